@@ -0,0 +1,133 @@
+package wsjson
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const (
+	// Authenticates a connection in-band, for peers that can't send credentials on the upgrade request
+	MethodAuthenticate = "rpc.authenticate"
+)
+
+var (
+	// Returned by authenticators when the credentials are required but not present
+	ErrNoCredentials = errors.New("No credentials provided")
+)
+
+// Identity of the peer of a connection
+type Principal interface {
+	Id() string
+}
+
+// Authenticates the upgrade request before the connection is established
+type Authenticator interface {
+	// Returns a nil principal when the request has no credentials and they are optional,
+	// an error rejects the connection
+	Authenticate(r *http.Request) (Principal, error)
+}
+
+// Authenticators implementing this interface allow in-band authentication using MethodAuthenticate
+type TokenAuthenticator interface {
+	AuthenticateToken(ctx context.Context, token string) (Principal, error)
+}
+
+// Validates a token returning the principal it belongs to
+type TokenValidator func(ctx context.Context, token string) (Principal, error)
+
+// Authenticates connections with a token, the token is taken from
+// the "Authorization: Bearer" header, a cookie or a query parameter
+type BearerAuthenticator struct {
+	Validate TokenValidator
+	// Name of the cookie with the token, cookies are not checked if empty
+	Cookie string
+	// Name of the query parameter with the token, the query is not checked if empty
+	QueryParam string
+	// Reject connections without credentials, otherwise they can authenticate in-band
+	Required bool
+}
+
+// Params of MethodAuthenticate
+type AuthenticateParams struct {
+	Token string `json:"token"`
+}
+
+// Result of MethodAuthenticate
+type AuthenticateResult struct {
+	Id string `json:"id"`
+}
+
+func (ba *BearerAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	token := ba.token(r)
+	if token == "" {
+		if ba.Required {
+			return nil, ErrNoCredentials
+		}
+		return nil, nil
+	}
+	return ba.Validate(r.Context(), token)
+}
+
+func (ba *BearerAuthenticator) AuthenticateToken(ctx context.Context, token string) (Principal, error) {
+	return ba.Validate(ctx, token)
+}
+
+// Get the token from the request
+func (ba *BearerAuthenticator) token(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+
+	if ba.Cookie != "" {
+		if cookie, err := r.Cookie(ba.Cookie); err == nil && cookie.Value != "" {
+			return cookie.Value
+		}
+	}
+
+	if ba.QueryParam != "" {
+		return r.URL.Query().Get(ba.QueryParam)
+	}
+	return ""
+}
+
+type principalCtxKey struct{}
+
+// PrincipalFromContext returns the principal of the connection, nil if not authenticated.
+// It can be used with the context of a call and with the context of the upgrade request
+func PrincipalFromContext(ctx context.Context) Principal {
+	if principal, ok := ctx.Value(principalCtxKey{}).(Principal); ok {
+		return principal
+	}
+	if client := ClientFromContext(ctx); client != nil {
+		return client.Principal()
+	}
+	return nil
+}
+
+func withPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalCtxKey{}, principal)
+}
+
+// Handles MethodAuthenticate calls
+func (wsj *WsJson) authenticateInBand(ctx context.Context, params AuthenticateParams) (*AuthenticateResult, error) {
+	tokenAuth, ok := wsj.authenticator.(TokenAuthenticator)
+	if !ok {
+		return nil, NewError(ErrorMethodNotFound, "In-band authentication is not available")
+	}
+
+	client := ClientFromContext(ctx)
+	if client == nil {
+		return nil, NewError(ErrorInternalError, "No connection available")
+	}
+
+	principal, err := tokenAuth.AuthenticateToken(ctx, params.Token)
+	if err != nil || principal == nil {
+		return nil, NewError(ErrorUnauthenticated, "Invalid credentials")
+	}
+
+	client.SetPrincipal(principal)
+	return &AuthenticateResult{Id: principal.Id()}, nil
+}
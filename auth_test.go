@@ -0,0 +1,167 @@
+package wsjson
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type testPrincipal string
+
+func (p testPrincipal) Id() string {
+	return string(p)
+}
+
+func validateToken(ctx context.Context, token string) (Principal, error) {
+	if !strings.HasPrefix(token, "valid-") {
+		return nil, errors.New("Invalid token")
+	}
+	return testPrincipal(strings.TrimPrefix(token, "valid-")), nil
+}
+
+type WhoAmIService struct{}
+
+func (*WhoAmIService) ApiWhoAmI(ctx context.Context) (string, error) {
+	principal := PrincipalFromContext(ctx)
+	if principal == nil {
+		return "anonymous", nil
+	}
+	return principal.Id(), nil
+}
+
+// Start a server for the handler, the server is closed when the test ends
+func startServer(t *testing.T, wsj *WsJson) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(wsj.Handle))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Open a websocket connection to a test server
+func dialServer(server *httptest.Server, path string, header http.Header) (*websocket.Conn, *http.Response, error) {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + path
+	return websocket.DefaultDialer.Dial(url, header)
+}
+
+// Send a request and read its response
+func roundTrip(t *testing.T, conn *websocket.Conn, request string) *Response {
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(request)); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var resp Response
+	if err := conn.ReadJSON(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return &resp
+}
+
+func TestAuthentication(t *testing.T) {
+	wsj := &WsJson{}
+	wsj.SetAuthenticator(&BearerAuthenticator{
+		Validate:   validateToken,
+		Cookie:     "session",
+		QueryParam: "token",
+	})
+
+	var factoryPrincipal Principal
+	wsj.SetApiFactory(func(w http.ResponseWriter, r *http.Request) []interface{} {
+		factoryPrincipal = PrincipalFromContext(r.Context())
+		return []interface{}{&WhoAmIService{}}
+	})
+	server := startServer(t, wsj)
+	whoAmI := `{"jsonrpc": "2.0", "method": "WhoAmIService.WhoAmI", "id": 1}`
+
+	var table = []struct {
+		path   string
+		header http.Header
+		id     string
+	}{
+		{"/", http.Header{"Authorization": {"Bearer valid-header"}}, "header"},
+		{"/", http.Header{"Cookie": {"session=valid-cookie"}}, "cookie"},
+		{"/?token=valid-query", nil, "query"},
+		{"/", nil, "anonymous"},
+	}
+
+	for _, row := range table {
+		factoryPrincipal = nil
+		conn, _, err := dialServer(server, row.path, row.header)
+		if err != nil {
+			t.Fatalf("Error connecting with %s: %v", row.id, err)
+		}
+
+		resp := roundTrip(t, conn, whoAmI)
+		if resp.Err != nil || resp.Result != row.id {
+			t.Errorf("Invalid principal, expected: %s, got: %+v", row.id, resp)
+		}
+
+		if row.id != "anonymous" && (factoryPrincipal == nil || factoryPrincipal.Id() != row.id) {
+			t.Errorf("Principal should be available to the api factory, expected: %s, got: %v", row.id, factoryPrincipal)
+		}
+		conn.Close()
+	}
+
+	// invalid credentials are rejected before the upgrade
+	lc := startLogCapture()
+	_, httpResp, err := dialServer(server, "/", http.Header{"Authorization": {"Bearer invalid"}})
+	lc.stop()
+	if err == nil || httpResp == nil || httpResp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Connection with invalid credentials should be rejected, got: %v", err)
+	}
+	if !lc.contains("Authentication failed: Invalid token") {
+		t.Errorf("Authentication error should be logged: %v", lc.buffer)
+	}
+}
+
+func TestInBandAuthentication(t *testing.T) {
+	wsj := &WsJson{}
+	wsj.SetAuthenticator(&BearerAuthenticator{Validate: validateToken})
+	// every method requires authentication but the builtins
+	wsj.SetAccessPolicy(RolePolicy{"*": {}})
+	wsj.AddService(&WhoAmIService{})
+	server := startServer(t, wsj)
+
+	conn, _, err := dialServer(server, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	resp := roundTrip(t, conn, `{"jsonrpc": "2.0", "method": "WhoAmIService.WhoAmI", "id": 1}`)
+	if resp.Err == nil || resp.Err.Code != ErrorUnauthenticated {
+		t.Errorf("Methods should require authentication: %+v", resp)
+	}
+	resp = roundTrip(t, conn, `{"jsonrpc": "2.0", "method": "rpc.methods", "id": 1}`)
+	if resp.Err != nil {
+		t.Errorf("Methods should be listed before authenticating: %+v", resp.Err)
+	}
+
+	resp = roundTrip(t, conn, `{"jsonrpc": "2.0", "method": "rpc.authenticate", "params": {"token": "invalid"}, "id": 1}`)
+	if resp.Err == nil || resp.Err.Code != ErrorUnauthenticated {
+		t.Errorf("Invalid token should be rejected: %+v", resp)
+	}
+
+	resp = roundTrip(t, conn, `{"jsonrpc": "2.0", "method": "rpc.authenticate", "params": {"token": "valid-inband"}, "id": 2}`)
+	if resp.Err != nil {
+		t.Fatalf("Valid token should be accepted: %+v", resp.Err)
+	}
+
+	resp = roundTrip(t, conn, `{"jsonrpc": "2.0", "method": "WhoAmIService.WhoAmI", "id": 3}`)
+	if resp.Result != "inband" {
+		t.Errorf("Invalid principal after in-band authentication: %+v", resp)
+	}
+
+	// required credentials
+	wsj.SetAuthenticator(&BearerAuthenticator{Validate: validateToken, Required: true})
+	lc := startLogCapture()
+	_, httpResp, err := dialServer(server, "/", nil)
+	lc.stop()
+	if err == nil || httpResp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Connection without credentials should be rejected, got: %v", err)
+	}
+}
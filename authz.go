@@ -0,0 +1,113 @@
+package wsjson
+
+import (
+	"context"
+	"strings"
+)
+
+// Principals implementing this interface can be authorized by role
+type RoleProvider interface {
+	Roles() []string
+}
+
+// Services must implement this interface to restrict their methods to some roles.
+// The keys are the exposed method names, "*" applies to the methods without an entry.
+// A caller needs any of the roles listed, an empty list only requires authentication
+type MethodRolesProvider interface {
+	WsRoles() map[string][]string
+}
+
+// Decides which methods can be called on the connections of a WsJson
+type AccessPolicy interface {
+	// Whether the principal, nil if not authenticated, can call a method by its full name
+	Allow(principal Principal, method string) bool
+}
+
+// An AccessPolicy with the roles required by method name. Keys ending with "*" match
+// all the methods starting with the rest of the key, the longest key matching is used.
+// The builtin "rpc." methods are only matched by their own name, so anonymous clients can
+// still authenticate in-band. Methods not matched by any key can be called by anyone
+type RolePolicy map[string][]string
+
+func (rp RolePolicy) Allow(principal Principal, method string) bool {
+	roles, ok := rp[method]
+	if !ok && !isBuiltin(method) {
+		matched := -1
+		for pattern, patternRoles := range rp {
+			prefix := strings.TrimSuffix(pattern, "*")
+			if prefix == pattern || !strings.HasPrefix(method, prefix) || len(prefix) <= matched {
+				continue
+			}
+			roles, ok, matched = patternRoles, true, len(prefix)
+		}
+	}
+
+	if !ok {
+		return true
+	}
+	return hasAnyRole(principal, roles)
+}
+
+// Whether the principal has any of the roles, an empty list only requires a principal
+func hasAnyRole(principal Principal, roles []string) bool {
+	if principal == nil {
+		return false
+	}
+	if len(roles) == 0 {
+		return true
+	}
+
+	roleProv, ok := principal.(RoleProvider)
+	if !ok {
+		return false
+	}
+	for _, role := range roleProv.Roles() {
+		for _, required := range roles {
+			if role == required {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Roles required by a method of the service, nil if there are no restrictions
+func (serv *service) methodRoles(name string) []string {
+	if serv.roles == nil {
+		return nil
+	}
+	roles, ok := serv.roles[name]
+	if !ok {
+		roles, ok = serv.roles["*"]
+	}
+	if ok && roles == nil {
+		// present without roles, authentication is still required
+		return []string{}
+	}
+	return roles
+}
+
+// Whether the principal can call a method, roles are the ones required by its service
+func (m *serviceManager) allowed(principal Principal, name string, roles []string) bool {
+	if roles != nil && !hasAnyRole(principal, roles) {
+		return false
+	}
+	return m.policy == nil || m.policy.Allow(principal, name)
+}
+
+// Check the caller can use the method, must be done before decoding its params
+func (m *serviceManager) authorize(ctx context.Context, name string, method methodHandler) error {
+	var roles []string
+	if bm, ok := method.(boundMethod); ok {
+		roles = bm.roles
+	}
+
+	principal := PrincipalFromContext(ctx)
+	if m.allowed(principal, name, roles) {
+		return nil
+	}
+	if principal == nil {
+		return NewError(ErrorUnauthenticated, "Authentication required to call %s", name)
+	}
+	return NewError(ErrorForbidden, "Not allowed to call %s", name)
+}
@@ -0,0 +1,184 @@
+package wsjson
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type rolesPrincipal struct {
+	id    string
+	roles []string
+}
+
+func (p *rolesPrincipal) Id() string {
+	return p.id
+}
+
+func (p *rolesPrincipal) Roles() []string {
+	return p.roles
+}
+
+// Service restricting its methods by role
+type ReportsService struct{}
+
+func (*ReportsService) WsRoles() map[string][]string {
+	return map[string][]string{
+		"Delete": {"admin"},
+		"*":      nil,
+	}
+}
+
+func (*ReportsService) ApiList() ([]string, error) {
+	return []string{"q1", "q2"}, nil
+}
+
+func (rs *ReportsService) ApiDelete(name string) (bool, error) {
+	return true, nil
+}
+
+// Records when params are decoded
+var spyDecoded bool
+
+type decodeSpy struct{}
+
+func (ds *decodeSpy) UnmarshalJSON([]byte) error {
+	spyDecoded = true
+	return nil
+}
+
+func TestRolePolicy(t *testing.T) {
+	policy := RolePolicy{
+		"admin.*":          {"admin"},
+		"admin.users.List": {"admin", "support"},
+		"billing.*":        {},
+		"rpc.*":            {"admin"},
+	}
+
+	anonymous := Principal(nil)
+	user := &rolesPrincipal{"ann", []string{"user"}}
+	support := &rolesPrincipal{"sue", []string{"support"}}
+	admin := &rolesPrincipal{"al", []string{"user", "admin"}}
+	noRoles := testPrincipal("nora")
+
+	var table = []struct {
+		principal Principal
+		method    string
+		allowed   bool
+	}{
+		{anonymous, "public.Get", true},
+		{anonymous, "admin.users.Delete", false},
+		{user, "admin.users.Delete", false},
+		{admin, "admin.users.Delete", true},
+		{support, "admin.users.Delete", false},
+		{support, "admin.users.List", true},
+		{anonymous, "billing.Get", false},
+		{noRoles, "billing.Get", true},
+		{noRoles, "admin.users.List", false},
+		{anonymous, "rpc.methods", true},
+		{anonymous, "rpc.authenticate", true},
+	}
+
+	for _, row := range table {
+		if allowed := policy.Allow(row.principal, row.method); allowed != row.allowed {
+			t.Errorf("Invalid access for %v to %s, expected: %t, got: %t", row.principal, row.method, row.allowed, allowed)
+		}
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	wsj := &WsJson{}
+	err := Register(wsj, "spy.decode", func(ctx context.Context, p *decodeSpy) (bool, error) {
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsj.SetAccessPolicy(RolePolicy{"SimpleService.Double": {"admin"}, "spy.*": {"admin"}})
+
+	client, err := newWsJsonClient(wsj.newServiceManager(), nil, []interface{}{&ReportsService{}, &SimpleService{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var table = []struct {
+		principal Principal
+		msg       string
+		errCode   int
+	}{
+		{nil, `{"jsonrpc": "2.0", "method": "ReportsService.List", "id": 1}`, ErrorUnauthenticated},
+		{nil, `{"jsonrpc": "2.0", "method": "SimpleService.Echo", "params": ["hi"], "id": 1}`, 0},
+		{nil, `{"jsonrpc": "2.0", "method": "SimpleService.Double", "params": [1, "a", 1, true], "id": 1}`, ErrorUnauthenticated},
+		{testPrincipal("nora"), `{"jsonrpc": "2.0", "method": "ReportsService.List", "id": 1}`, 0},
+		{testPrincipal("nora"), `{"jsonrpc": "2.0", "method": "ReportsService.Delete", "params": ["q1"], "id": 1}`, ErrorForbidden},
+		{&rolesPrincipal{"al", []string{"admin"}}, `{"jsonrpc": "2.0", "method": "ReportsService.Delete", "params": ["q1"], "id": 1}`, 0},
+		{testPrincipal("nora"), `{"jsonrpc": "2.0", "method": "SimpleService.Double", "params": [1, "a", 1, true], "id": 1}`, ErrorForbidden},
+		{testPrincipal("nora"), `{"jsonrpc": "2.0", "method": "spy.decode", "params": {}, "id": 1}`, ErrorForbidden},
+	}
+
+	for _, row := range table {
+		client.SetPrincipal(row.principal)
+		resp := client.handleMessage(strings.NewReader(row.msg))
+		if row.errCode == 0 {
+			if resp.Err != nil {
+				t.Errorf("No error expected for %v calling '%s', got: %+v", row.principal, row.msg, resp.Err)
+			}
+		} else if resp.Err == nil || resp.Err.Code != row.errCode {
+			t.Errorf("Invalid error for %v calling '%s', expected: %d, got: %+v", row.principal, row.msg, row.errCode, resp.Err)
+		}
+	}
+
+	// params are not decoded for unauthorized calls
+	if spyDecoded {
+		t.Error("Params should not be decoded when the call is not allowed")
+	}
+
+	// discovery only lists the methods the caller can use
+	var discovery = []struct {
+		principal Principal
+		methods   []string
+	}{
+		{nil, []string{"SimpleService.AllTypesPtr", "SimpleService.AnArray", "SimpleService.AnObject",
+			"SimpleService.AnObjectPtr", "SimpleService.Echo", "SimpleService.Event", "rpc.authenticate", "rpc.methods"}},
+		{&rolesPrincipal{"al", []string{"admin"}}, []string{"ReportsService.Delete", "ReportsService.List",
+			"SimpleService.AllTypesPtr", "SimpleService.AnArray", "SimpleService.AnObject", "SimpleService.AnObjectPtr",
+			"SimpleService.Double", "SimpleService.Echo", "SimpleService.Event", "rpc.authenticate", "rpc.methods", "spy.decode"}},
+	}
+	for _, row := range discovery {
+		client.SetPrincipal(row.principal)
+		resp := client.handleMessage(strings.NewReader(`{"jsonrpc": "2.0", "method": "rpc.methods", "id": 1}`))
+		if !reflect.DeepEqual(resp.Result, row.methods) {
+			t.Errorf("Invalid methods for %v, expected: %v, got: %v", row.principal, row.methods, resp.Result)
+		}
+	}
+}
+
+// The policy set before the naming options doesn't fix the names of the globals
+func TestAccessPolicyFirst(t *testing.T) {
+	wsj := &WsJson{}
+	wsj.SetAccessPolicy(RolePolicy{"SimpleService/double": {"admin"}})
+	wsj.SetMethodSeparator("/")
+	wsj.SetNameMapper(SnakeCase)
+	if err := wsj.AddService(&SimpleService{}); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := newWsJsonClient(wsj.newServiceManager(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var table = []struct {
+		msg     string
+		errCode int
+	}{
+		{`{"jsonrpc": "2.0", "method": "SimpleService/echo", "params": ["hi"], "id": 1}`, 0},
+		{`{"jsonrpc": "2.0", "method": "SimpleService/double", "params": [1, "a", 1, true], "id": 1}`, ErrorUnauthenticated},
+	}
+	for _, row := range table {
+		resp := client.handleMessage(strings.NewReader(row.msg))
+		if (row.errCode == 0 && resp.Err != nil) || (row.errCode != 0 && (resp.Err == nil || resp.Err.Code != row.errCode)) {
+			t.Errorf("Invalid response for '%s', expected error: %d, got: %+v", row.msg, row.errCode, resp.Err)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package wsjson
+
+import (
+	"context"
+	"strings"
+)
+
+const (
+	// Prefix of the methods provided by wsjson itself
+	builtinPrefix = "rpc."
+
+	// Lists the methods available on the connection
+	MethodListMethods = "rpc.methods"
+
+	// Sent to the peer when the methods available on the connection change
+	EventMethodsChanged = "rpc.methodsChanged"
+)
+
+// Params of the EventMethodsChanged notification
+type MethodsChanged struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// Register the methods provided by wsjson itself
+func (wsj *WsJson) registerBuiltins(m *serviceManager) {
+	m.addHandler(MethodListMethods, &noParamsMethod[[]string]{fn: listMethods})
+	m.addHandler(MethodAuthenticate, &funcMethod[AuthenticateParams, *AuthenticateResult]{fn: wsj.authenticateInBand})
+}
+
+// Whether a method is provided by wsjson itself
+func isBuiltin(method string) bool {
+	return strings.HasPrefix(method, builtinPrefix)
+}
+
+func listMethods(ctx context.Context) ([]string, error) {
+	client := ClientFromContext(ctx)
+	if client == nil {
+		return nil, NewError(ErrorInternalError, "No connection available")
+	}
+	return client.manager.methodNames(client.Principal()), nil
+}
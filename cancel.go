@@ -0,0 +1,78 @@
+package wsjson
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	// Notification to cancel a call in progress
+	MethodCancelRequest = "$/cancelRequest"
+)
+
+// Params of MethodCancelRequest
+type CancelParams struct {
+	// Id of the call to cancel
+	Id interface{} `json:"id"`
+}
+
+// A call being handled, it can be cancelled by the peer
+type inFlightCall struct {
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+// Key of a request id in the calls in flight, numbers decoded by any codec match
+func callKey(id interface{}) interface{} {
+	if n, ok := requestId(id); ok {
+		return n
+	}
+	if s, ok := id.(string); ok {
+		return s
+	}
+	return fmt.Sprint(id)
+}
+
+// Register a call with the context it must use, the returned function must be called when it ends
+func (wsjc *WsJsonClient) startCall(ctx context.Context, id interface{}) (context.Context, func() bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	call := &inFlightCall{cancel: cancel}
+	key := callKey(id)
+
+	wsjc.inFlightMutex.Lock()
+	wsjc.inFlight[key] = call
+	wsjc.inFlightMutex.Unlock()
+
+	// returns whether the peer cancelled the call
+	return ctx, func() bool {
+		wsjc.inFlightMutex.Lock()
+		defer wsjc.inFlightMutex.Unlock()
+		if wsjc.inFlight[key] == call {
+			delete(wsjc.inFlight, key)
+		}
+		cancel()
+		return call.cancelled
+	}
+}
+
+// Handles MethodCancelRequest, unknown ids are ignored as the call may have finished
+func (wsjc *WsJsonClient) handleCancelRequest(request Request) {
+	var params CancelParams
+	if err := wsjc.codec().Unmarshal(request.Params, &params); err != nil || params.Id == nil {
+		return
+	}
+
+	wsjc.inFlightMutex.Lock()
+	defer wsjc.inFlightMutex.Unlock()
+	if call, ok := wsjc.inFlight[callKey(params.Id)]; ok {
+		call.cancelled = true
+		call.cancel()
+	}
+}
+
+// Ask the peer to cancel a call made, errors are ignored as the call may have finished
+func (wsjc *WsJsonClient) cancelCall(id int) {
+	if request, err := wsjc.newRequest(MethodCancelRequest, &CancelParams{Id: id}); err == nil {
+		wsjc.send(request)
+	}
+}
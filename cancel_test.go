@@ -0,0 +1,138 @@
+package wsjson
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type SlowService struct {
+	cancelled chan error
+}
+
+func (ss *SlowService) ApiWait(ctx context.Context) (bool, error) {
+	<-ctx.Done()
+	ss.cancelled <- ctx.Err()
+	return false, ctx.Err()
+}
+
+func (*SlowService) ApiQuick() (bool, error) {
+	return true, nil
+}
+
+func TestCancelRequest(t *testing.T) {
+	cancelled := make(chan error, 1)
+	wsj := &WsJson{}
+	wsj.AddService(&SlowService{cancelled})
+	server := startServer(t, wsj)
+
+	conn, _, err := dialServer(server, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var table = []struct {
+		id     string
+		cancel string
+	}{
+		{`7`, `7`},
+		{`"call-1"`, `"call-1"`},
+		{`8`, `8.0`},
+	}
+
+	for _, row := range table {
+		conn.WriteMessage(1, []byte(`{"jsonrpc": "2.0", "method": "SlowService.Wait", "id": `+row.id+`}`))
+		// unknown and finished calls are ignored
+		resp := roundTrip(t, conn, `{"jsonrpc": "2.0", "method": "SlowService.Quick", "id": 100}`)
+		conn.WriteMessage(1, []byte(`{"jsonrpc": "2.0", "method": "$/cancelRequest", "params": {"id": 100}}`))
+		if resp.Err != nil {
+			t.Fatalf("Quick call failed: %+v", resp.Err)
+		}
+
+		resp = roundTrip(t, conn, `{"jsonrpc": "2.0", "method": "$/cancelRequest", "params": {"id": `+row.cancel+`}}`)
+		if resp.Err == nil || resp.Err.Code != ErrorRequestCancelled {
+			t.Errorf("Call %s should be cancelled: %+v", row.id, resp)
+		}
+		select {
+		case err := <-cancelled:
+			if err != context.Canceled {
+				t.Errorf("Invalid context error: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Context of call %s should be cancelled", row.id)
+		}
+	}
+}
+
+func TestCancelStream(t *testing.T) {
+	stopped := make(chan error, 1)
+	wsj := &WsJson{}
+	wsj.AddService(&LogService{stopped})
+	server := startServer(t, wsj)
+
+	conn, _, err := dialServer(server, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.WriteMessage(1, []byte(`{"jsonrpc": "2.0", "method": "LogService.Follow", "id": 1}`))
+	conn.WriteMessage(1, []byte(`{"jsonrpc": "2.0", "method": "$/cancelRequest", "params": {"id": 1}}`))
+
+	select {
+	case err := <-stopped:
+		if err != context.Canceled {
+			t.Errorf("Invalid error after cancelling: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Stream should stop when cancelled")
+	}
+
+	for {
+		var resp Response
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if err := conn.ReadJSON(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Id != nil {
+			if resp.Err == nil || resp.Err.Code != ErrorRequestCancelled {
+				t.Errorf("Stream should answer as cancelled: %+v", resp)
+			}
+			break
+		}
+	}
+}
+
+func TestCallCancel(t *testing.T) {
+	cancelled := make(chan error, 1)
+	wsj := &WsJson{}
+	wsj.AddService(&SlowService{cancelled})
+	server := startServer(t, wsj)
+
+	conn, _, err := dialServer(server, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := newWsJsonClient(newServiceManager(), conn, []interface{}{&WhoAmIService{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.serve()
+	defer client.close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := client.Call(ctx, "SlowService.Wait", nil, nil); err != context.DeadlineExceeded {
+		t.Errorf("Invalid error of the cancelled call: %v", err)
+	}
+
+	select {
+	case err := <-cancelled:
+		if err != context.Canceled {
+			t.Errorf("Invalid context error on the server: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("The call should be cancelled on the server")
+	}
+}
@@ -1,11 +1,13 @@
 package wsjson
 
 import (
-	"encoding/json"
+	"bytes"
+	"context"
 	"errors"
 	"io"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -25,35 +27,108 @@ const (
 	maxMessageSize = 4096
 )
 
+var (
+	// Returned to pending calls when the connection is closed
+	ErrConnectionClosed = errors.New("Connection closed")
+)
+
+type clientCtxKey struct{}
+
+// ClientFromContext returns the connection serving a call,
+// it is available in the context received by methods and registered functions
+func ClientFromContext(ctx context.Context) *WsJsonClient {
+	client, _ := ctx.Value(clientCtxKey{}).(*WsJsonClient)
+	return client
+}
+
+// Response to a call made to the peer
+type callResult struct {
+	result RawMessage
+	err    *Error
+}
+
 type WsJsonClient struct {
-	manager        *serviceManager
+	manager *serviceManager
+	// current connection, replaced when a session is resumed
 	conn           *websocket.Conn
+	connMutex      sync.Mutex
 	output         chan interface{}
 	resultsMutex   sync.RWMutex
-	pendingResults map[int]chan<- json.RawMessage
-	idSeq          <-chan int
+	pendingResults map[int]chan<- *callResult
+	// handlers of the notifications about the calls made
+	pendingHandlers map[int]*callHandlers
+	idSeq           int64
+	closed          bool
+	closeOnce       sync.Once
+	done            chan struct{}
+	// context of the calls received, cancelled when the connection is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	principal      Principal
+	principalMutex sync.RWMutex
+
+	// limits the calls received, may be nil
+	limiter *connLimiter
+	// calls received being handled, by id
+	inFlight      map[interface{}]*inFlightCall
+	inFlightMutex sync.Mutex
+
+	// negotiated with the peer, nil if no protocols are configured
+	protocol *Protocol
+	// nil if compression is not negotiated
+	compression *connCompression
+
+	// receives the measures, may be nil
+	metrics Metrics
+	// creates the spans of the calls, may be nil
+	tracer *Tracer
+	// records the frames, may be nil
+	recorder *Recorder
+	connId   string
+	// handles the notifications to methods not available, may be nil
+	notificationFallback NotificationFallback
+	// called once the connection is closed, may be nil
+	onClose func()
+	// session served, nil if sessions are not enabled
+	session *connSession
+	// session of the server dialed, nil if the protocol doesn't have FeatureSessions
+	tracker *sessionTracker
+	// called when the connection is lost instead of closing the client, the connection
+	// is replaced with resumeConn. Set by ReconnectingClient, may be nil
+	onConnLost func(err error)
+	// answers an HTTP POST request, nothing can be sent to the peer but the response
+	overHTTP bool
+	// replays a recording, there is no peer to answer the calls
+	replaying bool
 }
 
-func newWsJsonClient(conn *websocket.Conn, services []interface{}) (*WsJsonClient, error) {
-	if len(services) == 0 {
+// Queued to close the connection after the messages before it are sent
+type closeFrame struct {
+	code int
+	text string
+}
+
+// Queued to know when the messages before it are sent
+type flushRequest chan struct{}
+
+func newWsJsonClient(manager *serviceManager, conn *websocket.Conn, services []interface{}) (*WsJsonClient, error) {
+	if len(services) == 0 && !manager.hasMethods() {
 		return nil, errors.New("At least one service is required")
 	}
 
-	// Request Id sequence
-	idSeq := make(chan int)
-	go func() {
-		for i := 1; ; {
-			idSeq <- i
-		}
-	}()
-
+	ctx, cancel := context.WithCancel(context.Background())
 	client := &WsJsonClient{
-		manager:        newServiceManager(),
-		conn:           conn,
-		output:         make(chan interface{}, 10),
-		pendingResults: make(map[int]chan<- json.RawMessage),
-		idSeq:          idSeq,
+		manager:         manager,
+		conn:            conn,
+		output:          make(chan interface{}, 10),
+		pendingResults:  make(map[int]chan<- *callResult),
+		pendingHandlers: make(map[int]*callHandlers),
+		inFlight:        make(map[interface{}]*inFlightCall),
+		done:            make(chan struct{}),
+		cancel:          cancel,
 	}
+	client.ctx = context.WithValue(ctx, clientCtxKey{}, client)
 
 	for _, serv := range services {
 		err := client.manager.addService(serv)
@@ -65,20 +140,21 @@ func newWsJsonClient(conn *websocket.Conn, services []interface{}) (*WsJsonClien
 	return client, nil
 }
 
-func (wsjc *WsJsonClient) readLoop() {
+func (wsjc *WsJsonClient) readLoop(conn *websocket.Conn) {
+	var err error
 	defer func() {
-		//c.hub.unregister <- c
-		wsjc.conn.Close()
+		wsjc.connLost(conn, err)
 	}()
-	wsjc.conn.SetReadLimit(maxMessageSize)
-	wsjc.conn.SetReadDeadline(time.Now().Add(pongWait))
-	wsjc.conn.SetPongHandler(func(string) error {
-		wsjc.conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(pongWait))
 		return nil
 	})
 
 	for {
-		_, message, err := wsjc.conn.NextReader()
+		var message []byte
+		_, message, err = conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway) {
 				log.Printf("error: %v", err)
@@ -86,32 +162,247 @@ func (wsjc *WsJsonClient) readLoop() {
 			break
 		}
 
-		go wsjc.processMessage(message)
+		if wsjc.metrics != nil {
+			wsjc.metrics.MessageReceived(len(message))
+		}
+		wsjc.recordFrame(DirectionIn, message)
+		wsjc.processMessage(bytes.NewReader(message))
+	}
+}
+
+// Sends queued messages and pings to the peer, sessions keep buffering them while disconnected
+func (wsjc *WsJsonClient) writeLoop() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		wsjc.close()
+	}()
+
+	if wsjc.session != nil {
+		wsjc.startSession(false)
+	}
+	for {
+		select {
+		case message := <-wsjc.output:
+			switch message := message.(type) {
+			case flushRequest:
+				close(message)
+				continue
+			case closeFrame:
+				if conn := wsjc.currentConn(); conn != nil {
+					data := websocket.FormatCloseMessage(message.code, message.text)
+					conn.WriteControl(websocket.CloseMessage, data, time.Now().Add(writeWait))
+				}
+				return
+			case resumeConn:
+				wsjc.resume(message)
+				continue
+			}
+
+			var seq int64
+			if wsjc.session != nil {
+				seq = wsjc.session.nextSeq()
+				setSeq(message, seq)
+			}
+			data, err := wsjc.codec().Marshal(message)
+			if err != nil {
+				log.Printf("Error encoding message: %v", err)
+				continue
+			}
+			if wsjc.session != nil {
+				wsjc.session.buffered(seq, data)
+			}
+			if err := wsjc.write(data); err != nil && !wsjc.reconnectable() {
+				return
+			}
+		case <-ticker.C:
+			conn := wsjc.currentConn()
+			if conn == nil {
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				if !wsjc.reconnectable() {
+					return
+				}
+				wsjc.connLost(conn, err)
+			}
+		case <-wsjc.done:
+			return
+		}
+	}
+}
+
+// Write a message to the current connection, messages of sessions without connection are
+// only buffered, and a failed write loses the connection
+func (wsjc *WsJsonClient) write(data []byte) error {
+	conn := wsjc.currentConn()
+	if conn == nil {
+		return nil
+	}
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := wsjc.writeMessage(conn, wsjc.codec().MessageType(), data); err != nil {
+		log.Printf("Error writing message: %v", err)
+		if wsjc.reconnectable() {
+			wsjc.connLost(conn, err)
+		}
+		return err
+	}
+	if wsjc.metrics != nil {
+		wsjc.metrics.MessageSent(len(data))
+	}
+	wsjc.recordFrame(DirectionOut, data)
+	return nil
+}
+
+func (wsjc *WsJsonClient) currentConn() *websocket.Conn {
+	wsjc.connMutex.Lock()
+	defer wsjc.connMutex.Unlock()
+	return wsjc.conn
+}
+
+// Whether a lost connection can be replaced, by resuming its session or reconnecting
+func (wsjc *WsJsonClient) reconnectable() bool {
+	return wsjc.session != nil || wsjc.onConnLost != nil
+}
+
+// Handle the end of a connection, sessions wait for their client to resume them
+// unless it closed the connection normally, reconnecting clients dial again
+func (wsjc *WsJsonClient) connLost(conn *websocket.Conn, err error) {
+	normalClosure := websocket.IsCloseError(err, websocket.CloseNormalClosure)
+	if !wsjc.reconnectable() || (wsjc.session != nil && normalClosure) {
+		wsjc.close()
+		return
+	}
+
+	wsjc.connMutex.Lock()
+	defer wsjc.connMutex.Unlock()
+	if wsjc.conn != conn {
+		// replaced by a resumed connection, or closed
+		return
+	}
+	conn.Close()
+	wsjc.conn = nil
+	if wsjc.metrics != nil {
+		wsjc.metrics.ConnectionClosed()
+	}
+	if wsjc.session != nil {
+		wsjc.session.detach(wsjc.close)
+	} else {
+		go wsjc.onConnLost(err)
+	}
+}
+
+// Close the connection and fail all the pending calls
+func (wsjc *WsJsonClient) close() {
+	wsjc.closeOnce.Do(func() {
+		close(wsjc.done)
+		wsjc.cancel()
+		wsjc.connMutex.Lock()
+		if wsjc.conn != nil {
+			wsjc.conn.Close()
+			wsjc.conn = nil
+			if wsjc.metrics != nil {
+				wsjc.metrics.ConnectionClosed()
+			}
+		}
+		wsjc.connMutex.Unlock()
+		if wsjc.onClose != nil {
+			wsjc.onClose()
+		}
+
+		wsjc.resultsMutex.Lock()
+		defer wsjc.resultsMutex.Unlock()
+		wsjc.closed = true
+		wsjc.closePendingResults()
+	})
+}
+
+// Fail the calls made waiting for their response with ErrConnectionClosed,
+// resultsMutex must be held
+func (wsjc *WsJsonClient) closePendingResults() {
+	for id, ch := range wsjc.pendingResults {
+		wsjc.pendingHandlers[id].endSpan(NewError(ErrorInternalError, ErrConnectionClosed.Error()))
+		close(ch)
+		delete(wsjc.pendingResults, id)
+		delete(wsjc.pendingHandlers, id)
 	}
 }
 
+// Queue a message to be sent to the peer
+func (wsjc *WsJsonClient) send(message interface{}) error {
+	return wsjc.sendContext(context.Background(), message)
+}
+
+// Handles a message received, calls are handled on their own goroutine
+// while results and notifications about the calls made are handled in order
 func (wsjc *WsJsonClient) processMessage(message io.Reader) {
-	response := wsjc.handleMessage(message)
+	request, errResponse := wsjc.decodeMessage(message)
+	if errResponse != nil {
+		wsjc.send(errResponse)
+		return
+	}
+
+	if wsjc.tracker != nil && request.Meta != nil && request.Meta.Seq > 0 {
+		received, ack := wsjc.tracker.receive(request.Meta.Seq)
+		if !received {
+			// replayed by the server after resuming
+			return
+		}
+		if ack {
+			wsjc.SendEvent(MethodAck, &AckParams{Seq: request.Meta.Seq})
+		}
+	}
+
+	if request.isResult() || isCallNotification(request.Method) {
+		wsjc.respond(wsjc.dispatchMessage(request))
+		return
+	}
+	// checked before starting its goroutine, calls over the limits don't start any
+	if response, limited := wsjc.limitCall(*request); limited {
+		wsjc.respond(response)
+		return
+	}
+	// registered before reading the next message, it may cancel the call
+	ctx, endCall := wsjc.beginRequest(*request)
+	go func() {
+		wsjc.respond(wsjc.callRequest(ctx, endCall, *request))
+	}()
+}
+
+func (wsjc *WsJsonClient) respond(response *Response) {
 	if response != nil {
-		wsjc.output <- response
+		wsjc.send(response)
 	}
 }
 
 // Handles a request received from the client
 // returns a Response if the request is a method call
 func (wsjc *WsJsonClient) handleMessage(reader io.Reader) *Response {
+	request, errResponse := wsjc.decodeMessage(reader)
+	if errResponse != nil {
+		return errResponse
+	}
+	return wsjc.dispatchMessage(request)
+}
+
+// Decode and validate a message, returns the response if it is invalid
+func (wsjc *WsJsonClient) decodeMessage(reader io.Reader) (*Request, *Response) {
 	var request Request
-	err := json.NewDecoder(reader).Decode(&request)
+	data, err := io.ReadAll(reader)
+	if err == nil {
+		err = wsjc.codec().Unmarshal(data, &request)
+	}
 	if err != nil {
-		return NewErrorResponse(NewError(ErrorParse, "Parse Error"))
+		return nil, NewErrorResponse(NewError(ErrorParse, "Parse Error"))
 	}
 
 	if request.Version != JSONRPCVersion {
-		return request.makeError(ErrorInvalidRequest, "Invalid JSONRPC Version")
+		return nil, request.makeError(ErrorInvalidRequest, "Invalid JSONRPC Version")
 	}
 
 	if request.Result != nil && request.Params != nil {
-		return request.makeError(ErrorInvalidRequest, "Message can't have both 'params' and 'result' present")
+		return nil, request.makeError(ErrorInvalidRequest, "Message can't have both 'params' and 'result' present")
 	}
 
 	/*
@@ -120,18 +411,80 @@ func (wsjc *WsJsonClient) handleMessage(reader io.Reader) *Response {
 		}
 	*/
 
-	if request.Result != nil {
-		return wsjc.handleResult(request)
-	} else {
-		return wsjc.handleRequest(request)
-	}
+	return &request, nil
+}
+
+// Notifications about calls in progress or the session, handled in order as they arrive
+func isCallNotification(method string) bool {
+	return method == MethodPartialResult || method == MethodCancelRequest || method == MethodProgress ||
+		method == MethodAck || method == MethodSession
+}
 
+func (wsjc *WsJsonClient) dispatchMessage(request *Request) *Response {
+	switch {
+	case request.isResult():
+		return wsjc.handleResult(*request)
+	case request.Method == MethodPartialResult:
+		wsjc.handlePartialResult(*request)
+		return nil
+	case request.Method == MethodCancelRequest:
+		wsjc.handleCancelRequest(*request)
+		return nil
+	case request.Method == MethodProgress:
+		wsjc.handleProgress(*request)
+		return nil
+	case request.Method == MethodAck:
+		wsjc.handleAck(*request)
+		return nil
+	case request.Method == MethodSession:
+		wsjc.handleSession(*request)
+		return nil
+	}
+	return wsjc.handleRequest(*request)
 }
 
 func (wsjc *WsJsonClient) handleRequest(request Request) *Response {
-	result, err := wsjc.manager.callMethod(request.Method, request.Params)
+	if response, limited := wsjc.limitCall(request); limited {
+		return response
+	}
+	ctx, endCall := wsjc.beginRequest(request)
+	return wsjc.callRequest(ctx, endCall, request)
+}
+
+// Context of a request, calls with an id can be cancelled until endCall is called
+func (wsjc *WsJsonClient) beginRequest(request Request) (context.Context, func() bool) {
+	ctx := withRequestId(wsjc.ctx, request.Id)
+	if request.Meta != nil && request.Meta.ProgressToken != nil {
+		ctx = withProgressToken(ctx, request.Meta.ProgressToken)
+	}
+	ctx = wsjc.startServerSpan(ctx, &request)
+	if request.Id == nil {
+		return ctx, func() bool { return false }
+	}
+	return wsjc.startCall(ctx, request.Id)
+}
+
+func (wsjc *WsJsonClient) callRequest(ctx context.Context, endCall func() bool, request Request) (response *Response) {
+	start := time.Now()
+	defer func() {
+		if endCall() {
+			response = request.makeError(ErrorRequestCancelled, "Request cancelled")
+		}
+		wsjc.callHandled(request.Method, response, start)
+		if response != nil {
+			SpanFromContext(ctx).endCall(response.Err)
+		} else {
+			SpanFromContext(ctx).End()
+		}
+	}()
+
+	result, err := wsjc.manager.callMethod(ctx, wsjc.codec(), request.Method, request.Params)
 	if err != nil {
 		if jsonError, ok := err.(*Error); ok {
+			if request.Id == nil && jsonError.Code == ErrorMethodNotFound && wsjc.notificationFallback != nil {
+				wsjc.notificationFallback(ctx, request.Method, request.Params)
+				return nil
+			}
 			response := NewErrorResponse(jsonError)
 			response.Id = request.Id
 			return response
@@ -140,15 +493,49 @@ func (wsjc *WsJsonClient) handleRequest(request Request) *Response {
 		}
 	}
 
-	if result != nil {
-		return &Response{
-			Version: JSONRPCVersion,
-			Result:  result,
-			Id:      request.Id,
-		}
-	} else {
+	if result == nil && request.Id == nil {
 		return nil
 	}
+	// calls are answered even without result, with null
+	return &Response{
+		Version: JSONRPCVersion,
+		Result:  result,
+		Id:      request.Id,
+	}
+}
+
+// Check a call against the rate limits, returns whether it is over them
+// and its response, nil for notifications
+func (wsjc *WsJsonClient) limitCall(request Request) (*Response, bool) {
+	if wsjc.limiter == nil {
+		return nil, false
+	}
+	limitErr, closeConn := wsjc.limiter.allow(request.Method, wsjc.Principal())
+	if limitErr == nil {
+		return nil, false
+	}
+	wsjc.callHandled(request.Method, NewErrorResponse(limitErr), time.Now())
+	return wsjc.rateLimited(request, limitErr, closeConn), true
+}
+
+// Reject a call over the rate limits, notifications are dropped
+func (wsjc *WsJsonClient) rateLimited(request Request, limitErr *Error, closeConn bool) *Response {
+	var response *Response
+	if request.Id != nil {
+		response = NewErrorResponse(limitErr)
+		response.Id = request.Id
+	}
+	if !closeConn || wsjc.overHTTP {
+		// there is no connection to close over HTTP POST
+		return response
+	}
+
+	log.Printf("Closing connection over the rate limits")
+	if response != nil {
+		wsjc.send(response)
+	}
+	wsjc.send(closeFrame{websocket.ClosePolicyViolation, limitErr.Message})
+	return nil
 }
 
 func (wsjc *WsJsonClient) handleResult(request Request) *Response {
@@ -158,85 +545,259 @@ func (wsjc *WsJsonClient) handleResult(request Request) *Response {
 		return nil
 	}
 
-	idFloat, ok := idRaw.(float64)
+	id, ok := requestId(idRaw)
 	if !ok {
 		log.Printf("Result id must be an integer: %v", idRaw)
 		return nil
 	}
 
-	id := int(idFloat)
-
-	ch := wsjc.removePendingResult(id)
+	ch := wsjc.removePendingResult(id, request.Err)
 	if ch == nil {
 		log.Printf("No previous request found for result.id:%d, request: '%s'", id, &request)
 	} else {
-		ch <- request.Result
+		ch <- &callResult{result: request.Result, err: request.Err}
 		close(ch)
 	}
 
 	return nil
 }
 
-func (wsjc *WsJsonClient) addPendingResult(id int, ch chan<- json.RawMessage) {
+func (wsjc *WsJsonClient) addPendingResult(id int, ch chan<- *callResult, handlers *callHandlers) error {
 	wsjc.resultsMutex.Lock()
 	defer wsjc.resultsMutex.Unlock()
+	if wsjc.closed {
+		return ErrConnectionClosed
+	}
 	wsjc.pendingResults[id] = ch
+	if handlers != nil {
+		wsjc.pendingHandlers[id] = handlers
+	}
+	return nil
 }
 
-func (wsjc *WsJsonClient) getPendingResult(id int) chan<- json.RawMessage {
+func (wsjc *WsJsonClient) getPendingResult(id int) chan<- *callResult {
 	wsjc.resultsMutex.RLock()
 	defer wsjc.resultsMutex.RUnlock()
 	return wsjc.pendingResults[id]
 }
 
-func (wsjc *WsJsonClient) removePendingResult(id int) chan<- json.RawMessage {
+// Remove a call made ending its span with err, nil if it succeeded
+func (wsjc *WsJsonClient) removePendingResult(id int, err *Error) chan<- *callResult {
 	wsjc.resultsMutex.Lock()
 	defer wsjc.resultsMutex.Unlock()
 	ch := wsjc.pendingResults[id]
 	if ch != nil {
 		delete(wsjc.pendingResults, id)
+		wsjc.pendingHandlers[id].endSpan(err)
 	}
+	delete(wsjc.pendingHandlers, id)
 	return ch
 }
 
+// Principal returns the identity of the peer, nil if not authenticated
+func (wsjc *WsJsonClient) Principal() Principal {
+	wsjc.principalMutex.RLock()
+	defer wsjc.principalMutex.RUnlock()
+	return wsjc.principal
+}
+
+// SetPrincipal changes the identity of the peer
+func (wsjc *WsJsonClient) SetPrincipal(principal Principal) {
+	wsjc.principalMutex.Lock()
+	defer wsjc.principalMutex.Unlock()
+	wsjc.principal = principal
+}
+
+// AddService registers a service on a live connection,
+// the peer is notified of the new methods
+func (wsjc *WsJsonClient) AddService(instance interface{}) error {
+	added, err := wsjc.manager.addServices(instance)
+	if err != nil {
+		return err
+	}
+	names := wsjc.manager.serviceMethodNames(added, wsjc.Principal())
+	return wsjc.notifyMethodsChanged(&MethodsChanged{Added: names})
+}
+
+// RemoveService removes a service, and its sub-services, from a live connection
+// using its full name. The peer is notified of the removed methods
+func (wsjc *WsJsonClient) RemoveService(name string) error {
+	removed, err := wsjc.manager.removeService(name)
+	if err != nil {
+		return err
+	}
+	names := wsjc.manager.serviceMethodNames(removed, wsjc.Principal())
+	return wsjc.notifyMethodsChanged(&MethodsChanged{Removed: names})
+}
+
+func (wsjc *WsJsonClient) notifyMethodsChanged(changes *MethodsChanged) error {
+	if len(changes.Added) == 0 && len(changes.Removed) == 0 || !wsjc.Protocol().Has(FeatureMethodsChanged) {
+		return nil
+	}
+	return wsjc.SendEvent(EventMethodsChanged, changes)
+}
+
 // CallMethod sends a JSON-RPC request to the peer.
-// Returns a channel where the result of the call will be sent when it arrives.
-// An error is returned if there is a problem marshalling the param to JSON
-func (wsjc *WsJsonClient) CallMethod(name string, params interface{}) (<-chan json.RawMessage, error) {
+// Returns a channel where the result of the call will be sent when it arrives,
+// encoded with the codec of the connection, see Decode.
+// An error is returned if there is a problem marshalling the param
+func (wsjc *WsJsonClient) CallMethod(name string, params interface{}) (<-chan RawMessage, error) {
 	return wsjc.SendMessage(name, params, true)
 }
 
+// Decode a result received from the peer with the codec of the connection
+func (wsjc *WsJsonClient) Decode(data RawMessage, v interface{}) error {
+	return wsjc.codec().Unmarshal(data, v)
+}
+
+// CallMethodProgress is CallMethod receiving the progress reported by the peer,
+// onProgress is called with each report in order until the result arrives
+func (wsjc *WsJsonClient) CallMethodProgress(name string, params interface{}, onProgress func(Progress)) (<-chan RawMessage, error) {
+	return wsjc.resultChannel(name, params, &callHandlers{onProgress: onProgress})
+}
+
+// Call sends a JSON-RPC request to the peer and waits for its response.
+// The result is decoded into result, which may be nil to discard it.
+// Errors sent by the peer are returned as *Error, see WithProgress to receive its progress
+func (wsjc *WsJsonClient) Call(ctx context.Context, name string, params interface{}, result interface{}) error {
+	var handlers *callHandlers
+	if onProgress := progressFromContext(ctx); onProgress != nil {
+		handlers = &callHandlers{onProgress: onProgress}
+	}
+	id, ch, err := wsjc.sendRequest(ctx, name, params, handlers)
+	if err != nil {
+		return err
+	}
+	return wsjc.waitResult(ctx, id, ch, result)
+}
+
+// Wait for the response of a call, the pending call is removed if the context is done first
+func (wsjc *WsJsonClient) waitResult(ctx context.Context, id int, ch <-chan *callResult, result interface{}) error {
+	select {
+	case res, ok := <-ch:
+		if !ok {
+			return ErrConnectionClosed
+		}
+		if res.err != nil {
+			return res.err
+		}
+		if result == nil || len(res.result) == 0 {
+			return nil
+		}
+		return wsjc.codec().Unmarshal(res.result, result)
+	case <-ctx.Done():
+		if wsjc.removePendingResult(id, NewError(ErrorRequestCancelled, ctx.Err().Error())) != nil {
+			wsjc.cancelCall(id)
+		}
+		return ctx.Err()
+	}
+}
+
 func (wsjc *WsJsonClient) SendEvent(name string, params interface{}) error {
 	_, err := wsjc.SendMessage(name, params, false)
 	return err
 }
 
 // Sends a JSON RPC message to the peer
-func (wsjc *WsJsonClient) SendMessage(name string, params interface{}, isMethod bool) (ch <-chan json.RawMessage, err error) {
-	rawParams, err := json.Marshal(params)
-	if err != nil {
+func (wsjc *WsJsonClient) SendMessage(name string, params interface{}, isMethod bool) (ch <-chan RawMessage, err error) {
+	if !isMethod {
+		var request *Request
+		request, err = wsjc.newRequest(name, params)
+		if err != nil {
+			return
+		}
+		err = wsjc.send(request)
 		return
 	}
 
-	request := &Request{
-		Version: JSONRPCVersion,
-		Method:  name,
-		Params:  rawParams,
+	return wsjc.resultChannel(name, params, nil)
+}
+
+// Sends a request, the channel receives its result unless it fails
+func (wsjc *WsJsonClient) resultChannel(name string, params interface{}, handlers *callHandlers) (<-chan RawMessage, error) {
+	_, results, err := wsjc.sendRequest(context.Background(), name, params, handlers)
+	if err != nil {
+		return nil, err
 	}
 
-	if isMethod {
-		id := <-wsjc.idSeq
-		request.Id = id
-		chr := make(chan json.RawMessage)
-		wsjc.addPendingResult(id, chr)
-		ch = chr
+	ch := make(chan RawMessage)
+	go func() {
+		defer close(ch)
+		res, ok := <-results
+		if ok && res.err == nil {
+			ch <- res.result
+		}
+	}()
+	return ch, nil
+}
+
+// Handlers of the notifications about a call made, any of them may be nil
+type callHandlers struct {
+	onValue    func(RawMessage)
+	onProgress func(Progress)
+	// span of the call, nil if not traced
+	span *Span
+}
+
+func (h *callHandlers) endSpan(err *Error) {
+	if h != nil {
+		h.span.endCall(err)
 	}
+}
 
-	wsjc.output <- request
-	return
+// Sends a request that expects a response, handlers may be nil.
+// The span of the call is a child of the span in the context
+func (wsjc *WsJsonClient) sendRequest(ctx context.Context, name string, params interface{}, handlers *callHandlers) (int, <-chan *callResult, error) {
+	if wsjc.replaying {
+		return 0, nil, ErrReplayPeerCall
+	}
+	if !wsjc.Protocol().Has(FeatureServerCalls) {
+		return 0, nil, ErrFeatureNotSupported
+	}
+
+	request, err := wsjc.newRequest(name, params)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// Request Id sequence
+	id := int(atomic.AddInt64(&wsjc.idSeq, 1))
+	request.Id = id
+	if handlers != nil && handlers.onProgress != nil {
+		request.Meta = &Meta{ProgressToken: id}
+	}
+	if span := wsjc.startClientSpan(ctx, request); span != nil {
+		if handlers == nil {
+			handlers = &callHandlers{}
+		}
+		handlers.span = span
+	}
+	ch := make(chan *callResult, 1)
+	if err = wsjc.addPendingResult(id, ch, handlers); err != nil {
+		return 0, nil, err
+	}
+
+	if err = wsjc.send(request); err != nil {
+		wsjc.removePendingResult(id, NewError(ErrorInternalError, err.Error()))
+		return 0, nil, err
+	}
+	return id, ch, nil
 }
 
-func (wsjc *WsJsonClient) serve() {
-	go wsjc.readLoop()
+func (wsjc *WsJsonClient) newRequest(name string, params interface{}) (*Request, error) {
+	rawParams, err := wsjc.codec().Marshal(params)
+	if err != nil {
+		return nil, err
+	}
 
+	return &Request{
+		Version: JSONRPCVersion,
+		Method:  name,
+		Params:  rawParams,
+	}, nil
+}
+
+func (wsjc *WsJsonClient) serve() {
+	go wsjc.writeLoop()
+	go wsjc.readLoop(wsjc.conn)
 }
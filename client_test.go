@@ -1,6 +1,7 @@
 package wsjson
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -141,7 +142,7 @@ func createClient() (
 	simpleService = &SimpleService{calls: 0}
 	namedService = &NamedPrefixService{}
 	methodService = &MethodProviderService{}
-	client, err = newWsJsonClient(nil, []interface{}{simpleService, namedService, methodService})
+	client, err = newWsJsonClient(newServiceManager(), nil, []interface{}{simpleService, namedService, methodService})
 	if err != nil {
 		return
 	}
@@ -338,7 +339,7 @@ func TestSimpleService(t *testing.T) {
 
 func TestNoServices(t *testing.T) {
 	servs := []interface{}{}
-	_, err := newWsJsonClient(nil, servs)
+	_, err := newWsJsonClient(newServiceManager(), nil, servs)
 	if err == nil {
 		t.Error("Client creation with empty services should have failed")
 	}
@@ -395,7 +396,7 @@ func TestValidations(t *testing.T) {
 
 	for _, row := range table {
 		servs := []interface{}{row.serv}
-		_, err := newWsJsonClient(nil, servs)
+		_, err := newWsJsonClient(newServiceManager(), nil, servs)
 		if err == nil {
 			t.Error("Client creation should have failed:", row.serv)
 		}
@@ -535,7 +536,7 @@ func TestCalls(t *testing.T) {
 	}
 
 	//goroutine to consume results
-	ch2 := make(chan json.RawMessage)
+	ch2 := make(chan RawMessage)
 	ch2stop := make(chan bool)
 	go func() {
 		for result := range ch {
@@ -551,7 +552,7 @@ func TestCalls(t *testing.T) {
 		t.Fatalf("Result delivery should have no response, found: %s", r)
 	}
 
-	var rawResult json.RawMessage
+	var rawResult RawMessage
 	select {
 	case rawResult = <-ch2:
 	case <-time.After(100 * time.Millisecond):
@@ -574,3 +575,70 @@ func TestCalls(t *testing.T) {
 	}
 
 }
+
+func TestCallResponses(t *testing.T) {
+	client, _, _, _, err := createClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// answer every request with the given response
+	answer := func(response string) {
+		rq := (<-client.output).(*Request)
+		r := client.handleMessage(strings.NewReader(fmt.Sprintf(response, rq.Id)))
+		if r != nil {
+			t.Errorf("Result delivery should have no response, found: %v", r)
+		}
+	}
+
+	go answer(`{"jsonrpc":"2.0", "result":{"number": 7, "name": "seven"}, "id":%d}`)
+	var result AllTypes
+	err = client.Call(context.Background(), "remote.Get", []int{7}, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Number != 7 || result.Name != "seven" {
+		t.Errorf("Invalid result received: %+v", result)
+	}
+
+	go answer(`{"jsonrpc":"2.0", "error":{"code": 100, "message": "Don't mention his name"}, "id":%d}`)
+	err = client.Call(context.Background(), "remote.Get", []int{7}, &result)
+	jsonErr, ok := err.(*Error)
+	if !ok || jsonErr.Code != errVoldemor {
+		t.Errorf("Invalid error for call, expected code %d, got: %#v", errVoldemor, err)
+	}
+
+	// calls time out with their context
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = client.Call(ctx, "remote.Never", nil, nil)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Call should have timed out, got: %v", err)
+	}
+	rq1 := (<-client.output).(*Request)
+	// the peer is told to cancel the call timed out
+	cancelRq := (<-client.output).(*Request)
+	if cancelRq.Method != MethodCancelRequest || string(cancelRq.Params) != fmt.Sprintf(`{"id":%v}`, rq1.Id) {
+		t.Errorf("Timed out calls should be cancelled, got: %v", cancelRq)
+	}
+	// consecutive calls must have different ids
+	client.SendMessage("remote.Other", nil, true)
+	rq2 := (<-client.output).(*Request)
+	if rq2.Method != "remote.Other" || rq2.Id == nil || rq1.Id == rq2.Id {
+		t.Errorf("Requests should have different ids: %v, %v", rq1, rq2)
+	}
+
+	client.resultsMutex.RLock()
+	pending := len(client.pendingResults)
+	client.resultsMutex.RUnlock()
+	if pending != 1 {
+		t.Errorf("Timed out calls should not be pending, got %d pending", pending)
+	}
+
+	// pending calls fail when the connection is closed
+	go client.close()
+	err = client.Call(context.Background(), "remote.Get", nil, nil)
+	if err != ErrConnectionClosed {
+		t.Errorf("Call on a closed connection should fail, got: %v", err)
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/manologab/wsjson"
+)
+
+// Load to generate, read from a JSON file
+type Scenario struct {
+	// Concurrent connections
+	Connections int `json:"connections"`
+	// Duration of the run, e.g. "30s"
+	Duration duration `json:"duration"`
+	// Calls per second of each connection, 0 for as many as possible
+	Rate float64 `json:"rate"`
+	// Time to wait for each response
+	Timeout duration `json:"timeout"`
+	// Calls made, chosen at random by their weight
+	Calls []ScenarioCall `json:"calls"`
+}
+
+type ScenarioCall struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+	// Relative frequency of the call, 1 if not set
+	Weight int `json:"weight,omitempty"`
+	// params decoded, so they are encoded by the codec of the connection
+	params interface{}
+}
+
+// Duration as a string in JSON, e.g. "1m30s"
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	*d = duration(parsed)
+	return err
+}
+
+func readScenario(name string) (*Scenario, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseScenario(f)
+}
+
+func parseScenario(r io.Reader) (*Scenario, error) {
+	var s Scenario
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, fmt.Errorf("Invalid scenario: %v", err)
+	}
+	if len(s.Calls) == 0 {
+		return nil, errors.New("Invalid scenario: no calls")
+	}
+	for i := range s.Calls {
+		if s.Calls[i].Method == "" {
+			return nil, fmt.Errorf("Invalid scenario: call %d without method", i)
+		}
+		if len(s.Calls[i].Params) > 0 {
+			if err := decodeParams(s.Calls[i].Params, &s.Calls[i].params); err != nil {
+				return nil, fmt.Errorf("Invalid scenario: params of call %d: %v", i, err)
+			}
+		}
+		if s.Calls[i].Weight <= 0 {
+			s.Calls[i].Weight = 1
+		}
+	}
+	if s.Connections <= 0 {
+		s.Connections = 1
+	}
+	if s.Duration <= 0 {
+		s.Duration = duration(10 * time.Second)
+	}
+	if s.Timeout <= 0 {
+		s.Timeout = duration(10 * time.Second)
+	}
+	return &s, nil
+}
+
+// Decode the params keeping the integers as int64, binary codecs encode
+// float64 as floats, which can't be decoded into integer params
+func decodeParams(data []byte, params *interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(params); err != nil {
+		return err
+	}
+	*params = convertNumbers(*params)
+	return nil
+}
+
+func convertNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i := range v {
+			v[i] = convertNumbers(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = convertNumbers(v[k])
+		}
+	}
+	return v
+}
+
+// Choose a call by its weight
+func (s *Scenario) pick(rnd *rand.Rand) *ScenarioCall {
+	total := 0
+	for i := range s.Calls {
+		total += s.Calls[i].Weight
+	}
+	n := rnd.Intn(total)
+	for i := range s.Calls {
+		if n < s.Calls[i].Weight {
+			return &s.Calls[i]
+		}
+		n -= s.Calls[i].Weight
+	}
+	return &s.Calls[len(s.Calls)-1]
+}
+
+// Latencies and errors of a method
+type methodStats struct {
+	latencies []time.Duration
+	// errors by JSON-RPC code, 0 for the ones not sent by the server like timeouts
+	errors map[int]int
+}
+
+// Results of a run
+type Report struct {
+	Elapsed     time.Duration
+	Connections int
+	// Connections lost during the run, they are opened again
+	Disconnects int
+	// Connections that couldn't be opened
+	DialErrors int
+	methods    map[string]*methodStats
+}
+
+// Results of a method
+type MethodReport struct {
+	Method string
+	Calls  int
+	Errors int
+	// Errors by JSON-RPC code, 0 for the ones not sent by the server
+	ErrorCodes map[int]int
+	// Calls per second
+	Throughput    float64
+	P50, P90, P99 time.Duration
+	Max           time.Duration
+}
+
+// Collects the results of the connections
+type collector struct {
+	mutex  sync.Mutex
+	report Report
+}
+
+func (c *collector) record(method string, latency time.Duration, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stats := c.report.methods[method]
+	if stats == nil {
+		stats = &methodStats{errors: make(map[int]int)}
+		c.report.methods[method] = stats
+	}
+	if err == nil {
+		stats.latencies = append(stats.latencies, latency)
+		return
+	}
+	code := 0
+	var rpcErr *wsjson.Error
+	if errors.As(err, &rpcErr) {
+		code = rpcErr.Code
+	}
+	stats.errors[code]++
+}
+
+func (c *collector) count(disconnects, dialErrors int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.report.Disconnects += disconnects
+	c.report.DialErrors += dialErrors
+}
+
+// Whether the run is over, dials fail on its deadline before the context is done
+func ended(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return true
+	}
+	deadline, ok := ctx.Deadline()
+	return ok && !time.Now().Before(deadline)
+}
+
+// Run the scenario against the endpoint, wsj configures the connections
+func run(ctx context.Context, wsj *wsjson.WsJson, url string, header http.Header, s *Scenario) *Report {
+	c := &collector{report: Report{Connections: s.Connections, methods: make(map[string]*methodStats)}}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.Duration))
+	defer cancel()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < s.Connections; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			runConnection(ctx, wsj, url, header, s, c, rand.New(rand.NewSource(seed)))
+		}(start.UnixNano() + int64(i))
+	}
+	wg.Wait()
+	c.report.Elapsed = time.Since(start)
+	return &c.report
+}
+
+// Make calls on a connection until the context is done, the connection is opened again when lost
+func runConnection(ctx context.Context, wsj *wsjson.WsJson, url string, header http.Header, s *Scenario, c *collector, rnd *rand.Rand) {
+	var interval time.Duration
+	if s.Rate > 0 {
+		interval = time.Duration(float64(time.Second) / s.Rate)
+	}
+
+	var client *wsjson.WsJsonClient
+	defer func() {
+		if client != nil {
+			client.Close()
+		}
+	}()
+	next := time.Now()
+	for ctx.Err() == nil {
+		if client == nil {
+			var err error
+			if client, err = wsj.Dial(ctx, url, header); err != nil {
+				if !ended(ctx) {
+					c.count(0, 1)
+					sleep(ctx, 100*time.Millisecond)
+				}
+				continue
+			}
+		}
+
+		call := s.pick(rnd)
+		callCtx, cancel := context.WithTimeout(ctx, time.Duration(s.Timeout))
+		begin := time.Now()
+		err := client.Call(callCtx, call.Method, call.params, nil)
+		latency := time.Since(begin)
+		cancel()
+		if ctx.Err() != nil {
+			// calls interrupted by the end of the run are not counted
+			return
+		}
+		c.record(call.Method, latency, err)
+
+		select {
+		case <-client.Done():
+			c.count(1, 0)
+			client = nil
+		default:
+		}
+
+		if interval > 0 {
+			next = next.Add(interval)
+			sleep(ctx, time.Until(next))
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// Results by method, sorted by name
+func (r *Report) Methods() []MethodReport {
+	var reports []MethodReport
+	for method, stats := range r.methods {
+		latencies := append([]time.Duration(nil), stats.latencies...)
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		mr := MethodReport{Method: method, ErrorCodes: stats.errors}
+		for _, n := range stats.errors {
+			mr.Errors += n
+		}
+		mr.Calls = len(latencies) + mr.Errors
+		if r.Elapsed > 0 {
+			mr.Throughput = float64(mr.Calls) / r.Elapsed.Seconds()
+		}
+		if len(latencies) > 0 {
+			mr.P50 = percentile(latencies, 50)
+			mr.P90 = percentile(latencies, 90)
+			mr.P99 = percentile(latencies, 99)
+			mr.Max = latencies[len(latencies)-1]
+		}
+		reports = append(reports, mr)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Method < reports[j].Method })
+	return reports
+}
+
+// Nearest-rank percentile of sorted latencies
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Print the report as a table
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "%d connections, %s, %d disconnects, %d dial errors\n\n",
+		r.Connections, r.Elapsed.Round(time.Millisecond), r.Disconnects, r.DialErrors)
+	fmt.Fprintf(w, "%-30s %8s %8s %7s %10s %10s %10s %10s %10s\n",
+		"method", "calls", "calls/s", "errors", "p50", "p90", "p99", "max", "error codes")
+	var total, failed int
+	for _, m := range r.Methods() {
+		total += m.Calls
+		failed += m.Errors
+		fmt.Fprintf(w, "%-30s %8d %8.1f %6.2f%% %10s %10s %10s %10s %10s\n",
+			m.Method, m.Calls, m.Throughput, errorRate(m.Errors, m.Calls),
+			round(m.P50), round(m.P90), round(m.P99), round(m.Max), formatCodes(m.ErrorCodes))
+	}
+	fmt.Fprintf(w, "\n%d calls, %.1f calls/s, %.2f%% errors\n", total, float64(total)/r.Elapsed.Seconds(), errorRate(failed, total))
+}
+
+func errorRate(errors, calls int) float64 {
+	if calls == 0 {
+		return 0
+	}
+	return float64(errors) * 100 / float64(calls)
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
+
+func formatCodes(codes map[int]int) string {
+	keys := make([]int, 0, len(codes))
+	for code := range codes {
+		keys = append(keys, code)
+	}
+	sort.Ints(keys)
+	s := ""
+	for _, code := range keys {
+		if s != "" {
+			s += ","
+		}
+		s += fmt.Sprintf("%d:%d", code, codes[code])
+	}
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manologab/wsjson"
+	"github.com/manologab/wsjson/codec/msgpack"
+	"github.com/manologab/wsjson/wsjsontest"
+)
+
+type Load struct{}
+
+func (*Load) ApiEcho(s string) (string, error) {
+	return s, nil
+}
+
+func (*Load) ApiAdd(a, b int) (int, error) {
+	return a + b, nil
+}
+
+func (*Load) ApiFail() (bool, error) {
+	return false, wsjson.NewError(wsjson.ErrorInvalidParams, "Failed")
+}
+
+// Closes the connection without answering
+func (*Load) ApiDrop(ctx context.Context) (bool, error) {
+	wsjson.ClientFromContext(ctx).Close()
+	return true, nil
+}
+
+func TestParseScenario(t *testing.T) {
+	var tests = []struct {
+		scenario string
+		err      string
+	}{
+		{`{"calls": [{"method": "Load.Echo", "params": ["a"]}]}`, ""},
+		{`{"calls": []}`, "no calls"},
+		{`{"calls": [{"params": []}]}`, "call 0 without method"},
+		{`{"duration": "soon", "calls": [{"method": "Load.Echo"}]}`, "invalid duration"},
+		{`{"calls": [`, "Invalid scenario"},
+	}
+	for _, test := range tests {
+		_, err := parseScenario(strings.NewReader(test.scenario))
+		if test.err == "" && err != nil {
+			t.Errorf("Scenario %s should be valid, got: %v", test.scenario, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("Scenario %s should fail with %q, got: %v", test.scenario, test.err, err)
+		}
+	}
+
+	s, _ := parseScenario(strings.NewReader(`{"calls": [{"method": "Load.Echo"}]}`))
+	if s.Connections != 1 || s.Duration <= 0 || s.Timeout <= 0 || s.Calls[0].Weight != 1 {
+		t.Errorf("Defaults should be set, got: %+v", s)
+	}
+}
+
+func TestPick(t *testing.T) {
+	s, err := parseScenario(strings.NewReader(`{"calls": [{"method": "a", "weight": 3}, {"method": "b"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int{}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 4000; i++ {
+		counts[s.pick(rnd).Method]++
+	}
+	if counts["a"] < 2800 || counts["a"] > 3200 {
+		t.Errorf("Calls should be chosen by their weight, got: %v", counts)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i))
+	}
+	var tests = []struct {
+		p        int
+		expected time.Duration
+	}{{50, 50}, {90, 90}, {99, 99}, {100, 100}, {0, 1}}
+	for _, test := range tests {
+		if got := percentile(latencies, test.p); got != test.expected {
+			t.Errorf("p%d should be %d, got: %d", test.p, test.expected, got)
+		}
+	}
+	if got := percentile(latencies[:1], 99); got != 1 {
+		t.Errorf("Percentile of one latency should be it, got: %d", got)
+	}
+}
+
+func TestRun(t *testing.T) {
+	server := wsjsontest.NewServer(t, &Load{})
+	s, err := parseScenario(strings.NewReader(`{
+		"connections": 4,
+		"duration": "300ms",
+		"timeout": "1s",
+		"calls": [
+			{"method": "Load.Echo", "params": ["hello"], "weight": 20},
+			{"method": "Load.Fail", "weight": 5},
+			{"method": "Load.Drop"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := run(context.Background(), &wsjson.WsJson{}, server.URL, nil, s)
+	methods := map[string]MethodReport{}
+	for _, m := range report.Methods() {
+		methods[m.Method] = m
+	}
+
+	echo := methods["Load.Echo"]
+	if echo.Calls == 0 || echo.Errors != 0 || echo.Throughput <= 0 || echo.P50 <= 0 || echo.P50 > echo.P99 || echo.P99 > echo.Max {
+		t.Errorf("Echo calls should succeed, got: %+v", echo)
+	}
+	fail := methods["Load.Fail"]
+	if fail.Calls == 0 || fail.Errors != fail.Calls || fail.ErrorCodes[wsjson.ErrorInvalidParams] != fail.Calls {
+		t.Errorf("Fail calls should fail with invalid params, got: %+v", fail)
+	}
+	drop := methods["Load.Drop"]
+	if drop.Calls == 0 || drop.ErrorCodes[0] != drop.Calls {
+		t.Errorf("Drop calls should fail without code, got: %+v", drop)
+	}
+	if report.Disconnects != drop.Calls || report.DialErrors != 0 {
+		t.Errorf("Each drop should be a disconnect, got: %d disconnects for %d drops, %d dial errors",
+			report.Disconnects, drop.Calls, report.DialErrors)
+	}
+
+	var out strings.Builder
+	report.Print(&out)
+	for _, exp := range []string{"4 connections", "Load.Echo", "Load.Fail", "-32602:", "0:"} {
+		if !strings.Contains(out.String(), exp) {
+			t.Errorf("Report should contain %q, got:\n%s", exp, out.String())
+		}
+	}
+}
+
+func TestRunMsgpack(t *testing.T) {
+	protocol := wsjson.Protocol{Name: "wsjson+msgpack", Features: wsjson.AllFeatures, Codec: msgpack.Codec}
+	wsj := &wsjson.WsJson{}
+	wsj.SetProtocols(protocol)
+	wsj.AddService(&Load{})
+	server := wsjsontest.Serve(t, wsj)
+
+	s, _ := parseScenario(strings.NewReader(`{"duration": "100ms", "calls": [{"method": "Load.Add", "params": [2, 3]}]}`))
+	client := &wsjson.WsJson{}
+	client.SetProtocols(protocol)
+	report := run(context.Background(), client, server.URL, nil, s)
+	methods := report.Methods()
+	if len(methods) != 1 || methods[0].Calls == 0 || methods[0].Errors != 0 {
+		t.Errorf("Integer params should be sent as integers, got: %+v", methods)
+	}
+}
+
+func TestRunDialErrors(t *testing.T) {
+	s, _ := parseScenario(strings.NewReader(`{"duration": "250ms", "calls": [{"method": "Load.Echo"}]}`))
+	report := run(context.Background(), &wsjson.WsJson{}, "ws://127.0.0.1:1/ws", nil, s)
+	if report.DialErrors == 0 || len(report.Methods()) != 0 {
+		t.Errorf("Connections should fail, got: %+v", report)
+	}
+}
@@ -0,0 +1,109 @@
+// Command wsjson-bench is a load tool for wsjson servers. It opens concurrent connections
+// making calls chosen at random from a scenario file, and reports the throughput and latency
+// percentiles of each method, its errors and the connections lost.
+//
+//	wsjson-bench ws://localhost:8080/ws scenario.json
+//	wsjson-bench -c 100 -d 1m ws://localhost:8080/ws scenario.json
+//
+// The scenario has the calls made with their weight, and the defaults of the flags:
+//
+//	{
+//	  "connections": 50,
+//	  "duration": "30s",
+//	  "calls": [
+//	    {"method": "Sample.Echo", "params": ["hello"], "weight": 8},
+//	    {"method": "Sample.Sleep", "params": [20], "weight": 2}
+//	  ]
+//	}
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/manologab/wsjson"
+	"github.com/manologab/wsjson/codec/cbor"
+	"github.com/manologab/wsjson/codec/msgpack"
+)
+
+// Headers of the upgrade request, the flag can be repeated
+type headerFlag http.Header
+
+func (h headerFlag) String() string {
+	return fmt.Sprint(http.Header(h))
+}
+
+func (h headerFlag) Set(value string) error {
+	name, val, ok := strings.Cut(value, ":")
+	if !ok {
+		return fmt.Errorf("Invalid header, expected 'Name: value': %s", value)
+	}
+	http.Header(h).Add(strings.TrimSpace(name), strings.TrimSpace(val))
+	return nil
+}
+
+var (
+	headers     = headerFlag{}
+	connections = flag.Int("c", 0, "concurrent connections, overrides the scenario")
+	runFor      = flag.Duration("d", 0, "duration of the run, overrides the scenario")
+	rate        = flag.Float64("rate", 0, "calls per second of each connection, overrides the scenario")
+	timeout     = flag.Duration("timeout", 0, "time to wait for each response, overrides the scenario")
+	protocol    = flag.String("protocol", "", "subprotocol requested")
+	codecName   = flag.String("codec", "json", "codec of the subprotocol: json, msgpack or cbor")
+)
+
+var codecs = map[string]wsjson.Codec{
+	"json":    wsjson.JSONCodec,
+	"msgpack": msgpack.Codec,
+	"cbor":    cbor.Codec,
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: wsjson-bench [flags] url scenario.json\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("wsjson-bench: ")
+	flag.Var(headers, "H", "header of the upgrade request, 'Name: value'")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	codec, ok := codecs[*codecName]
+	if !ok {
+		log.Fatalf("Unknown codec: %s", *codecName)
+	}
+	scenario, err := readScenario(flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *connections > 0 {
+		scenario.Connections = *connections
+	}
+	if *runFor > 0 {
+		scenario.Duration = duration(*runFor)
+	}
+	if *rate > 0 {
+		scenario.Rate = *rate
+	}
+	if *timeout > 0 {
+		scenario.Timeout = duration(*timeout)
+	}
+
+	wsj := &wsjson.WsJson{}
+	if *protocol != "" {
+		wsj.SetProtocols(wsjson.Protocol{Name: *protocol, Features: wsjson.AllFeatures, Codec: codec})
+	}
+	report := run(context.Background(), wsj, flag.Arg(0), http.Header(headers), scenario)
+	report.Print(os.Stdout)
+}
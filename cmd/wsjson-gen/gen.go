@@ -0,0 +1,603 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/manologab/wsjson"
+)
+
+const (
+	// Same default prefix used by wsjson when registering services
+	defMethodPrefix = "Api"
+
+	wsjsonPath = "github.com/manologab/wsjson"
+)
+
+var typeOfError = types.Universe.Lookup("error").Type()
+
+// Final result of the methods returning a channel
+var streamResult = types.NewNamed(
+	types.NewTypeName(token.NoPos, types.NewPackage(wsjsonPath, "wsjson"), "StreamResult", nil),
+	types.NewStruct(nil, nil), nil)
+
+// Names that can't be used for the parameters of a generated method
+var reservedNames = map[string]bool{
+	"ctx": true, "p": true, "result": true, "err": true, "context": true, "wsjson": true,
+	"onValue": true,
+}
+
+// An exposed method of a service
+type method struct {
+	name     string // exposed name
+	goName   string
+	funcName string // name of the proxy method
+	params   []*types.Var
+	result   types.Type // nil for events
+	isObject bool       // the only parameter is sent as an object
+	// streaming methods send partial results of this type, nil for the other methods
+	value types.Type
+}
+
+type generator struct {
+	separator string
+	mapName   wsjson.NameMapper
+	// package of the generated file, the package of the services if empty
+	outPkg string
+
+	fset    *token.FileSet
+	files   []*ast.File
+	pkg     *types.Package
+	info    *types.Info
+	imports map[string]string
+	buf     bytes.Buffer
+	// unexported types of the services used from another package
+	unexported []string
+}
+
+// Generate the proxies for the given types of the package in dir, in the package outPkg,
+// or the package of the services if empty. separator and mapName must match the options
+// of the server
+func generate(dir string, typeNames []string, skipFile, outPkg, separator string, mapName wsjson.NameMapper) ([]byte, error) {
+	gen := &generator{
+		separator: separator,
+		mapName:   mapName,
+		fset:      token.NewFileSet(),
+		imports:   map[string]string{wsjsonPath: "wsjson"},
+	}
+	if err := gen.load(dir, skipFile); err != nil {
+		return nil, err
+	}
+	if outPkg != gen.pkg.Name() {
+		gen.outPkg = outPkg
+	}
+
+	var body bytes.Buffer
+	for _, name := range typeNames {
+		gen.buf.Reset()
+		if err := gen.generateType(strings.TrimSpace(name)); err != nil {
+			return nil, err
+		}
+		body.Write(gen.buf.Bytes())
+	}
+	if len(gen.unexported) > 0 {
+		return nil, fmt.Errorf("Unexported types can't be used from package %s: %s", gen.outPkg, strings.Join(gen.unexported, ", "))
+	}
+
+	pkgName := gen.pkg.Name()
+	if gen.outPkg != "" {
+		pkgName = gen.outPkg
+	}
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by wsjson-gen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&out, "package %s\n\n", pkgName)
+	out.WriteString("import (\n")
+	paths := make([]string, 0, len(gen.imports))
+	for path := range gen.imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if name := gen.imports[path]; name != path[strings.LastIndex(path, "/")+1:] {
+			fmt.Fprintf(&out, "\t%s %q\n", name, path)
+		} else {
+			fmt.Fprintf(&out, "\t%q\n", path)
+		}
+	}
+	out.WriteString(")\n")
+	out.Write(body.Bytes())
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("Invalid generated code: %v\n%s", err, out.Bytes())
+	}
+	return src, nil
+}
+
+// Parse and type check the package
+func (gen *generator) load(dir, skipFile string) error {
+	filter := func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go") && fi.Name() != skipFile
+	}
+	pkgs, err := parser.ParseDir(gen.fset, dir, filter, 0)
+	if err != nil {
+		return err
+	}
+	if len(pkgs) != 1 {
+		return fmt.Errorf("Expected one package in %s, found: %d", dir, len(pkgs))
+	}
+
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			gen.files = append(gen.files, file)
+		}
+	}
+
+	path, exports, err := listExports(dir)
+	if err != nil {
+		return err
+	}
+	gen.info = &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+		Defs:  make(map[*ast.Ident]types.Object),
+	}
+	conf := types.Config{
+		Importer: importer.ForCompiler(gen.fset, "gc", func(path string) (io.ReadCloser, error) {
+			file, ok := exports[path]
+			if !ok || file == "" {
+				return nil, fmt.Errorf("No export data for %s", path)
+			}
+			return os.Open(file)
+		}),
+		// errors in unrelated code shouldn't prevent the generation
+		Error: func(error) {},
+	}
+	gen.pkg, _ = conf.Check(path, gen.fset, gen.files, gen.info)
+	return nil
+}
+
+// Import path of the package in dir and the export data of its dependencies,
+// compiled by the go command
+func listExports(dir string) (string, map[string]string, error) {
+	cmd := exec.Command("go", "list", "-e", "-export", "-deps", "-f", "{{.DepOnly}}\t{{.ImportPath}}\t{{.Export}}", ".")
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("Error listing the package: %v\n%s", err, stderr.Bytes())
+	}
+
+	var path string
+	exports := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[0] == "false" {
+			path = fields[1]
+		} else {
+			exports[fields[1]] = fields[2]
+		}
+	}
+	if path == "" {
+		return "", nil, fmt.Errorf("Package not found in %s", dir)
+	}
+	return path, exports, nil
+}
+
+// Write the proxy for a single service type
+func (gen *generator) generateType(typeName string) error {
+	obj := gen.pkg.Scope().Lookup(typeName)
+	if obj == nil {
+		return fmt.Errorf("Type %s not found in package %s", typeName, gen.pkg.Name())
+	}
+	named, ok := obj.Type().(*types.Named)
+	if !ok {
+		return fmt.Errorf("%s is not a named type", typeName)
+	}
+	return gen.generateService(named, "", typeName)
+}
+
+// Write the proxy of a service and its sub-services, named <name>Proxy.
+// The name of the service is prefixed with the name of its parent, if any
+func (gen *generator) generateService(named *types.Named, parent, name string) error {
+	typeName := named.Obj().Name()
+	mset := types.NewMethodSet(types.NewPointer(named))
+
+	servName := typeName
+	if name, ok, err := gen.constString(mset, "WsName"); err != nil {
+		return err
+	} else if ok {
+		servName = name
+	}
+	if strings.Contains(servName, gen.separator) {
+		return fmt.Errorf("Service name %q can't contain the separator %q", servName, gen.separator)
+	}
+	if parent != "" {
+		servName = parent + gen.separator + servName
+	}
+
+	methods, err := gen.exposedMethods(mset)
+	if err != nil {
+		return err
+	}
+	for _, m := range methods {
+		if strings.Contains(m.name, gen.separator) {
+			return fmt.Errorf("Method name %q of %s can't contain the separator %q", m.name, servName, gen.separator)
+		}
+	}
+	subs, err := gen.subServices(mset)
+	if err != nil {
+		return err
+	}
+	if len(methods) == 0 && len(subs) == 0 {
+		return fmt.Errorf("No exposed methods found for %s", typeName)
+	}
+
+	proxy := name + "Proxy"
+	fmt.Fprintf(&gen.buf, "\n// %s calls the methods of a remote %s service\n", proxy, servName)
+	fmt.Fprintf(&gen.buf, "type %s struct {\n\tclient *wsjson.WsJsonClient\n}\n\n", proxy)
+	fmt.Fprintf(&gen.buf, "func New%s(client *wsjson.WsJsonClient) *%s {\n\treturn &%s{client: client}\n}\n", proxy, proxy, proxy)
+
+	funcNames := make(map[string]bool)
+	for _, m := range methods {
+		funcNames[m.funcName] = true
+		gen.writeMethod(proxy, servName, m)
+	}
+
+	// each sub-service is reached from its parent, e.g. geo.Units().Convert(...)
+	for _, sub := range subs {
+		accessor := exportedName(sub.Obj().Name())
+		if funcNames[accessor] {
+			return fmt.Errorf("Sub-service %s of %s has the name of a method", sub.Obj().Name(), typeName)
+		}
+		funcNames[accessor] = true
+		subProxy := name + accessor + "Proxy"
+		fmt.Fprintf(&gen.buf, "\nfunc (p *%s) %s() *%s {\n\treturn &%s{client: p.client}\n}\n", proxy, accessor, subProxy, subProxy)
+	}
+	for _, sub := range subs {
+		if err := gen.generateService(sub, servName, name+exportedName(sub.Obj().Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Types of the sub-services declared by WsServices(), which must return a literal
+// of instances of types of the package, e.g. []interface{}{&Units{}}
+func (gen *generator) subServices(mset *types.MethodSet) ([]*types.Named, error) {
+	expr, ok, err := gen.returnedExpr(mset, "WsServices")
+	if !ok || err != nil {
+		return nil, err
+	}
+	lit, ok := expr.(*ast.CompositeLit)
+	if !ok {
+		return nil, fmt.Errorf("WsServices() must return a slice literal")
+	}
+
+	var subs []*types.Named
+	for _, elt := range lit.Elts {
+		t := gen.info.Types[elt].Type
+		if ptr, ok := t.(*types.Pointer); ok {
+			t = ptr.Elem()
+		}
+		named, ok := t.(*types.Named)
+		if !ok || named.Obj().Pkg() != gen.pkg {
+			return nil, fmt.Errorf("WsServices() must return instances of types of the package, found: %s", types.ExprString(elt))
+		}
+		subs = append(subs, named)
+	}
+	return subs, nil
+}
+
+// Name with its first letter in upper case
+func exportedName(name string) string {
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+func (gen *generator) writeMethod(proxy, servName string, m *method) {
+	names := make([]string, len(m.params))
+	args := make([]string, len(m.params))
+	used := make(map[string]bool)
+	for i, param := range m.params {
+		name := param.Name()
+		if name == "" || name == "_" || reservedNames[name] || used[name] {
+			name = fmt.Sprintf("arg%d", i)
+		}
+		used[name] = true
+		names[i] = name
+		args[i] = name + " " + gen.typeString(param.Type())
+	}
+
+	params := "[]interface{}{" + strings.Join(names, ", ") + "}"
+	if m.isObject {
+		params = names[0]
+	}
+	fullName := servName + gen.separator + m.name
+	funcName := m.funcName
+
+	gen.buf.WriteString("\n")
+	if m.result == nil {
+		fmt.Fprintf(&gen.buf, "func (p *%s) %s(%s) error {\n", proxy, funcName, strings.Join(args, ", "))
+		fmt.Fprintf(&gen.buf, "\treturn p.client.SendEvent(%q, %s)\n}\n", fullName, params)
+		return
+	}
+
+	gen.imports["context"] = "context"
+	args = append([]string{"ctx context.Context"}, args...)
+	resultType := gen.typeString(m.result)
+	if m.value != nil {
+		args = append(args, "onValue func("+gen.typeString(m.value)+")")
+		fmt.Fprintf(&gen.buf, "func (p *%s) %s(%s) (%s, error) {\n", proxy, funcName, strings.Join(args, ", "), resultType)
+		fmt.Fprintf(&gen.buf, "\tvar result %s\n", resultType)
+		fmt.Fprintf(&gen.buf, "\terr := wsjson.CallStream(ctx, p.client, %q, %s, onValue, &result)\n", fullName, params)
+		gen.buf.WriteString("\treturn result, err\n}\n")
+		return
+	}
+
+	fmt.Fprintf(&gen.buf, "func (p *%s) %s(%s) (%s, error) {\n", proxy, funcName, strings.Join(args, ", "), resultType)
+	fmt.Fprintf(&gen.buf, "\tvar result %s\n", resultType)
+	fmt.Fprintf(&gen.buf, "\terr := p.client.Call(ctx, %q, %s, &result)\n", fullName, params)
+	gen.buf.WriteString("\treturn result, err\n}\n")
+}
+
+// Methods exposed by a service, following the same rules used by wsjson
+func (gen *generator) exposedMethods(mset *types.MethodSet) ([]*method, error) {
+	var methods []*method
+
+	explicit, hasProvider, err := gen.constMap(mset, "WsMethods")
+	if err != nil {
+		return nil, err
+	}
+
+	if hasProvider {
+		for name, goName := range explicit {
+			sel := mset.Lookup(gen.pkg, goName)
+			if sel == nil {
+				return nil, fmt.Errorf("WSMethods(): %s is not a method", goName)
+			}
+			m, err := newMethod(name, sel.Obj().(*types.Func))
+			if err != nil {
+				return nil, err
+			}
+			// use the exposed name when it is a valid exported identifier
+			if token.IsIdentifier(name) && token.IsExported(name) {
+				m.funcName = name
+			}
+			methods = append(methods, m)
+		}
+	} else {
+		prefix := defMethodPrefix
+		if pref, ok, err := gen.constString(mset, "WsPrefix"); err != nil {
+			return nil, err
+		} else if ok {
+			prefix = pref
+		}
+
+		for i := 0; i < mset.Len(); i++ {
+			fn := mset.At(i).Obj().(*types.Func)
+			if !fn.Exported() || !strings.HasPrefix(fn.Name(), prefix) {
+				continue
+			}
+			baseName := strings.TrimPrefix(fn.Name(), prefix)
+			name := baseName
+			if gen.mapName != nil {
+				name = gen.mapName(name)
+			}
+			m, err := newMethod(name, fn)
+			if err != nil {
+				return nil, err
+			}
+			if token.IsIdentifier(baseName) && token.IsExported(baseName) {
+				m.funcName = baseName
+			}
+			methods = append(methods, m)
+		}
+	}
+
+	sort.Slice(methods, func(i, j int) bool { return methods[i].name < methods[j].name })
+	return methods, nil
+}
+
+func newMethod(name string, fn *types.Func) (*method, error) {
+	if !fn.Exported() {
+		return nil, fmt.Errorf("Method must be exported: %s", fn.Name())
+	}
+
+	sig := fn.Type().(*types.Signature)
+	m := &method{
+		name:     name,
+		goName:   fn.Name(),
+		funcName: fn.Name(),
+	}
+
+	// the context and the stream are provided by the server, not sent by the peer
+	var hasStream bool
+	for i := 0; i < sig.Params().Len(); i++ {
+		param := sig.Params().At(i)
+		if i == 0 && isContext(param.Type()) {
+			continue
+		}
+		if len(m.params) == 0 && !hasStream && isStream(param.Type()) {
+			hasStream = true
+			continue
+		}
+		m.params = append(m.params, param)
+	}
+
+	switch sig.Results().Len() {
+	case 0:
+	case 2:
+		if !types.Identical(sig.Results().At(1).Type(), typeOfError) {
+			return nil, fmt.Errorf("Method '%s' last output must be of type error", fn.Name())
+		}
+		m.result = sig.Results().At(0).Type()
+		if ch, ok := m.result.Underlying().(*types.Chan); ok && ch.Dir() != types.SendOnly {
+			m.value = ch.Elem()
+			m.result = streamResult
+		} else if hasStream {
+			m.value = types.NewInterfaceType(nil, nil)
+		}
+	default:
+		return nil, fmt.Errorf("Method '%s' must have 0 or 2 outputs, found: %d", fn.Name(), sig.Results().Len())
+	}
+
+	if len(m.params) == 1 {
+		t := m.params[0].Type()
+		if ptr, ok := t.Underlying().(*types.Pointer); ok {
+			t = ptr.Elem()
+		}
+		_, m.isObject = t.Underlying().(*types.Struct)
+	}
+	return m, nil
+}
+
+// Whether the type is *wsjson.Stream
+func isStream(t types.Type) bool {
+	ptr, ok := t.(*types.Pointer)
+	if !ok {
+		return false
+	}
+	named, ok := ptr.Elem().(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == wsjsonPath && obj.Name() == "Stream"
+}
+
+func isContext(t types.Type) bool {
+	named, ok := t.(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == "context" && obj.Name() == "Context"
+}
+
+// Type name as seen from the generated file, registering the needed imports
+func (gen *generator) typeString(t types.Type) string {
+	if gen.outPkg != "" {
+		gen.checkExported(t)
+	}
+	return types.TypeString(t, func(p *types.Package) string {
+		if p == gen.pkg && gen.outPkg == "" {
+			return ""
+		}
+		gen.imports[p.Path()] = p.Name()
+		return p.Name()
+	})
+}
+
+// Record the unexported types of the services package used by t
+func (gen *generator) checkExported(t types.Type) {
+	switch t := t.(type) {
+	case *types.Named:
+		if obj := t.Obj(); obj.Pkg() == gen.pkg && !obj.Exported() {
+			gen.unexported = append(gen.unexported, obj.Name())
+		}
+		for i := 0; i < t.TypeArgs().Len(); i++ {
+			gen.checkExported(t.TypeArgs().At(i))
+		}
+	case *types.Pointer:
+		gen.checkExported(t.Elem())
+	case *types.Slice:
+		gen.checkExported(t.Elem())
+	case *types.Array:
+		gen.checkExported(t.Elem())
+	case *types.Chan:
+		gen.checkExported(t.Elem())
+	case *types.Map:
+		gen.checkExported(t.Key())
+		gen.checkExported(t.Elem())
+	}
+}
+
+// Find the declaration of a method of the package
+func (gen *generator) funcDecl(fn *types.Func) *ast.FuncDecl {
+	for _, file := range gen.files {
+		for _, decl := range file.Decls {
+			fd, ok := decl.(*ast.FuncDecl)
+			if ok && gen.info.Defs[fd.Name] == fn {
+				return fd
+			}
+		}
+	}
+	return nil
+}
+
+// Expression returned by a method whose body is a single return statement
+func (gen *generator) returnedExpr(mset *types.MethodSet, name string) (ast.Expr, bool, error) {
+	sel := mset.Lookup(gen.pkg, name)
+	if sel == nil {
+		return nil, false, nil
+	}
+
+	decl := gen.funcDecl(sel.Obj().(*types.Func))
+	if decl == nil || decl.Body == nil || len(decl.Body.List) != 1 {
+		return nil, true, fmt.Errorf("%s() must be declared in this package with a single return statement", name)
+	}
+	ret, ok := decl.Body.List[0].(*ast.ReturnStmt)
+	if !ok || len(ret.Results) != 1 {
+		return nil, true, fmt.Errorf("%s() must be declared in this package with a single return statement", name)
+	}
+	return ret.Results[0], true, nil
+}
+
+// Value of a method returning a constant string
+func (gen *generator) constString(mset *types.MethodSet, name string) (string, bool, error) {
+	expr, ok, err := gen.returnedExpr(mset, name)
+	if !ok || err != nil {
+		return "", ok, err
+	}
+
+	value := gen.info.Types[expr].Value
+	if value == nil || value.Kind() != constant.String {
+		return "", true, fmt.Errorf("%s() must return a constant string", name)
+	}
+	return constant.StringVal(value), true, nil
+}
+
+// Value of a method returning a map[string]string literal with constant entries
+func (gen *generator) constMap(mset *types.MethodSet, name string) (map[string]string, bool, error) {
+	expr, ok, err := gen.returnedExpr(mset, name)
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+
+	lit, ok := expr.(*ast.CompositeLit)
+	if !ok {
+		return nil, true, fmt.Errorf("%s() must return a map literal", name)
+	}
+
+	values := make(map[string]string)
+	for _, elt := range lit.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			return nil, true, fmt.Errorf("%s() must return a map literal", name)
+		}
+		key, value := gen.info.Types[kv.Key].Value, gen.info.Types[kv.Value].Value
+		if key == nil || value == nil || key.Kind() != constant.String || value.Kind() != constant.String {
+			return nil, true, fmt.Errorf("%s() entries must be constant strings", name)
+		}
+		values[constant.StringVal(key)] = constant.StringVal(value)
+	}
+	return values, true, nil
+}
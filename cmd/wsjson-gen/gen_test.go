@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/manologab/wsjson"
+)
+
+func TestGenerate(t *testing.T) {
+	src, err := generate("testdata/services", []string{"Geometry", "Explicit"}, "", "", ".", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(src)
+
+	expected := []string{
+		"package services",
+		"\t\"context\"\n",
+		"\t\"github.com/manologab/wsjson\"\n",
+		"\t\"time\"\n",
+		"type GeometryProxy struct {",
+		"func NewGeometryProxy(client *wsjson.WsJsonClient) *GeometryProxy {",
+		"func (p *GeometryProxy) Distance(ctx context.Context, from Point, to Point) (float64, error) {",
+		"err := p.client.Call(ctx, \"geo.Distance\", []interface{}{from, to}, &result)",
+		"func (p *GeometryProxy) Move(ctx context.Context, arg0 *Point) (*Point, error) {",
+		"err := p.client.Call(ctx, \"geo.Move\", arg0, &result)",
+		"func (p *GeometryProxy) Since(ctx context.Context, t time.Time) (time.Duration, error) {",
+		"func (p *GeometryProxy) Reset(arg0 string) error {\n\treturn p.client.SendEvent(\"geo.Reset\", []interface{}{arg0})\n}",
+		"func (p *GeometryProxy) Path(ctx context.Context, from Point, to Point, onValue func(Point)) (wsjson.StreamResult, error) {",
+		"err := wsjson.CallStream(ctx, p.client, \"geo.Path\", []interface{}{from, to}, onValue, &result)",
+		"func (p *GeometryProxy) Trace(ctx context.Context, arg0 Point, onValue func(interface{})) (int, error) {",
+		"func (p *GeometryProxy) Units() *GeometryUnitsProxy {\n\treturn &GeometryUnitsProxy{client: p.client}\n}",
+		"type GeometryUnitsProxy struct {",
+		"func (p *GeometryUnitsProxy) Convert(ctx context.Context, value float64, unit string) (float64, error) {",
+		"err := p.client.Call(ctx, \"geo.units.Convert\", []interface{}{value, unit}, &result)",
+		"func (p *ExplicitProxy) Answer(ctx context.Context) (int, error) {",
+		"err := p.client.Call(ctx, \"Explicit.answer\", []interface{}{}, &result)",
+	}
+	for _, exp := range expected {
+		if !strings.Contains(out, exp) {
+			t.Errorf("Generated code doesn't contain '%s', output:\n%s", exp, out)
+		}
+	}
+
+	if strings.Contains(out, "helper") {
+		t.Errorf("Unexported methods should not be exposed, output:\n%s", out)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	var table = []struct {
+		typeName  string
+		separator string
+		error     string
+	}{
+		{"Missing", ".", "Type Missing not found"},
+		{"Point", ".", "No exposed methods found for Point"},
+		{"Explicit", "s", `Method name "answer" of Explicit can't contain the separator "s"`},
+	}
+
+	for _, row := range table {
+		_, err := generate("testdata/services", []string{row.typeName}, "", "", row.separator, nil)
+		if err == nil || !strings.Contains(err.Error(), row.error) {
+			t.Errorf("Invalid error for %s, expected: '%s', got: %v", row.typeName, row.error, err)
+		}
+	}
+}
+
+func TestGenerateNaming(t *testing.T) {
+	src, err := generate("testdata/services", []string{"Geometry"}, "", "", "/", wsjson.LowerCamelCase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(src)
+
+	expected := []string{
+		"func (p *GeometryProxy) Distance(ctx context.Context, from Point, to Point) (float64, error) {",
+		"err := p.client.Call(ctx, \"geo/distance\", []interface{}{from, to}, &result)",
+		"err := p.client.Call(ctx, \"geo/units/convert\", []interface{}{value, unit}, &result)",
+	}
+	for _, exp := range expected {
+		if !strings.Contains(out, exp) {
+			t.Errorf("Generated code doesn't contain '%s', output:\n%s", exp, out)
+		}
+	}
+}
+
+func TestGenerateClientPackage(t *testing.T) {
+	src, err := generate("testdata/services", []string{"Geometry"}, "", "client", ".", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(src)
+
+	expected := []string{
+		"package client",
+		"\t\"github.com/manologab/wsjson/cmd/wsjson-gen/testdata/services\"\n",
+		"func (p *GeometryProxy) Distance(ctx context.Context, from services.Point, to services.Point) (float64, error) {",
+		"func (p *GeometryProxy) Path(ctx context.Context, from services.Point, to services.Point, onValue func(services.Point)) (wsjson.StreamResult, error) {",
+	}
+	for _, exp := range expected {
+		if !strings.Contains(out, exp) {
+			t.Errorf("Generated code doesn't contain '%s', output:\n%s", exp, out)
+		}
+	}
+
+	_, err = generate("testdata/services", []string{"Internal"}, "", "client", ".", nil)
+	if err == nil || !strings.Contains(err.Error(), "Unexported types can't be used from package client: secret") {
+		t.Errorf("Unexported types should not be used from another package, got: %v", err)
+	}
+}
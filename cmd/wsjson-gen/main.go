@@ -0,0 +1,89 @@
+// Command wsjson-gen generates typed Go proxies for wsjson services.
+//
+// It is meant to be used with go generate, in the package that declares the services:
+//
+//	//go:generate wsjson-gen -type UserService,ChatService
+//
+// For each type a <Type>Proxy struct is written with one method per exposed API
+// method, calling the remote service through a *wsjson.WsJsonClient. Sub-services
+// get their own proxies, reached from the proxy of their parent.
+//
+// With -package the proxies are written for another package, e.g. a client package
+// that doesn't import the services:
+//
+//	//go:generate wsjson-gen -type UserService -package client -output ../client/user_proxy.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/manologab/wsjson"
+)
+
+var (
+	typeNames = flag.String("type", "", "comma-separated list of service type names; required")
+	output    = flag.String("output", "", "output file name, relative to the directory; default <first type>_proxy.go")
+	pkgName   = flag.String("package", "", "package of the output file; default the package of the services")
+	separator = flag.String("separator", ".", "separator between service and method names")
+	naming    = flag.String("naming", "", "naming of the exposed methods: lowerCamel or snake; default the Go name")
+)
+
+// Same strategies available in wsjson
+var nameMappers = map[string]wsjson.NameMapper{
+	"":           nil,
+	"lowerCamel": wsjson.LowerCamelCase,
+	"snake":      wsjson.SnakeCase,
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: wsjson-gen -type T[,T...] [-output file] [-package name] [-separator sep] [-naming strategy] [directory]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("wsjson-gen: ")
+	flag.Usage = usage
+	flag.Parse()
+
+	if *typeNames == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	types := strings.Split(*typeNames, ",")
+
+	mapName, ok := nameMappers[*naming]
+	if !ok {
+		log.Fatalf("Unknown naming strategy: %s", *naming)
+	}
+
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+
+	outName := *output
+	if outName == "" {
+		outName = strings.ToLower(types[0]) + "_proxy.go"
+	}
+	outName = filepath.Join(dir, outName)
+
+	// a previous output in the directory of the services is not parsed
+	skipFile := ""
+	if filepath.Clean(filepath.Dir(outName)) == filepath.Clean(dir) {
+		skipFile = filepath.Base(outName)
+	}
+	src, err := generate(dir, types, skipFile, *pkgName, *separator, mapName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.WriteFile(outName, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
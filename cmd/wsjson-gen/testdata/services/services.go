@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/manologab/wsjson"
+)
+
+type Point struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type Geometry struct{}
+
+func (*Geometry) WsName() string {
+	return "geo"
+}
+
+func (*Geometry) ApiDistance(from, to Point) (float64, error) {
+	return 0, nil
+}
+
+func (*Geometry) ApiMove(ctx context.Context, p *Point) (*Point, error) {
+	return p, nil
+}
+
+func (*Geometry) ApiSince(t time.Time) (time.Duration, error) {
+	return 0, nil
+}
+
+func (*Geometry) ApiReset(_ string) {
+}
+
+func (*Geometry) ApiPath(ctx context.Context, from, to Point) (<-chan Point, error) {
+	return nil, nil
+}
+
+func (*Geometry) ApiTrace(ctx context.Context, stream *wsjson.Stream, p Point) (int, error) {
+	return 0, nil
+}
+
+func (*Geometry) helper() {
+}
+
+func (*Geometry) WsServices() []interface{} {
+	return []interface{}{&Units{}}
+}
+
+type Units struct{}
+
+func (*Units) WsName() string {
+	return "units"
+}
+
+func (*Units) ApiConvert(value float64, unit string) (float64, error) {
+	return value, nil
+}
+
+type secret struct{}
+
+type Internal struct{}
+
+func (*Internal) ApiSecret() (*secret, error) {
+	return nil, nil
+}
+
+type Explicit struct{}
+
+func (*Explicit) WsMethods() map[string]string {
+	return map[string]string{
+		"answer": "Answer",
+	}
+}
+
+func (*Explicit) Answer() (int, error) {
+	return 42, nil
+}
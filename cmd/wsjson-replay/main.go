@@ -0,0 +1,85 @@
+// Command wsjson-replay replays sessions recorded by a wsjson.Recorder against a server
+// and reports the responses that differ from the recorded ones.
+//
+//	wsjson-replay -url ws://localhost:8080/ws traffic.jsonl
+//
+// Each recorded connection is replayed on its own connection: each call is sent once
+// the previous one is answered, and the calls made by the server are answered with the
+// recorded responses.
+// The exit status is 1 when any response differs.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/manologab/wsjson"
+)
+
+var (
+	url      = flag.String("url", "", "websocket URL of the server; required")
+	origin   = flag.String("origin", "", "Origin header of the connections")
+	protocol = flag.String("protocol", "", "subprotocol requested, must use the JSON codec")
+	timeout  = flag.Duration("timeout", 5*time.Second, "time to wait for the responses of each session")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: wsjson-replay -url ws://host/path [-origin origin] [-protocol name] [-timeout d] recording.jsonl...\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("wsjson-replay: ")
+	flag.Usage = usage
+	flag.Parse()
+
+	if *url == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	header := http.Header{}
+	if *origin != "" {
+		header.Set("Origin", *origin)
+	}
+	if *protocol != "" {
+		header.Set("Sec-WebSocket-Protocol", *protocol)
+	}
+
+	var frames []wsjson.Frame
+	for _, name := range flag.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fileFrames, err := wsjson.ReadFrames(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+		frames = append(frames, fileFrames...)
+	}
+
+	sessions := wsjson.SplitSessions(frames)
+	failed := 0
+	for _, session := range sessions {
+		mismatches, err := replay(*url, header, session, *timeout)
+		if err != nil {
+			log.Fatalf("conn %s: %v", session[0].Conn, err)
+		}
+		for _, m := range mismatches {
+			fmt.Println(m.String())
+		}
+		failed += len(mismatches)
+	}
+
+	fmt.Printf("%d sessions replayed, %d responses differ\n", len(sessions), failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/manologab/wsjson"
+)
+
+// Replay a recorded session on a new connection and compare the responses
+func replay(url string, header http.Header, session []wsjson.Frame, timeout time.Duration) ([]wsjson.Mismatch, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// responses recorded to answer the calls of the server
+	answers := make(map[string]json.RawMessage)
+	var calls []json.RawMessage
+	for _, frame := range session {
+		if frame.Direction != wsjson.DirectionIn {
+			continue
+		}
+		method, key, ok := wsjson.MessageKey(frame.Payload)
+		if !ok {
+			continue
+		}
+		if method != "" {
+			calls = append(calls, frame.Payload)
+		} else if key != "" {
+			answers[key] = frame.Payload
+		}
+	}
+
+	// each call is sent once the previous one is answered, the server may handle
+	// the calls of a connection concurrently
+	var replayed []json.RawMessage
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	for _, call := range calls {
+		if err := conn.WriteMessage(websocket.TextMessage, call); err != nil {
+			return nil, err
+		}
+		_, key, _ := wsjson.MessageKey(call)
+		if key == "" {
+			continue
+		}
+		response, err := readResponse(conn, key, answers)
+		if err != nil {
+			// the missing responses are reported as mismatches
+			break
+		}
+		replayed = append(replayed, response)
+	}
+
+	return wsjson.CompareResponses(session, replayed), nil
+}
+
+// Read the response of a call, the calls of the server are answered meanwhile
+func readResponse(conn *websocket.Conn, key string, answers map[string]json.RawMessage) (json.RawMessage, error) {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		method, messageKey, ok := wsjson.MessageKey(data)
+		if !ok || messageKey == "" {
+			continue
+		}
+		if method != "" {
+			if answer, ok := answers[messageKey]; ok {
+				conn.WriteMessage(websocket.TextMessage, answer)
+			}
+			continue
+		}
+		if messageKey == key {
+			return data, nil
+		}
+	}
+}
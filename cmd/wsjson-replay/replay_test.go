@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/manologab/wsjson"
+)
+
+type Greeter struct{}
+
+func (*Greeter) ApiEcho(message string) (string, error) {
+	return message, nil
+}
+
+// Asks the name of the peer
+func (*Greeter) ApiHello(ctx context.Context) (string, error) {
+	var name string
+	if err := wsjson.ClientFromContext(ctx).Call(ctx, "Peer.Name", nil, &name); err != nil {
+		return "", err
+	}
+	return "Hello " + name, nil
+}
+
+// Keeps a value, set slowly
+type Store struct {
+	mutex sync.Mutex
+	value string
+}
+
+func (s *Store) ApiSet(value string) (bool, error) {
+	time.Sleep(50 * time.Millisecond)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.value = value
+	return true, nil
+}
+
+func (s *Store) ApiGet() (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.value, nil
+}
+
+func frame(direction, payload string) wsjson.Frame {
+	return wsjson.Frame{Direction: direction, Conn: "c1", Payload: json.RawMessage(payload)}
+}
+
+func TestReplay(t *testing.T) {
+	wsj := &wsjson.WsJson{}
+	wsj.AddService(&Greeter{})
+	wsj.AddService(&Store{})
+	server := httptest.NewServer(http.HandlerFunc(wsj.Handle))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	session := []wsjson.Frame{
+		frame(wsjson.DirectionIn, `{"jsonrpc": "2.0", "method": "Greeter.Echo", "params": ["hi"], "id": 1}`),
+		frame(wsjson.DirectionOut, `{"jsonrpc": "2.0", "result": "hi", "id": 1}`),
+		frame(wsjson.DirectionIn, `{"jsonrpc": "2.0", "method": "Greeter.Hello", "id": 2}`),
+		frame(wsjson.DirectionOut, `{"jsonrpc": "2.0", "method": "Peer.Name", "params": null, "id": 1}`),
+		frame(wsjson.DirectionIn, `{"jsonrpc": "2.0", "result": "ana", "id": 1}`),
+		frame(wsjson.DirectionOut, `{"jsonrpc": "2.0", "result": "Hello ana", "id": 2}`),
+		frame(wsjson.DirectionIn, `{"jsonrpc": "2.0", "method": "Greeter.Echo", "params": ["bye"], "id": 3.0}`),
+		frame(wsjson.DirectionOut, `{"jsonrpc": "2.0", "result": "adios", "id": 3}`),
+	}
+
+	mismatches, err := replay(url, nil, session, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 1 || string(mismatches[0].Id) != "3.0" || !strings.Contains(string(mismatches[0].Got), `"bye"`) {
+		t.Errorf("Only the changed response should differ, got: %v", mismatches)
+	}
+
+	// calls are sent once the previous one is answered
+	session = []wsjson.Frame{
+		frame(wsjson.DirectionIn, `{"jsonrpc": "2.0", "method": "Store.Set", "params": ["a"], "id": 1}`),
+		frame(wsjson.DirectionOut, `{"jsonrpc": "2.0", "result": true, "id": 1}`),
+		frame(wsjson.DirectionIn, `{"jsonrpc": "2.0", "method": "Store.Get", "id": 2}`),
+		frame(wsjson.DirectionOut, `{"jsonrpc": "2.0", "result": "a", "id": 2}`),
+	}
+	mismatches, err = replay(url, nil, session, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 0 {
+		t.Errorf("Calls should be replayed in order, got: %v", mismatches)
+	}
+
+	// responses not received, the call of the server is not answered
+	session = []wsjson.Frame{
+		frame(wsjson.DirectionIn, `{"jsonrpc": "2.0", "method": "Greeter.Hello", "id": 1}`),
+		frame(wsjson.DirectionOut, `{"jsonrpc": "2.0", "result": "Hello ana", "id": 1}`),
+	}
+	mismatches, err = replay(url, nil, session, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 1 || mismatches[0].Got != nil {
+		t.Errorf("The missing response should differ, got: %v", mismatches)
+	}
+}
@@ -0,0 +1,187 @@
+// Command wsjson is a client for wsjson servers, to make calls from the command line,
+// interactively or from scripts.
+//
+//	wsjson ws://localhost:8080/ws                              interactive session
+//	wsjson ws://localhost:8080/ws methods                      list the methods
+//	wsjson ws://localhost:8080/ws call Users.Get '{"id": 1}'   call a method
+//	wsjson ws://localhost:8080/ws notify Chat.Typing           send a notification
+//	wsjson ws://localhost:8080/ws run smoke.jsonl              run a script
+//
+// Responses and the events sent by the server are printed as indented JSON.
+// Scripts have one call per line, with the response expected, see runScript.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/manologab/wsjson"
+	"github.com/manologab/wsjson/codec/cbor"
+	"github.com/manologab/wsjson/codec/msgpack"
+)
+
+// Headers of the upgrade request, the flag can be repeated
+type headerFlag http.Header
+
+func (h headerFlag) String() string {
+	return fmt.Sprint(http.Header(h))
+}
+
+func (h headerFlag) Set(value string) error {
+	name, val, ok := strings.Cut(value, ":")
+	if !ok {
+		return fmt.Errorf("Invalid header, expected 'Name: value': %s", value)
+	}
+	http.Header(h).Add(strings.TrimSpace(name), strings.TrimSpace(val))
+	return nil
+}
+
+var (
+	headers     = headerFlag{}
+	protocol    = flag.String("protocol", "", "subprotocol requested")
+	codecName   = flag.String("codec", "json", "codec of the subprotocol: json, msgpack or cbor")
+	timeout     = flag.Duration("timeout", 10*time.Second, "time to wait for each response")
+	historyFile = flag.String("history", defaultHistory(), "history file of the interactive sessions, empty to disable")
+)
+
+var codecs = map[string]wsjson.Codec{
+	"json":    wsjson.JSONCodec,
+	"msgpack": msgpack.Codec,
+	"cbor":    cbor.Codec,
+}
+
+func defaultHistory() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".wsjson_history")
+}
+
+// Subprotocols requested, nil for plain JSON without subprotocol.
+// Other codecs need a subprotocol, the server wouldn't know how to decode the messages
+func clientProtocols(protocol, codecName string) ([]wsjson.Protocol, error) {
+	codec, ok := codecs[codecName]
+	if !ok {
+		return nil, fmt.Errorf("Unknown codec: %s", codecName)
+	}
+	if protocol == "" {
+		if codec != wsjson.JSONCodec {
+			return nil, fmt.Errorf("The codec %s requires a subprotocol, set it with -protocol", codecName)
+		}
+		return nil, nil
+	}
+	return []wsjson.Protocol{{Name: protocol, Features: wsjson.AllFeatures, Codec: codec}}, nil
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: wsjson [flags] url [methods | call method [params] | notify method [params] | run script.jsonl]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("wsjson: ")
+	flag.Var(headers, "H", "header of the upgrade request, 'Name: value'")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	protocols, err := clientProtocols(*protocol, *codecName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	wsj := &wsjson.WsJson{}
+	if protocols != nil {
+		wsj.SetProtocols(protocols...)
+	}
+	out := newPrinter(os.Stdout)
+	s, err := connect(wsj, flag.Arg(0), http.Header(headers), out, *timeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer s.close()
+
+	args := flag.Args()[1:]
+	if len(args) == 0 {
+		if err := repl(s, os.Stdin, *historyFile); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	switch command := args[0]; {
+	case command == "methods" && len(args) == 1:
+		err = s.printMethods()
+	case (command == "call" || command == "notify") && (len(args) == 2 || len(args) == 3):
+		params := ""
+		if len(args) == 3 {
+			params = args[2]
+		}
+		err = s.run(command == "notify", args[1], params)
+	case command == "run" && len(args) == 2:
+		var failed int
+		failed, err = runScriptFile(s, args[1])
+		if err == nil && failed > 0 {
+			s.close()
+			os.Exit(1)
+		}
+	default:
+		flag.Usage()
+		s.close()
+		os.Exit(2)
+	}
+	if err != nil {
+		s.close()
+		log.Fatal(err)
+	}
+}
+
+// Send a call or notification and print the response
+func (s *session) run(notify bool, method, params string) error {
+	p, err := parseParams(params)
+	if err != nil {
+		return err
+	}
+	if notify {
+		return s.notify(method, p)
+	}
+	result, err := s.call(method, p)
+	if rpcErr, ok := err.(*wsjson.Error); ok {
+		s.out.json("error ", rpcErr)
+		return fmt.Errorf("Call to %s failed", method)
+	}
+	if err != nil {
+		return err
+	}
+	s.out.json("", result)
+	return nil
+}
+
+func runScriptFile(s *session, name string) (int, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return runScript(s, f)
+}
+
+// Wait for the notifications sent to be written before closing
+func (s *session) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	s.client.Flush(ctx)
+	s.client.Close()
+}
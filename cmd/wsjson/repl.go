@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Lines of the history file loaded at the start
+const maxHistory = 500
+
+const replHelp = `Commands:
+  method [params]         call a method, params as JSON
+  notify method [params]  send a notification
+  methods                 list the methods
+  history                 list the previous commands
+  !n                      run the command n of the history
+  help                    show this help
+  quit                    end the session
+`
+
+// Interactive session reading commands from in
+func repl(s *session, in io.Reader, historyFile string) error {
+	history := loadHistory(historyFile)
+	var historyOut io.Writer
+	if historyFile != "" {
+		f, err := os.OpenFile(historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err == nil {
+			defer f.Close()
+			historyOut = f
+		}
+	}
+
+	s.out.printf("Connected, type help for the commands\n")
+	scanner := bufio.NewScanner(in)
+	for {
+		s.out.printf("> ")
+		if !scanner.Scan() {
+			s.out.printf("\n")
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "!") {
+			n, err := strconv.Atoi(line[1:])
+			if err != nil || n < 1 || n > len(history) {
+				s.out.printf("No command %s in the history\n", line[1:])
+				continue
+			}
+			line = history[n-1]
+			s.out.printf("%s\n", line)
+		}
+		if line == "quit" || line == "exit" {
+			return nil
+		}
+
+		if line != "history" {
+			history = append(history, line)
+			if historyOut != nil {
+				fmt.Fprintln(historyOut, line)
+			}
+		}
+		s.replCommand(line, history)
+	}
+}
+
+// Run a command of the interactive session, errors are printed
+func (s *session) replCommand(line string, history []string) {
+	command, rest, _ := strings.Cut(line, " ")
+	var err error
+	switch command {
+	case "help":
+		s.out.printf("%s", replHelp)
+	case "history":
+		for i, entry := range history {
+			s.out.printf("%4d  %s\n", i+1, entry)
+		}
+	case "methods":
+		err = s.printMethods()
+	case "notify":
+		method, params, _ := strings.Cut(strings.TrimSpace(rest), " ")
+		err = s.run(true, method, params)
+	default:
+		err = s.run(false, command, rest)
+	}
+	if err != nil {
+		s.out.printf("%v\n", err)
+	}
+}
+
+// Last commands of the history file
+func loadHistory(name string) []string {
+	if name == "" {
+		return nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var history []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			history = append(history, line)
+		}
+	}
+	if len(history) > maxHistory {
+		history = history[len(history)-maxHistory:]
+	}
+	return history
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/manologab/wsjson"
+)
+
+// A line of a script
+type scriptStep struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+	// Sent as a notification, no response is expected
+	Notify bool `json:"notify,omitempty"`
+	// Response expected, the response is printed when missing
+	Expect *expectation `json:"expect,omitempty"`
+}
+
+// Result or error expected, the message of the error is only compared when given
+type expectation struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *wsjson.Error   `json:"error,omitempty"`
+	// Result decoded by parseStep
+	result interface{}
+}
+
+// Run a script with one step per line, empty lines and lines starting with # are skipped:
+//
+//	{"method": "Users.Get", "params": {"id": 1}, "expect": {"result": {"id": 1, "name": "ana"}}}
+//	{"method": "Users.Get", "params": {"id": -1}, "expect": {"error": {"code": -32602}}}
+//	{"method": "Chat.Typing", "notify": true}
+//
+// Returns the number of steps whose response differs from the expected one
+func runScript(s *session, r io.Reader) (int, error) {
+	failed := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		step, err := parseStep(text)
+		if err != nil {
+			return failed, fmt.Errorf("Invalid step at line %d: %v: %s", line, err, text)
+		}
+		if err := s.runStep(line, step); err != nil {
+			s.out.printf("line %d %s: FAIL %v\n", line, step.Method, err)
+			failed++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return failed, err
+	}
+	s.out.printf("%d steps failed\n", failed)
+	return failed, nil
+}
+
+// Parse a line of a script and the result expected
+func parseStep(text string) (*scriptStep, error) {
+	var step scriptStep
+	if err := json.Unmarshal([]byte(text), &step); err != nil {
+		return nil, err
+	}
+	if step.Method == "" {
+		return nil, fmt.Errorf("method missing")
+	}
+	if step.Expect != nil {
+		if err := step.Expect.decodeResult(); err != nil {
+			return nil, err
+		}
+	}
+	return &step, nil
+}
+
+// Decode the result expected, the JSON null when it is missing
+func (e *expectation) decodeResult() error {
+	if len(e.Result) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(e.Result, &e.result); err != nil {
+		return fmt.Errorf("invalid result expected: %v", err)
+	}
+	return nil
+}
+
+// Run a step, returns the difference with the response expected
+func (s *session) runStep(line int, step *scriptStep) error {
+	params, err := parseParams(string(step.Params))
+	if err != nil {
+		return err
+	}
+	if step.Notify {
+		return s.notify(step.Method, params)
+	}
+
+	result, err := s.call(step.Method, params)
+	rpcErr, isRPCErr := err.(*wsjson.Error)
+	if err != nil && !isRPCErr {
+		return err
+	}
+	if step.Expect == nil {
+		if isRPCErr {
+			s.out.json(fmt.Sprintf("line %d %s: error ", line, step.Method), rpcErr)
+		} else {
+			s.out.json(fmt.Sprintf("line %d %s: ", line, step.Method), result)
+		}
+		return nil
+	}
+
+	if expected := step.Expect.Error; expected != nil {
+		if !isRPCErr {
+			return fmt.Errorf("expected error %d, got result %s", expected.Code, toJSON(result))
+		}
+		if rpcErr.Code != expected.Code || (expected.Message != "" && rpcErr.Message != expected.Message) {
+			return fmt.Errorf("expected error %s, got %s", toJSON(expected), toJSON(rpcErr))
+		}
+	} else {
+		if isRPCErr {
+			return fmt.Errorf("expected result %s, got error %s", step.Expect.Result, toJSON(rpcErr))
+		}
+		expected := step.Expect.result
+		// numbers decoded by other codecs are compared as JSON numbers
+		json.Unmarshal([]byte(toJSON(result)), &result)
+		if !reflect.DeepEqual(expected, result) {
+			return fmt.Errorf("expected result %s, got %s", toJSON(expected), toJSON(result))
+		}
+	}
+	s.out.printf("line %d %s: ok\n", line, step.Method)
+	return nil
+}
+
+func toJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/manologab/wsjson"
+	"github.com/manologab/wsjson/wsjsontest"
+)
+
+type Users struct{}
+
+type User struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func (*Users) ApiGet(id int) (*User, error) {
+	if id < 0 {
+		return nil, wsjson.NewError(wsjson.ErrorInvalidParams, "Invalid id")
+	}
+	return &User{id, "ana"}, nil
+}
+
+// Sends an event to the caller
+func (*Users) ApiPing(ctx context.Context) (bool, error) {
+	return true, wsjson.ClientFromContext(ctx).SendEvent("users.pong", []int{1})
+}
+
+func newSession(t *testing.T) (*session, *syncBuffer) {
+	server := wsjsontest.NewServer(t, &Users{})
+	out := &syncBuffer{}
+	s, err := connect(&wsjson.WsJson{}, server.URL, nil, newPrinter(out), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.close)
+	return s, out
+}
+
+// Output written by the events too
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (sb *syncBuffer) Write(p []byte) (int, error) {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	return sb.buf.Write(p)
+}
+
+func (sb *syncBuffer) String() string {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	return sb.buf.String()
+}
+
+func TestRunScript(t *testing.T) {
+	s, out := newSession(t)
+
+	script := `
+# users
+{"method": "Users.Get", "params": [1], "expect": {"result": {"id": 1, "name": "ana"}}}
+{"method": "Users.Get", "params": [-1], "expect": {"error": {"code": -32602}}}
+{"method": "Users.Get", "params": [-1], "expect": {"error": {"code": -32602, "message": "Invalid id"}}}
+{"method": "Users.Get", "params": [2], "expect": {"result": {"id": 1, "name": "ana"}}}
+{"method": "Users.Get", "params": [2], "expect": {"error": {"code": -32602}}}
+{"method": "Users.Missing", "expect": {"result": null}}
+{"method": "Users.Get", "params": [3]}
+{"method": "Users.Event", "notify": true}
+`
+	failed, err := runScript(s, strings.NewReader(script))
+	if err != nil || failed != 3 {
+		t.Errorf("3 steps should fail, got: %d %v\n%s", failed, err, out.String())
+	}
+
+	var expected = []string{
+		"line 3 Users.Get: ok\n",
+		"line 5 Users.Get: ok\n",
+		"line 6 Users.Get: FAIL expected result {\"id\":1,\"name\":\"ana\"}, got {\"id\":2,\"name\":\"ana\"}\n",
+		"line 7 Users.Get: FAIL expected error -32602, got result",
+		"line 8 Users.Missing: FAIL expected result null, got error",
+		"line 9 Users.Get: {\n  \"id\": 3,\n  \"name\": \"ana\"\n}\n",
+		"3 steps failed\n",
+	}
+	for _, exp := range expected {
+		if !strings.Contains(out.String(), exp) {
+			t.Errorf("Output should contain %q, got:\n%s", exp, out.String())
+		}
+	}
+
+	if _, err := runScript(s, strings.NewReader(`{"params": []}`)); err == nil {
+		t.Errorf("Steps without method should fail")
+	}
+}
+
+func TestReplCommands(t *testing.T) {
+	s, out := newSession(t)
+
+	input := "methods\nUsers.Get [4]\nUsers.Get nope\nUsers.Ping\nhistory\n!2\n!9\nquit\n"
+	if err := repl(s, strings.NewReader(input), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var expected = []string{
+		"Users.Get\nUsers.Ping\n",
+		"\"id\": 4",
+		"Invalid params, they must be JSON",
+		"event users.pong [\n  1\n]\n",
+		"   2  Users.Get [4]\n",
+		"> Users.Get [4]\n",
+		"No command 9 in the history\n",
+	}
+	deadline := time.Now().Add(time.Second)
+	for _, exp := range expected {
+		// events are printed when they arrive
+		for !strings.Contains(out.String(), exp) && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if !strings.Contains(out.String(), exp) {
+			t.Errorf("Output should contain %q, got:\n%s", exp, out.String())
+		}
+	}
+}
+
+func TestParseStep(t *testing.T) {
+	var tests = []struct {
+		text string
+		err  string
+	}{
+		{`{"method": "Users.Get", "expect": {"result": {"id": 1}}}`, ""},
+		{`{"method": "Users.Get", "expect": {"error": {"code": -32602}}}`, ""},
+		{`{"params": []}`, "method missing"},
+		{`{"method": "Users.Get", "expect": {"result": {"id": }}}`, "invalid character"},
+	}
+	for _, test := range tests {
+		_, err := parseStep(test.text)
+		if test.err == "" && err != nil {
+			t.Errorf("Step %s should be valid, got: %v", test.text, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("Step %s should fail with %q, got: %v", test.text, test.err, err)
+		}
+	}
+
+	// results decoded by other means are checked too
+	e := &expectation{Result: []byte(`{"id": `)}
+	if err := e.decodeResult(); err == nil || !strings.HasPrefix(err.Error(), "invalid result expected") {
+		t.Errorf("Invalid results expected should fail, got: %v", err)
+	}
+
+	s, _ := newSession(t)
+	_, err := runScript(s, strings.NewReader("\n{\"method\": \"Users.Get\", \"expect\": {\"result\": }}\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "Invalid step at line 2") {
+		t.Errorf("Invalid steps should report their line, got: %v", err)
+	}
+}
+
+func TestClientProtocols(t *testing.T) {
+	var tests = []struct {
+		protocol, codec string
+		protocols       int
+		fails           bool
+	}{
+		{"", "json", 0, false},
+		{"", "msgpack", 0, true},
+		{"", "cbor", 0, true},
+		{"wsjson.v1.msgpack", "msgpack", 1, false},
+		{"wsjson.v1", "json", 1, false},
+		{"wsjson.v1", "xml", 0, true},
+	}
+	for _, test := range tests {
+		protocols, err := clientProtocols(test.protocol, test.codec)
+		if (err != nil) != test.fails || len(protocols) != test.protocols {
+			t.Errorf("Protocol %q with codec %s: got %v %v", test.protocol, test.codec, protocols, err)
+		}
+		if len(protocols) == 1 && (protocols[0].Name != test.protocol || protocols[0].Codec != codecs[test.codec]) {
+			t.Errorf("Protocol %q with codec %s: got %+v", test.protocol, test.codec, protocols[0])
+		}
+	}
+}
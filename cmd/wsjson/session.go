@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/manologab/wsjson"
+)
+
+// Prints the responses and the events, which arrive at any time
+type printer struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func newPrinter(w io.Writer) *printer {
+	return &printer{w: w}
+}
+
+func (p *printer) printf(format string, a ...interface{}) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	fmt.Fprintf(p.w, format, a...)
+}
+
+// Print a value as indented JSON after the prefix
+func (p *printer) json(prefix string, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		p.printf("%s%v\n", prefix, v)
+		return
+	}
+	p.printf("%s%s\n", prefix, data)
+}
+
+// A connection to the server
+type session struct {
+	client  *wsjson.WsJsonClient
+	out     *printer
+	timeout time.Duration
+}
+
+// Connect to the server, the events it sends are printed
+func connect(wsj *wsjson.WsJson, url string, header http.Header, out *printer, timeout time.Duration) (*session, error) {
+	wsj.SetNotificationFallback(func(ctx context.Context, method string, params wsjson.RawMessage) {
+		var v interface{}
+		if len(params) > 0 {
+			if err := codecOf(wsjson.ClientFromContext(ctx)).Unmarshal(params, &v); err != nil {
+				v = string(params)
+			}
+		}
+		out.json(fmt.Sprintf("event %s ", method), v)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	client, err := wsj.Dial(ctx, url, header)
+	if err != nil {
+		return nil, err
+	}
+	return &session{client: client, out: out, timeout: timeout}, nil
+}
+
+func codecOf(client *wsjson.WsJsonClient) wsjson.Codec {
+	if client != nil && client.Protocol().Codec != nil {
+		return client.Protocol().Codec
+	}
+	return wsjson.JSONCodec
+}
+
+// Params given as JSON, nil if empty
+func parseParams(params string) (interface{}, error) {
+	if len(bytes.TrimSpace([]byte(params))) == 0 {
+		return nil, nil
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(params), &v); err != nil {
+		return nil, fmt.Errorf("Invalid params, they must be JSON: %v", err)
+	}
+	return v, nil
+}
+
+// Call a method and wait for its result
+func (s *session) call(method string, params interface{}) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	var result interface{}
+	err := s.client.Call(ctx, method, params, &result)
+	return result, err
+}
+
+func (s *session) notify(method string, params interface{}) error {
+	return s.client.SendEvent(method, params)
+}
+
+// Methods of the connection, the server may not provide discovery
+func (s *session) methods() ([]string, error) {
+	result, err := s.call(wsjson.MethodListMethods, nil)
+	var rpcErr *wsjson.Error
+	if errors.As(err, &rpcErr) && rpcErr.Code == wsjson.ErrorMethodNotFound {
+		return nil, errors.New("The server doesn't list its methods")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	list, _ := result.([]interface{})
+	names := make([]string, 0, len(list))
+	for _, name := range list {
+		names = append(names, fmt.Sprint(name))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *session) printMethods() error {
+	names, err := s.methods()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		s.out.printf("%s\n", name)
+	}
+	return nil
+}
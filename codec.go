@@ -0,0 +1,115 @@
+package wsjson
+
+import (
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+)
+
+// Encodes the messages of a connection, envelope and params
+type Codec interface {
+	// Type of the websocket frames, websocket.TextMessage or websocket.BinaryMessage
+	MessageType() int
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// The default codec, JSON in text frames
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) MessageType() int {
+	return websocket.TextMessage
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// A raw encoded value, its decoding is delayed until the types are known.
+// It keeps the bytes as they are with the JSON, MessagePack and CBOR codecs
+type RawMessage []byte
+
+var (
+	jsonNull   = []byte("null")
+	msgpackNil = []byte{0xc0}
+	cborNull   = []byte{0xf6}
+)
+
+func (m RawMessage) raw(null []byte) ([]byte, error) {
+	if m == nil {
+		return null, nil
+	}
+	return m, nil
+}
+
+func (m *RawMessage) set(data []byte) error {
+	*m = append((*m)[0:0], data...)
+	return nil
+}
+
+func (m RawMessage) MarshalJSON() ([]byte, error) {
+	return m.raw(jsonNull)
+}
+
+func (m *RawMessage) UnmarshalJSON(data []byte) error {
+	return m.set(data)
+}
+
+func (m RawMessage) MarshalMsgpack() ([]byte, error) {
+	return m.raw(msgpackNil)
+}
+
+func (m *RawMessage) UnmarshalMsgpack(data []byte) error {
+	return m.set(data)
+}
+
+func (m RawMessage) MarshalCBOR() ([]byte, error) {
+	return m.raw(cborNull)
+}
+
+func (m *RawMessage) UnmarshalCBOR(data []byte) error {
+	return m.set(data)
+}
+
+// Id of a request as an integer, codecs decode numbers to different types
+func requestId(id interface{}) (int, bool) {
+	switch n := id.(type) {
+	case float64:
+		return int(n), n == float64(int(n))
+	case float32:
+		return int(n), n == float32(int(n))
+	case int:
+		return n, true
+	case int8:
+		return int(n), true
+	case int16:
+		return int(n), true
+	case int32:
+		return int(n), true
+	case int64:
+		return int(n), true
+	case uint8:
+		return int(n), true
+	case uint16:
+		return int(n), true
+	case uint32:
+		return int(n), true
+	case uint64:
+		return int(n), true
+	}
+	return 0, false
+}
+
+// Codec of the connection
+func (wsjc *WsJsonClient) codec() Codec {
+	if codec := wsjc.Protocol().Codec; codec != nil {
+		return codec
+	}
+	return JSONCodec
+}
@@ -0,0 +1,46 @@
+// Package cbor provides a CBOR codec for wsjson connections,
+// structs are encoded using their json tags.
+package cbor
+
+import (
+	"reflect"
+
+	fxcbor "github.com/fxamacker/cbor/v2"
+	"github.com/gorilla/websocket"
+	"github.com/manologab/wsjson"
+)
+
+// CBOR in binary frames
+var Codec wsjson.Codec = newCodec()
+
+type codec struct {
+	enc fxcbor.EncMode
+	dec fxcbor.DecMode
+}
+
+func newCodec() *codec {
+	enc, err := fxcbor.EncOptions{}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	// maps are decoded as in JSON
+	dec, err := fxcbor.DecOptions{
+		DefaultMapType: reflect.TypeOf(map[string]interface{}(nil)),
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return &codec{enc: enc, dec: dec}
+}
+
+func (c *codec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+func (c *codec) Marshal(v interface{}) ([]byte, error) {
+	return c.enc.Marshal(v)
+}
+
+func (c *codec) Unmarshal(data []byte, v interface{}) error {
+	return c.dec.Unmarshal(data, v)
+}
@@ -0,0 +1,57 @@
+// Package msgpack provides a MessagePack codec for wsjson connections,
+// structs are encoded using their json tags.
+package msgpack
+
+import (
+	"bytes"
+
+	"github.com/gorilla/websocket"
+	"github.com/manologab/wsjson"
+	vmsgpack "github.com/vmihailenco/msgpack/v5"
+)
+
+// MessagePack in binary frames
+var Codec wsjson.Codec = codec{}
+
+type codec struct{}
+
+func (codec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := vmsgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	if err := decode(data, v); err != nil {
+		return err
+	}
+	// nil values aren't passed to RawMessage.UnmarshalMsgpack, a null result
+	// would leave the response without result and it would be taken for a call
+	if request, ok := v.(*wsjson.Request); ok && request.Result == nil && request.Err == nil && request.Method == "" {
+		var fields map[string]vmsgpack.RawMessage
+		if decode(data, &fields) == nil {
+			if _, ok := fields["result"]; ok {
+				request.Result = wsjson.RawMessage(nilCode)
+			}
+		}
+	}
+	return nil
+}
+
+// Encoding of nil
+var nilCode = []byte{0xc0}
+
+func decode(data []byte, v interface{}) error {
+	dec := vmsgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
@@ -0,0 +1,152 @@
+package msgpack
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/manologab/wsjson"
+)
+
+type Point struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type GeoService struct{}
+
+func (*GeoService) ApiMove(p Point) (*Point, error) {
+	return &Point{X: p.X + 1, Y: p.Y + 1}, nil
+}
+
+func (*GeoService) ApiDistance(a, b int) (int, error) {
+	return b - a, nil
+}
+
+func (*GeoService) ApiNothing() (*int, error) {
+	return nil, nil
+}
+
+func TestCodec(t *testing.T) {
+	wsj := &wsjson.WsJson{}
+	wsj.SetProtocols(wsjson.Protocol{Name: "wsjson+msgpack", Features: wsjson.AllFeatures, Codec: Codec})
+	wsj.AddService(&GeoService{})
+	server := httptest.NewServer(http.HandlerFunc(wsj.Handle))
+	defer server.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{"wsjson+msgpack"}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var table = []struct {
+		method string
+		params interface{}
+		result interface{}
+	}{
+		{"GeoService.Move", Point{1, 2}, &Point{2, 3}},
+		{"GeoService.Distance", []int{3, 300}, 297},
+	}
+
+	for i, row := range table {
+		params, err := Codec.Marshal(row.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request, err := Codec.Marshal(&wsjson.Request{Version: wsjson.JSONRPCVersion, Method: row.method, Params: params, Id: i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.WriteMessage(websocket.BinaryMessage, request); err != nil {
+			t.Fatal(err)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp wsjson.Request
+		if err := Codec.Unmarshal(data, &resp); err != nil {
+			t.Fatal(err)
+		}
+		result := reflect.New(reflect.TypeOf(row.result))
+		if err := Codec.Unmarshal(resp.Result, result.Interface()); err != nil {
+			t.Fatal(err)
+		}
+		if messageType != websocket.BinaryMessage || resp.Err != nil || !reflect.DeepEqual(result.Elem().Interface(), row.result) {
+			t.Errorf("Invalid response for %s, expected: %#v, got: %d %#v %v", row.method, row.result, messageType, result.Elem(), resp.Err)
+		}
+	}
+}
+
+func TestCallMethod(t *testing.T) {
+	protocol := wsjson.Protocol{Name: "wsjson+msgpack", Features: wsjson.AllFeatures, Codec: Codec}
+	wsj := &wsjson.WsJson{}
+	wsj.SetProtocols(protocol)
+	wsj.AddService(&GeoService{})
+	server := httptest.NewServer(http.HandlerFunc(wsj.Handle))
+	defer server.Close()
+
+	dialer := &wsjson.WsJson{}
+	dialer.SetProtocols(protocol)
+	client, err := dialer.Dial(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ch, err := client.CallMethod("GeoService.Move", Point{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case raw := <-ch:
+		var p Point
+		if err := client.Decode(raw, &p); err != nil || p != (Point{2, 3}) {
+			t.Errorf("Results should be decoded with the codec, got: %v %+v", err, p)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("CallMethod should receive the result")
+	}
+}
+
+func TestNullResult(t *testing.T) {
+	protocol := wsjson.Protocol{Name: "wsjson+msgpack", Features: wsjson.AllFeatures, Codec: Codec}
+	wsj := &wsjson.WsJson{}
+	wsj.SetProtocols(protocol)
+	wsj.AddService(&GeoService{})
+	server := httptest.NewServer(http.HandlerFunc(wsj.Handle))
+	defer server.Close()
+
+	dialer := &wsjson.WsJson{}
+	dialer.SetProtocols(protocol)
+	client, err := dialer.Dial(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	result := new(int)
+	if err := client.Call(ctx, "GeoService.Nothing", nil, &result); err != nil || result != nil {
+		t.Errorf("Null results should be received, got: %v %v", err, result)
+	}
+
+	data, err := Codec.Marshal(&wsjson.Response{Version: wsjson.JSONRPCVersion, Result: nil, Id: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response wsjson.Request
+	if err := Codec.Unmarshal(data, &response); err != nil || response.Result == nil {
+		t.Errorf("Null results should be kept, got: %v %#v", err, response.Result)
+	}
+}
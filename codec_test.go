@@ -0,0 +1,66 @@
+package wsjson
+
+import "testing"
+
+func TestRawMessage(t *testing.T) {
+	var table = []struct {
+		msg    string
+		params string
+		result string
+	}{
+		{`{"jsonrpc": "2.0", "method": "m", "params": [1,{"a":"b"}], "id": 1}`, `[1,{"a":"b"}]`, ""},
+		{`{"jsonrpc": "2.0", "method": "m", "params": null}`, `null`, ""},
+		{`{"jsonrpc": "2.0", "result": "ok", "id": 1}`, "", `"ok"`},
+	}
+
+	for _, row := range table {
+		var request Request
+		if err := JSONCodec.Unmarshal([]byte(row.msg), &request); err != nil {
+			t.Fatal(err)
+		}
+		if string(request.Params) != row.params || string(request.Result) != row.result {
+			t.Errorf("Invalid raw messages for %s, got: %s %s", row.msg, request.Params, request.Result)
+		}
+
+		// raw messages are encoded as they are
+		encoded, err := JSONCodec.Marshal([]RawMessage{request.Params, request.Result})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "[" + orNull(row.params) + "," + orNull(row.result) + "]"
+		if string(encoded) != expected {
+			t.Errorf("Invalid encoding of %s, expected: %s, got: %s", row.msg, expected, encoded)
+		}
+	}
+}
+
+func orNull(raw string) string {
+	if raw == "" {
+		return "null"
+	}
+	return raw
+}
+
+func TestRequestId(t *testing.T) {
+	var table = []struct {
+		id    interface{}
+		value int
+		ok    bool
+	}{
+		{float64(12), 12, true},
+		{float64(1.5), 1, false},
+		{int8(-3), -3, true},
+		{uint16(300), 300, true},
+		{uint64(70000), 70000, true},
+		{int64(5), 5, true},
+		{"12", 0, false},
+		{nil, 0, false},
+	}
+
+	for _, row := range table {
+		value, ok := requestId(row.id)
+		if value != row.value || ok != row.ok {
+			t.Errorf("Invalid id for %#v, expected: %d %v, got: %d %v", row.id, row.value, row.ok, value, ok)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package wsjson
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// Per-message compression (permessage-deflate) options
+type Compression struct {
+	// Level of compress/flate, 0 keeps the default of the websocket package, flate.BestSpeed
+	Level int
+	// Messages smaller than this number of bytes are sent uncompressed
+	MinSize int
+}
+
+// Bytes sent on connections with compression negotiated
+type CompressionStats struct {
+	// Messages sent and how many of them were compressed
+	Messages           int64
+	CompressedMessages int64
+	// Size of the messages before compression
+	Bytes int64
+	// Bytes written to the network for the messages, including the frame headers
+	WireBytes int64
+}
+
+// Wire bytes by message byte, lower is better, 1 without messages
+func (cs CompressionStats) Ratio() float64 {
+	if cs.Bytes == 0 {
+		return 1
+	}
+	return float64(cs.WireBytes) / float64(cs.Bytes)
+}
+
+type compressionCounters struct {
+	messages           atomic.Int64
+	compressedMessages atomic.Int64
+	bytes              atomic.Int64
+	wireBytes          atomic.Int64
+}
+
+func (cc *compressionCounters) add(size int, wireBytes int64, compressed bool) {
+	cc.messages.Add(1)
+	if compressed {
+		cc.compressedMessages.Add(1)
+	}
+	cc.bytes.Add(int64(size))
+	cc.wireBytes.Add(wireBytes)
+}
+
+func (cc *compressionCounters) stats() CompressionStats {
+	return CompressionStats{
+		Messages:           cc.messages.Load(),
+		CompressedMessages: cc.compressedMessages.Load(),
+		Bytes:              cc.bytes.Load(),
+		WireBytes:          cc.wireBytes.Load(),
+	}
+}
+
+// Compression of a connection, tracks the bytes written to the network
+type connCompression struct {
+	options *Compression
+	written atomic.Int64
+	conn    compressionCounters
+	// shared by all the connections
+	total *compressionCounters
+}
+
+// Net conn counting the bytes written
+type countingConn struct {
+	net.Conn
+	written *atomic.Int64
+}
+
+func (cc *countingConn) Write(b []byte) (int, error) {
+	n, err := cc.Conn.Write(b)
+	cc.written.Add(int64(n))
+	return n, err
+}
+
+// Response writer hijacking a counting conn for the upgrader
+type countingResponseWriter struct {
+	http.ResponseWriter
+	written *atomic.Int64
+}
+
+func (cw *countingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("Response does not implement http.Hijacker")
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &countingConn{Conn: conn, written: cw.written}, brw, nil
+}
+
+// Enable per-message compression, nil disables it.
+// Must be called after SetUpgrader as it changes the upgrader
+func (wsj *WsJson) SetCompression(compression *Compression) {
+	wsj.compression = compression
+	wsj.upgrader().EnableCompression = compression != nil
+}
+
+// CompressionStats returns the totals of all the connections with compression
+func (wsj *WsJson) CompressionStats() CompressionStats {
+	return wsj.compressionTotals.stats()
+}
+
+// Compression of a new connection, nil if the client doesn't support it.
+// The response writer counts the bytes written once upgraded
+func (wsj *WsJson) newConnCompression(w http.ResponseWriter, r *http.Request) (*connCompression, http.ResponseWriter) {
+	if wsj.compression == nil || !offersDeflate(r) {
+		return nil, w
+	}
+	cc := &connCompression{options: wsj.compression, total: &wsj.compressionTotals}
+	return cc, &countingResponseWriter{ResponseWriter: w, written: &cc.written}
+}
+
+// Whether the client offers permessage-deflate
+func offersDeflate(r *http.Request) bool {
+	for _, header := range r.Header.Values("Sec-Websocket-Extensions") {
+		for _, ext := range strings.Split(header, ",") {
+			name, _, _ := strings.Cut(ext, ";")
+			if strings.EqualFold(strings.TrimSpace(name), "permessage-deflate") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Write a message to a connection compressing it if big enough
+func (wsjc *WsJsonClient) writeMessage(conn *websocket.Conn, messageType int, data []byte) error {
+	cc := wsjc.connCompression()
+	if cc == nil {
+		return conn.WriteMessage(messageType, data)
+	}
+
+	compress := len(data) >= cc.options.MinSize
+	conn.EnableWriteCompression(compress)
+	before := cc.written.Load()
+	if err := conn.WriteMessage(messageType, data); err != nil {
+		return err
+	}
+	wireBytes := cc.written.Load() - before
+	cc.conn.add(len(data), wireBytes, compress)
+	cc.total.add(len(data), wireBytes, compress)
+	return nil
+}
+
+// CompressionStats returns the bytes sent by the connection,
+// all zero if compression wasn't negotiated
+func (wsjc *WsJsonClient) CompressionStats() CompressionStats {
+	cc := wsjc.connCompression()
+	if cc == nil {
+		return CompressionStats{}
+	}
+	return cc.conn.stats()
+}
+
+// Compression of the current connection
+func (wsjc *WsJsonClient) connCompression() *connCompression {
+	wsjc.connMutex.Lock()
+	defer wsjc.connMutex.Unlock()
+	return wsjc.compression
+}
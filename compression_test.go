@@ -0,0 +1,87 @@
+package wsjson
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+type RepeatService struct {
+	clients chan *WsJsonClient
+}
+
+func (rs *RepeatService) ApiRepeat(ctx context.Context, text string, times int) (string, error) {
+	rs.clients <- ClientFromContext(ctx)
+	return strings.Repeat(text, times), nil
+}
+
+func TestCompression(t *testing.T) {
+	wsj := &WsJson{}
+	wsj.SetCompression(&Compression{Level: 9, MinSize: 256})
+	clients := make(chan *WsJsonClient, 2)
+	wsj.AddService(&RepeatService{clients})
+	server := startServer(t, wsj)
+
+	dialer := websocket.Dialer{EnableCompression: true}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	small := roundTrip(t, conn, `{"jsonrpc": "2.0", "method": "RepeatService.Repeat", "params": ["a", 10], "id": 1}`)
+	large := roundTrip(t, conn, `{"jsonrpc": "2.0", "method": "RepeatService.Repeat", "params": ["abc", 1000], "id": 2}`)
+	if small.Err != nil || large.Err != nil || len(large.Result.(string)) != 3000 {
+		t.Fatalf("Invalid responses: %+v %+v", small, large)
+	}
+
+	client := <-clients
+	<-clients
+	stats := client.CompressionStats()
+	if stats.Messages != 2 || stats.CompressedMessages != 1 {
+		t.Errorf("Only the large message should be compressed: %+v", stats)
+	}
+	if stats.Bytes < 3000 || stats.Ratio() > 0.2 {
+		t.Errorf("Invalid compression ratio %f: %+v", stats.Ratio(), stats)
+	}
+	if wsj.CompressionStats() != stats {
+		t.Errorf("Totals should include the connection, expected: %+v, got: %+v", stats, wsj.CompressionStats())
+	}
+
+	// clients without compression support
+	conn2, _, err := dialServer(server, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	roundTrip(t, conn2, `{"jsonrpc": "2.0", "method": "RepeatService.Repeat", "params": ["abc", 1000], "id": 1}`)
+	if stats := (<-clients).CompressionStats(); stats.Messages != 0 {
+		t.Errorf("Connections without compression shouldn't have stats: %+v", stats)
+	}
+}
+
+func TestOffersDeflate(t *testing.T) {
+	var table = []struct {
+		extensions []string
+		result     bool
+	}{
+		{nil, false},
+		{[]string{"permessage-deflate"}, true},
+		{[]string{"permessage-deflate; client_max_window_bits"}, true},
+		{[]string{"x-webkit-deflate-frame, PERMESSAGE-DEFLATE"}, true},
+		{[]string{"x-webkit-deflate-frame"}, false},
+	}
+
+	for _, row := range table {
+		r, _ := http.NewRequest("GET", "/", nil)
+		for _, ext := range row.extensions {
+			r.Header.Add("Sec-WebSocket-Extensions", ext)
+		}
+		if result := offersDeflate(r); result != row.result {
+			t.Errorf("Invalid result for %v, expected: %v, got: %v", row.extensions, row.result, result)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package wsjson
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// Receives the notifications of the peer to methods that are not available
+type NotificationFallback func(ctx context.Context, method string, params RawMessage)
+
+// Set the handler of the notifications to methods that are not available,
+// without it they are answered with ErrorMethodNotFound
+func (wsj *WsJson) SetNotificationFallback(fallback NotificationFallback) {
+	wsj.notificationFallback = fallback
+}
+
+// Options shared by the connections served and dialed
+func (wsj *WsJson) configureClient(client *WsJsonClient) {
+	client.tracer = wsj.tracer
+	client.notificationFallback = wsj.notificationFallback
+	if wsj.recorder != nil {
+		client.recorder = wsj.recorder
+		client.connId = newConnId()
+	}
+}
+
+// Dial connects to a wsjson server. The server can call the global services of wsj,
+// the subprotocols of wsj are requested unless the header sets Sec-WebSocket-Protocol
+func (wsj *WsJson) Dial(ctx context.Context, url string, header http.Header) (*WsJsonClient, error) {
+	conn, err := wsj.dialConn(ctx, url, header)
+	if err != nil {
+		return nil, err
+	}
+	client, err := wsj.newDialedClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	client.serve()
+	return client, nil
+}
+
+func (wsj *WsJson) dialConn(ctx context.Context, url string, header http.Header) (*websocket.Conn, error) {
+	dialer := &websocket.Dialer{
+		Proxy:             http.ProxyFromEnvironment,
+		HandshakeTimeout:  websocket.DefaultDialer.HandshakeTimeout,
+		ReadBufferSize:    defReadBufferSize,
+		WriteBufferSize:   defWriteBufferSize,
+		EnableCompression: wsj.compression != nil,
+	}
+	if header.Get("Sec-WebSocket-Protocol") == "" {
+		for _, protocol := range wsj.protocols {
+			dialer.Subprotocols = append(dialer.Subprotocols, protocol.Name)
+		}
+	}
+
+	conn, _, err := dialer.DialContext(ctx, url, header)
+	return conn, err
+}
+
+// Client of a dialed connection, not served yet
+func (wsj *WsJson) newDialedClient(conn *websocket.Conn) (*WsJsonClient, error) {
+	client, err := newWsJsonClient(wsj.newServiceManager(), conn, nil)
+	if err != nil {
+		return nil, err
+	}
+	for i := range wsj.protocols {
+		if wsj.protocols[i].Name == conn.Subprotocol() {
+			client.protocol = &wsj.protocols[i]
+		}
+	}
+	wsj.configureClient(client)
+	if client.Protocol().Has(FeatureSessions) {
+		client.tracker = &sessionTracker{}
+	}
+	return client, nil
+}
+
+// Flush waits until the messages queued before are written to the connection
+func (wsjc *WsJsonClient) Flush(ctx context.Context) error {
+	flush := make(flushRequest)
+	if err := wsjc.sendContext(ctx, flush); err != nil {
+		return err
+	}
+	select {
+	case <-flush:
+		return nil
+	case <-wsjc.done:
+		return ErrConnectionClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close the connection, pending calls fail with ErrConnectionClosed
+func (wsjc *WsJsonClient) Close() error {
+	wsjc.close()
+	return nil
+}
+
+// Done is closed once the connection is closed
+func (wsjc *WsJsonClient) Done() <-chan struct{} {
+	return wsjc.done
+}
@@ -0,0 +1,94 @@
+// Command sampleserver serves a few methods with known costs, to measure the
+// dispatcher and the codecs with wsjson-bench:
+//
+//	go run ./examples/sampleserver -addr :8080
+//	wsjson-bench ws://localhost:8080/ws examples/sampleserver/scenario.json
+//	wsjson-bench -protocol wsjson.v2+msgpack -codec msgpack ws://localhost:8080/ws examples/sampleserver/scenario.json
+//
+// The metrics of the server are served at /metrics.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/manologab/wsjson"
+	"github.com/manologab/wsjson/codec/cbor"
+	"github.com/manologab/wsjson/codec/msgpack"
+)
+
+type Sample struct{}
+
+type Item struct {
+	Id    int      `json:"id"`
+	Name  string   `json:"name"`
+	Price float64  `json:"price"`
+	Tags  []string `json:"tags"`
+}
+
+// Returns its param
+func (*Sample) ApiEcho(s string) (string, error) {
+	return s, nil
+}
+
+func (*Sample) ApiAdd(a, b int) (int, error) {
+	return a + b, nil
+}
+
+// Answers after ms milliseconds, or when the call is cancelled
+func (*Sample) ApiSleep(ctx context.Context, ms int) (bool, error) {
+	timer := time.NewTimer(time.Duration(ms) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// Returns n items, to measure the codecs with larger results.
+// Results over the message limit of the clients close their connection, about 50 items with JSON
+func (*Sample) ApiItems(n int) ([]Item, error) {
+	if n < 0 || n > 100000 {
+		return nil, wsjson.NewError(wsjson.ErrorInvalidParams, "n must be between 0 and 100000")
+	}
+	items := make([]Item, n)
+	for i := range items {
+		items[i] = Item{Id: i, Name: "item " + strings.Repeat("x", i%16), Price: float64(i) * 1.5, Tags: []string{"a", "b"}}
+	}
+	return items, nil
+}
+
+// Always fails
+func (*Sample) ApiFail() (bool, error) {
+	return false, wsjson.NewError(wsjson.ErrorInvalidParams, "Failed on purpose")
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	flag.Parse()
+
+	wsj := &wsjson.WsJson{}
+	wsj.AllowAllOrigins(true)
+	wsj.SetProtocols(
+		wsjson.Protocol{Name: "wsjson.v2", Features: wsjson.AllFeatures},
+		wsjson.Protocol{Name: "wsjson.v2+msgpack", Features: wsjson.AllFeatures, Codec: msgpack.Codec},
+		wsjson.Protocol{Name: "wsjson.v2+cbor", Features: wsjson.AllFeatures, Codec: cbor.Codec},
+	)
+	if err := wsj.AddService(&Sample{}); err != nil {
+		log.Fatal(err)
+	}
+	metrics := wsjson.NewPrometheusMetrics()
+	wsj.SetMetrics(metrics)
+	wsj.SetHTTPFallback(true)
+
+	http.HandleFunc("/ws", wsj.Handle)
+	http.Handle("/metrics", metrics)
+	log.Printf("Listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
@@ -0,0 +1,64 @@
+package wsjson
+
+import "context"
+
+// A function registered as a method
+type funcMethod[P, R any] struct {
+	fn func(context.Context, P) (R, error)
+}
+
+func (fm *funcMethod[P, R]) call(ctx context.Context, codec Codec, params RawMessage) (interface{}, error) {
+	var p P
+	if err := decodeFuncParams(codec, params, &p); err != nil {
+		return nil, err
+	}
+	return fm.fn(ctx, p)
+}
+
+// A function without params registered as a method, the params of the request are ignored
+type noParamsMethod[R any] struct {
+	fn func(context.Context) (R, error)
+}
+
+func (nm *noParamsMethod[R]) call(ctx context.Context, codec Codec, params RawMessage) (interface{}, error) {
+	return nm.fn(ctx)
+}
+
+// A function registered as an event
+type funcEvent[P any] struct {
+	fn func(context.Context, P)
+}
+
+func (fe *funcEvent[P]) call(ctx context.Context, codec Codec, params RawMessage) (interface{}, error) {
+	var p P
+	if err := decodeFuncParams(codec, params, &p); err != nil {
+		return nil, err
+	}
+	fe.fn(ctx, p)
+	return nil, nil
+}
+
+// Params are decoded directly into the parameter of the function, they may be ommited
+func decodeFuncParams(codec Codec, params RawMessage, p interface{}) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := codec.Unmarshal(params, p); err != nil {
+		return NewError(ErrorInvalidParams, "Unable to decode params: %v", err)
+	}
+	return nil
+}
+
+// Register exposes a function as a method available on every connection.
+// The name is the full name of the method, e.g. "math.add", and takes
+// precedence over the methods of a service with the same name.
+// The params of the request are decoded into P
+func Register[P, R any](wsj *WsJson, name string, fn func(context.Context, P) (R, error)) error {
+	return wsj.globalManager().addHandler(name, &funcMethod[P, R]{fn: fn})
+}
+
+// RegisterEvent exposes a function as an event available on every connection,
+// notifications have no response and calls with an id are answered with null
+func RegisterEvent[P any](wsj *WsJson, name string, fn func(context.Context, P)) error {
+	return wsj.globalManager().addHandler(name, &funcEvent[P]{fn: fn})
+}
@@ -0,0 +1,162 @@
+package wsjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+)
+
+const (
+	// Default size limit of the HTTP POST bodies
+	defaultMaxPostSize = 1 << 20
+)
+
+var (
+	// Returned by the calls and events to the peer of a request over HTTP POST
+	ErrNoWebsocket = errors.New("Calls and events to the peer need a websocket connection")
+)
+
+// Answer the requests that aren't websocket upgrades as JSON-RPC over HTTP POST, a call or
+// a batch of them in the body. The services are the same ones, but they can't call the peer
+// nor send it events, and the calls can't be streamed, cancelled or resumed
+func (wsj *WsJson) SetHTTPFallback(enabled bool) {
+	wsj.httpFallback = enabled
+}
+
+// Set the size limit of the HTTP POST bodies, 1 MiB by default.
+// Websocket messages keep their own limit
+func (wsj *WsJson) SetMaxPostSize(size int64) {
+	wsj.maxPostSize = size
+}
+
+func (wsj *WsJson) postSizeLimit() int64 {
+	if wsj.maxPostSize <= 0 {
+		return defaultMaxPostSize
+	}
+	return wsj.maxPostSize
+}
+
+// Answer a JSON-RPC request, or batch of them, over HTTP POST
+func (wsj *WsJson) servePost(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	principal, r, err := wsj.authenticate(w, r)
+	if err != nil {
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, wsj.postSizeLimit()))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var apiObjects []interface{}
+	if wsj.apiFactory != nil {
+		apiObjects = wsj.apiFactory(w, r)
+		if apiObjects == nil {
+			// apiFactory should have handled the response
+			return
+		}
+	}
+	client, err := newWsJsonClient(wsj.newServiceManager(), nil, apiObjects)
+	if err != nil {
+		log.Printf("Error creating client: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer client.close()
+	client.overHTTP = true
+	client.limiter = wsj.hostLimiter(r.RemoteAddr)
+	client.SetPrincipal(principal)
+	client.metrics = wsj.metrics
+	wsj.configureClient(client)
+	client.recordFrame(DirectionIn, body)
+
+	var result interface{}
+	if batch, ok := decodeBatch(body); ok {
+		responses := client.handleBatch(batch)
+		if len(responses) > 0 {
+			result = responses
+		}
+	} else if response := client.handlePost(body); response != nil {
+		result = response
+	}
+	if result == nil {
+		// only notifications
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("Error encoding message: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	client.recordFrame(DirectionOut, data)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// Messages of a batch, false if the body is not an array
+func decodeBatch(body []byte) ([]json.RawMessage, bool) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '[' {
+		return nil, false
+	}
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return nil, false
+	}
+	return batch, true
+}
+
+// Handle the calls of a batch concurrently, the responses keep their order
+// and notifications have none
+func (wsjc *WsJsonClient) handleBatch(batch []json.RawMessage) []*Response {
+	if len(batch) == 0 {
+		return []*Response{NewErrorResponse(NewError(ErrorInvalidRequest, "Empty batch"))}
+	}
+	responses := make([]*Response, len(batch))
+	var wg sync.WaitGroup
+	for i, message := range batch {
+		wg.Add(1)
+		go func(i int, message json.RawMessage) {
+			defer wg.Done()
+			responses[i] = wsjc.handlePost(message)
+		}(i, message)
+	}
+	wg.Wait()
+
+	n := 0
+	for _, response := range responses {
+		if response != nil {
+			responses[n] = response
+			n++
+		}
+	}
+	return responses[:n]
+}
+
+// Handle a message received over HTTP POST, only calls and notifications to the services
+func (wsjc *WsJsonClient) handlePost(message []byte) *Response {
+	request, errResponse := wsjc.decodeMessage(bytes.NewReader(message))
+	if errResponse != nil {
+		return errResponse
+	}
+	if request.isResult() || isCallNotification(request.Method) {
+		// there are no calls made nor in progress to refer to
+		if request.Id == nil || request.isResult() {
+			return nil
+		}
+		return request.makeError(ErrorInvalidRequest, "Method not supported over HTTP POST")
+	}
+	return wsjc.handleRequest(*request)
+}
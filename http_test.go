@@ -0,0 +1,197 @@
+package wsjson
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Calls its peer, which is not possible over HTTP POST
+type CallbackService struct{}
+
+func (*CallbackService) ApiAsk(ctx context.Context) (string, error) {
+	client := ClientFromContext(ctx)
+	if err := client.SendEvent("asked", nil); err != ErrNoWebsocket {
+		return "", err
+	}
+	return "", client.Call(ctx, "Peer.Answer", nil, nil)
+}
+
+func postServer(t *testing.T) *WsJson {
+	wsj := &WsJson{}
+	wsj.SetHTTPFallback(true)
+	wsj.AddService(&CallbackService{})
+	wsj.SetApiFactory(func(w http.ResponseWriter, r *http.Request) []interface{} {
+		return []interface{}{&SimpleService{}, &WhoAmIService{}}
+	})
+	return wsj
+}
+
+func post(t *testing.T, url string, body string, header http.Header) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, strings.TrimSpace(string(data))
+}
+
+func TestHTTPPost(t *testing.T) {
+	server := startServer(t, postServer(t))
+	// bigger than the websocket messages
+	big := strings.Repeat("x", maxMessageSize+1)
+
+	var tests = []struct {
+		body     string
+		status   int
+		response string
+	}{
+		{`{"jsonrpc": "2.0", "method": "SimpleService.Echo", "params": ["hi"], "id": 1}`,
+			200, `{"jsonrpc":"2.0","result":"hi","id":1}`},
+		{`{"jsonrpc": "2.0", "method": "SimpleService.Event", "params": ["hi"]}`, 204, ``},
+		{`{"jsonrpc": "2.0", "method": "SimpleService.Event", "params": ["hi"], "id": 2}`,
+			200, `{"jsonrpc":"2.0","result":null,"id":2}`},
+		{`{"jsonrpc": "2.0", "method": "SimpleService.Nope", "id": 1}`,
+			200, `{"jsonrpc":"2.0","result":null,"error":{"code":-32601,"message":"API SimpleService doesn't have the Nope method"},"id":1}`},
+		{`{"jsonrpc": "2.0", "method": "SimpleService.Echo"`,
+			200, `{"jsonrpc":"2.0","result":null,"error":{"code":-32700,"message":"Parse Error"},"id":null}`},
+		{`[{"jsonrpc": "2.0", "method": "SimpleService.Echo", "params": ["a"], "id": 1},
+		   {"jsonrpc": "2.0", "method": "SimpleService.Event", "params": ["b"]},
+		   {"jsonrpc": "2.0", "method": "WhoAmIService.WhoAmI", "id": "c"}]`,
+			200, `[{"jsonrpc":"2.0","result":"a","id":1},{"jsonrpc":"2.0","result":"anonymous","id":"c"}]`},
+		{`[{"jsonrpc": "2.0", "method": "SimpleService.Event", "params": ["b"]}]`, 204, ``},
+		{`[]`, 200, `[{"jsonrpc":"2.0","result":null,"error":{"code":-32600,"message":"Empty batch"},"id":null}]`},
+		{`{"jsonrpc": "2.0", "method": "$/cancelRequest", "params": {"id": 1}, "id": 2}`,
+			200, `{"jsonrpc":"2.0","result":null,"error":{"code":-32600,"message":"Method not supported over HTTP POST"},"id":2}`},
+		{`{"jsonrpc": "2.0", "result": "stray", "id": 3}`, 204, ``},
+		{`{"jsonrpc": "2.0", "method": "CallbackService.Ask", "id": 4}`,
+			200, `{"jsonrpc":"2.0","result":null,"error":{"code":-32603,"message":"` + ErrNoWebsocket.Error() + `"},"id":4}`},
+		{`{"jsonrpc": "2.0", "method": "SimpleService.Echo", "params": ["` + big + `"], "id": 5}`,
+			200, `{"jsonrpc":"2.0","result":"` + big + `","id":5}`},
+		{strings.Repeat(" ", defaultMaxPostSize+1), 413, http.StatusText(http.StatusRequestEntityTooLarge)},
+	}
+	for _, test := range tests {
+		status, response := post(t, server.URL, test.body, nil)
+		if status != test.status || response != test.response {
+			t.Errorf("Request %s should be answered with %d %s, got: %d %s", test.body, test.status, test.response, status, response)
+		}
+	}
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != http.MethodPost {
+		t.Errorf("Only POST should be allowed, got: %d %v", resp.StatusCode, resp.Header)
+	}
+
+	// websockets are still served on the same endpoint
+	conn, _, err := dialServer(server, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if resp := roundTrip(t, conn, `{"jsonrpc": "2.0", "method": "SimpleService.Echo", "params": ["ws"], "id": 1}`); resp.Result != "ws" {
+		t.Errorf("Websockets should be served, got: %v", resp)
+	}
+}
+
+func TestHTTPPostAuth(t *testing.T) {
+	wsj := postServer(t)
+	wsj.SetAuthenticator(&BearerAuthenticator{Validate: validateToken})
+	server := startServer(t, wsj)
+	body := `{"jsonrpc": "2.0", "method": "WhoAmIService.WhoAmI", "id": 1}`
+
+	var tests = []struct {
+		token  string
+		status int
+		result string
+	}{
+		{"", 200, "anonymous"},
+		{"invalid", 401, ""},
+		{"valid-ana", 200, "ana"},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		if test.token != "" {
+			header.Set("Authorization", "Bearer "+test.token)
+		}
+		status, data := post(t, server.URL, body, header)
+		if status != test.status {
+			t.Errorf("Token %q should be answered with %d, got: %d %s", test.token, test.status, status, data)
+			continue
+		}
+		if status != 200 {
+			continue
+		}
+		var resp Response
+		if err := json.Unmarshal([]byte(data), &resp); err != nil || resp.Result != test.result {
+			t.Errorf("Token %q should call as %q, got: %s", test.token, test.result, data)
+		}
+	}
+}
+
+func TestHTTPPostLimits(t *testing.T) {
+	wsj := postServer(t)
+	wsj.SetMaxPostSize(128)
+	wsj.SetRateLimits(RateLimits{
+		Connection:    RateLimit{Rate: 0.1, Burst: 2},
+		MaxViolations: 1,
+	})
+	server := startServer(t, wsj)
+	echo := `{"jsonrpc": "2.0", "method": "SimpleService.Echo", "params": ["hi"], "id": 1}`
+
+	if status, _ := post(t, server.URL, echo+strings.Repeat(" ", 128), nil); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Bodies over the size limit should be rejected, got %d", status)
+	}
+
+	// the requests of a host share the limits, and aren't closed by the violations
+	var tests = []struct {
+		status int
+		code   int
+	}{
+		{200, 0},
+		{200, 0},
+		{200, ErrorRateLimited},
+		{200, ErrorRateLimited},
+	}
+	for i, test := range tests {
+		status, data := post(t, server.URL, echo, nil)
+		var resp Response
+		if err := json.Unmarshal([]byte(data), &resp); err != nil {
+			t.Fatalf("Invalid response %d: %s", i, data)
+		}
+		code := 0
+		if resp.Err != nil {
+			code = resp.Err.Code
+		}
+		if status != test.status || code != test.code {
+			t.Errorf("Request %d should be answered with %d and error %d, got: %d %s", i, test.status, test.code, status, data)
+		}
+	}
+}
+
+func TestHTTPPostDisabled(t *testing.T) {
+	wsj := postServer(t)
+	wsj.SetHTTPFallback(false)
+	server := startServer(t, wsj)
+	if status, _ := post(t, server.URL, `{"jsonrpc": "2.0", "method": "SimpleService.Echo", "params": ["hi"], "id": 1}`, nil); status != http.StatusBadRequest {
+		t.Errorf("Requests that aren't upgrades should fail without the fallback, got %d", status)
+	}
+}
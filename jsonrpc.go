@@ -12,6 +12,14 @@ const (
 	ErrorMethodNotFound int    = -32601
 	ErrorInvalidParams  int    = -32602
 	ErrorInternalError  int    = -32603
+
+	// Server errors defined by wsjson
+	ErrorUnauthenticated int = -32001
+	ErrorForbidden       int = -32003
+	ErrorRateLimited     int = -32029
+
+	// The call was cancelled by the peer with MethodCancelRequest
+	ErrorRequestCancelled int = -32800
 )
 
 type Error struct {
@@ -20,12 +28,25 @@ type Error struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
+// Params and Result are omitted by CBOR only when nil, as raw messages are never empty for it
 type Request struct {
-	Version string          `json:"jsonrpc"`
-	Method  string          `json:"method"`
-	Params  json.RawMessage `json:"params,omitempty"`
-	Result  json.RawMessage `json:"result,omitempty"`
-	Id      interface{}     `json:"id,omitempty"`
+	Version string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  RawMessage  `json:"params,omitempty" cbor:"params,omitzero"`
+	Result  RawMessage  `json:"result,omitempty" cbor:"result,omitzero"`
+	Err     *Error      `json:"error,omitempty"`
+	Id      interface{} `json:"id,omitempty"`
+	Meta    *Meta       `json:"meta,omitempty"`
+}
+
+// Extension of the envelope of the calls with data about the call itself
+type Meta struct {
+	// Token to identify the MethodProgress notifications of the call
+	ProgressToken interface{} `json:"progressToken,omitempty"`
+	// W3C trace context of the span of the caller
+	Traceparent string `json:"traceparent,omitempty"`
+	// Position of the message in its session, see SetSessions
+	Seq int64 `json:"seq,omitempty"`
 }
 
 type Response struct {
@@ -33,6 +54,7 @@ type Response struct {
 	Result  interface{} `json:"result"`
 	Err     *Error      `json:"error,omitempty"`
 	Id      interface{} `json:"id"`
+	Meta    *Meta       `json:"meta,omitempty"`
 }
 
 func NewError(code int, message string, a ...interface{}) *Error {
@@ -76,6 +98,11 @@ func (req *Request) makeError(code int, message string, a ...interface{}) *Respo
 	return response
 }
 
+// Whether the message is the response to a call
+func (req *Request) isResult() bool {
+	return req.Result != nil || req.Err != nil
+}
+
 func (req *Request) String() string {
 	enc, err := json.Marshal(req)
 	if err != nil {
@@ -83,3 +110,11 @@ func (req *Request) String() string {
 	}
 	return string(enc)
 }
+
+func (resp *Response) String() string {
+	enc, err := json.Marshal(resp)
+	if err != nil {
+		return err.Error()
+	}
+	return string(enc)
+}
@@ -0,0 +1,303 @@
+package wsjson
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reasons of the failed upgrades
+const (
+	UpgradeFailedOrigin   = "origin"
+	UpgradeFailedProtocol = "protocol"
+	UpgradeFailedAuth     = "auth"
+	UpgradeFailedFactory  = "factory"
+	UpgradeFailedInternal = "internal"
+	UpgradeFailedUpgrade  = "upgrade"
+)
+
+// Label of the calls to methods not found, to keep the number of labels bounded
+const unknownMethod = "unknown"
+
+// Metrics receives the measures of the connections and the calls they handle,
+// its methods are called concurrently. See PrometheusMetrics
+type Metrics interface {
+	// A connection was upgraded
+	ConnectionOpened()
+	ConnectionClosed()
+	// A request was rejected before upgrading, reason is one of the UpgradeFailed constants
+	UpgradeFailed(reason string)
+	// Size of a message received or sent, before compression
+	MessageReceived(size int)
+	MessageSent(size int)
+	// A call or notification received was handled, code is 0 unless it failed
+	CallHandled(method string, code int, latency time.Duration)
+}
+
+// Messages waiting in the connections, sampled when needed
+type QueueStats struct {
+	// Messages queued to be sent
+	Output int
+	// Calls made waiting for their response
+	PendingResults int
+}
+
+// Connections being served, to sample their queues
+type connSet struct {
+	mutex   sync.Mutex
+	clients map[*WsJsonClient]struct{}
+}
+
+func (cs *connSet) add(client *WsJsonClient) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	if cs.clients == nil {
+		cs.clients = make(map[*WsJsonClient]struct{})
+	}
+	cs.clients[client] = struct{}{}
+}
+
+func (cs *connSet) remove(client *WsJsonClient) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	delete(cs.clients, client)
+}
+
+func (cs *connSet) queueStats() QueueStats {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	var stats QueueStats
+	for client := range cs.clients {
+		clientStats := client.queueStats()
+		stats.Output += clientStats.Output
+		stats.PendingResults += clientStats.PendingResults
+	}
+	return stats
+}
+
+// Metrics implementations that also report the queues of the connections
+type queueObserver interface {
+	observeQueues(func() QueueStats)
+}
+
+// Set the receiver of the measures, nil disables them.
+// Must be called before serving connections
+func (wsj *WsJson) SetMetrics(metrics Metrics) {
+	wsj.metrics = metrics
+	if observer, ok := metrics.(queueObserver); ok {
+		observer.observeQueues(wsj.QueueStats)
+	}
+}
+
+// QueueStats returns the totals of the connections being served
+func (wsj *WsJson) QueueStats() QueueStats {
+	return wsj.conns.queueStats()
+}
+
+// Record a request rejected before upgrading
+func (wsj *WsJson) upgradeFailed(reason string) {
+	if wsj.metrics != nil {
+		wsj.metrics.UpgradeFailed(reason)
+	}
+}
+
+func (wsjc *WsJsonClient) queueStats() QueueStats {
+	wsjc.resultsMutex.RLock()
+	defer wsjc.resultsMutex.RUnlock()
+	return QueueStats{Output: len(wsjc.output), PendingResults: len(wsjc.pendingResults)}
+}
+
+// Record a call received once handled
+func (wsjc *WsJsonClient) callHandled(method string, response *Response, start time.Time) {
+	if wsjc.metrics == nil {
+		return
+	}
+	code := 0
+	if response != nil && response.Err != nil {
+		code = response.Err.Code
+	}
+	if !wsjc.manager.hasMethod(method) {
+		// names sent by the peer that aren't registered would add labels without bounds
+		method = unknownMethod
+	}
+	wsjc.metrics.CallHandled(method, code, time.Since(start))
+}
+
+// Upper bounds in seconds of the buckets of the call latencies
+var DefaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics kept in memory and served in the Prometheus text exposition format
+type PrometheusMetrics struct {
+	buckets []float64
+
+	connections      atomic.Int64
+	upgrades         atomic.Int64
+	messagesReceived atomic.Int64
+	messagesSent     atomic.Int64
+	bytesReceived    atomic.Int64
+	bytesSent        atomic.Int64
+
+	mutex         sync.Mutex
+	upgradeFailed map[string]int64
+	calls         map[callLabels]*histogram
+	queues        func() QueueStats
+}
+
+type callLabels struct {
+	method string
+	code   int
+}
+
+type histogram struct {
+	// count by bucket, not cumulative
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+// NewPrometheusMetrics creates metrics with the given latency buckets, DefaultLatencyBuckets if none
+func NewPrometheusMetrics(buckets ...float64) *PrometheusMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &PrometheusMetrics{
+		buckets:       buckets,
+		upgradeFailed: make(map[string]int64),
+		calls:         make(map[callLabels]*histogram),
+	}
+}
+
+func (pm *PrometheusMetrics) ConnectionOpened() {
+	pm.upgrades.Add(1)
+	pm.connections.Add(1)
+}
+
+func (pm *PrometheusMetrics) ConnectionClosed() {
+	pm.connections.Add(-1)
+}
+
+func (pm *PrometheusMetrics) UpgradeFailed(reason string) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	pm.upgradeFailed[reason]++
+}
+
+func (pm *PrometheusMetrics) MessageReceived(size int) {
+	pm.messagesReceived.Add(1)
+	pm.bytesReceived.Add(int64(size))
+}
+
+func (pm *PrometheusMetrics) MessageSent(size int) {
+	pm.messagesSent.Add(1)
+	pm.bytesSent.Add(int64(size))
+}
+
+func (pm *PrometheusMetrics) CallHandled(method string, code int, latency time.Duration) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	labels := callLabels{method, code}
+	h := pm.calls[labels]
+	if h == nil {
+		h = &histogram{counts: make([]int64, len(pm.buckets))}
+		pm.calls[labels] = h
+	}
+	seconds := latency.Seconds()
+	if i := sort.SearchFloat64s(pm.buckets, seconds); i < len(pm.buckets) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += seconds
+}
+
+func (pm *PrometheusMetrics) observeQueues(queues func() QueueStats) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	pm.queues = queues
+}
+
+// Serves the metrics to be scraped by Prometheus
+func (pm *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(pm.String()))
+}
+
+// The metrics in the Prometheus text exposition format
+func (pm *PrometheusMetrics) String() string {
+	var b strings.Builder
+	writeMetric(&b, "wsjson_connections_active", "gauge", "Connections being served.", pm.connections.Load())
+	writeMetric(&b, "wsjson_upgrades_total", "counter", "Connections upgraded.", pm.upgrades.Load())
+	writeMetric(&b, "wsjson_messages_received_total", "counter", "Messages received.", pm.messagesReceived.Load())
+	writeMetric(&b, "wsjson_messages_sent_total", "counter", "Messages sent.", pm.messagesSent.Load())
+	writeMetric(&b, "wsjson_received_bytes_total", "counter", "Bytes of the messages received.", pm.bytesReceived.Load())
+	writeMetric(&b, "wsjson_sent_bytes_total", "counter", "Bytes of the messages sent, before compression.", pm.bytesSent.Load())
+
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	writeHeader(&b, "wsjson_upgrade_failures_total", "counter", "Requests rejected before upgrading, by reason.")
+	reasons := make([]string, 0, len(pm.upgradeFailed))
+	for reason := range pm.upgradeFailed {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(&b, "wsjson_upgrade_failures_total{reason=%s} %d\n", quoteLabel(reason), pm.upgradeFailed[reason])
+	}
+
+	writeHeader(&b, "wsjson_call_duration_seconds", "histogram", "Latency of the calls received, by method and error code.")
+	labels := make([]callLabels, 0, len(pm.calls))
+	for l := range pm.calls {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].method != labels[j].method {
+			return labels[i].method < labels[j].method
+		}
+		return labels[i].code < labels[j].code
+	})
+	for _, l := range labels {
+		h := pm.calls[l]
+		prefix := fmt.Sprintf("method=%s,code=\"%d\"", quoteLabel(l.method), l.code)
+		var cumulative int64
+		for i, bound := range pm.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&b, "wsjson_call_duration_seconds_bucket{%s,le=\"%s\"} %d\n", prefix, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(&b, "wsjson_call_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", prefix, h.count)
+		fmt.Fprintf(&b, "wsjson_call_duration_seconds_sum{%s} %s\n", prefix, formatFloat(h.sum))
+		fmt.Fprintf(&b, "wsjson_call_duration_seconds_count{%s} %d\n", prefix, h.count)
+	}
+
+	if pm.queues != nil {
+		queues := pm.queues()
+		writeMetric(&b, "wsjson_output_queue_messages", "gauge", "Messages queued to be sent.", int64(queues.Output))
+		writeMetric(&b, "wsjson_pending_results", "gauge", "Calls made waiting for their response.", int64(queues.PendingResults))
+	}
+	return b.String()
+}
+
+func writeHeader(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeMetric(b *strings.Builder, name, kind, help string, value int64) {
+	writeHeader(b, name, kind, help)
+	fmt.Fprintf(b, "%s %d\n", name, value)
+}
+
+// Label value with backslashes, quotes and line feeds escaped
+func quoteLabel(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return `"` + value + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,124 @@
+package wsjson
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	metrics := NewPrometheusMetrics(0.1, 1)
+	wsj := &WsJson{}
+	wsj.AddService(&SimpleService{})
+	wsj.SetMetrics(metrics)
+	server := startServer(t, wsj)
+
+	conn, _, err := dialServer(server, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, conn, `{"jsonrpc": "2.0", "method": "SimpleService.Echo", "params": ["hi"], "id": 1}`)
+	roundTrip(t, conn, `{"jsonrpc": "2.0", "method": "SimpleService.Echo", "params": ["hello"], "id": 2}`)
+	roundTrip(t, conn, `{"jsonrpc": "2.0", "method": "Random.Name", "id": 3}`)
+
+	// rejected before upgrading
+	header := http.Header{"Origin": []string{"http://evil.example.com"}}
+	if _, _, err := dialServer(server, "/", header); err == nil {
+		t.Errorf("Connection from another origin should fail")
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Invalid content type: %s", ct)
+	}
+
+	var expected = []string{
+		"# TYPE wsjson_connections_active gauge",
+		"wsjson_connections_active 1",
+		"wsjson_upgrades_total 1",
+		"wsjson_messages_received_total 3",
+		`wsjson_upgrade_failures_total{reason="origin"} 1`,
+		"# TYPE wsjson_call_duration_seconds histogram",
+		`wsjson_call_duration_seconds_bucket{method="SimpleService.Echo",code="0",le="1"} 2`,
+		`wsjson_call_duration_seconds_bucket{method="SimpleService.Echo",code="0",le="+Inf"} 2`,
+		`wsjson_call_duration_seconds_count{method="SimpleService.Echo",code="0"} 2`,
+		`wsjson_call_duration_seconds_count{method="unknown",code="-32601"} 1`,
+		"wsjson_output_queue_messages 0",
+		"wsjson_pending_results 0",
+	}
+	body := rec.Body.String()
+	for _, line := range expected {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Metrics should contain %q, got:\n%s", line, body)
+		}
+	}
+
+	// messages are counted once written
+	conn.Close()
+	deadline := time.Now().Add(time.Second)
+	for body := metrics.String(); !strings.Contains(body, "wsjson_connections_active 0\n") ||
+		!strings.Contains(body, "wsjson_messages_sent_total 3\n"); body = metrics.String() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Closed connections should not be active and the responses should be counted, got:\n%s", body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMetricsUnknownMethods(t *testing.T) {
+	metrics := NewPrometheusMetrics(1)
+	client, err := newWsJsonClient(newServiceManager(), nil, []interface{}{&SimpleService{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.metrics = metrics
+	client.limiter = newConnLimiter(RateLimits{Connection: RateLimit{Rate: 0.1, Burst: 1}}, nil)
+	client.notificationFallback = func(ctx context.Context, method string, params RawMessage) {}
+
+	var requests = []Request{
+		{Method: "SimpleService.Event", Params: RawMessage(`["a"]`)},
+		{Method: "Random.Name", Id: float64(1)},
+		{Method: "Random.Other"},
+	}
+	for _, request := range requests {
+		client.handleRequest(request)
+	}
+	client.limiter = nil
+	client.handleRequest(Request{Method: "Random.Fallback"})
+
+	var expected = []string{
+		`wsjson_call_duration_seconds_count{method="SimpleService.Event",code="0"} 1`,
+		`wsjson_call_duration_seconds_count{method="unknown",code="-32029"} 2`,
+		`wsjson_call_duration_seconds_count{method="unknown",code="0"} 1`,
+	}
+	body := metrics.String()
+	for _, line := range expected {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Metrics should contain %q, got:\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, "Random.") {
+		t.Errorf("Methods not registered should not be labels, got:\n%s", body)
+	}
+}
+
+func TestQuoteLabel(t *testing.T) {
+	var table = []struct {
+		value    string
+		expected string
+	}{
+		{"Service.Method", `"Service.Method"`},
+		{`a"b`, `"a\"b"`},
+		{"a\\b\nc", `"a\\b\nc"`},
+	}
+
+	for _, row := range table {
+		if got := quoteLabel(row.value); got != row.expected {
+			t.Errorf("Invalid label for %q, expected: %s, got: %s", row.value, row.expected, got)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package wsjson
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Maps the Go name of a method, without its prefix, to the exposed name.
+// It applies only to the methods found by prefix, the names given by
+// MethodsProvider are exposed as they are
+type NameMapper func(string) string
+
+// LowerCamelCase exposes methods as lowerCamelCase, "GetUserID" becomes "getUserID"
+// and "HTTPStatus" becomes "httpStatus"
+func LowerCamelCase(name string) string {
+	r := []rune(name)
+	for i := 0; i < len(r) && unicode.IsUpper(r[i]); i++ {
+		// keep the first letter of the next word in upper case
+		if i > 0 && i+1 < len(r) && unicode.IsLower(r[i+1]) {
+			break
+		}
+		r[i] = unicode.ToLower(r[i])
+	}
+	return string(r)
+}
+
+// SnakeCase exposes methods as snake_case, "GetUserID" becomes "get_user_id"
+// and "HTTPStatus" becomes "http_status"
+func SnakeCase(name string) string {
+	r := []rune(name)
+	var b strings.Builder
+	for i, c := range r {
+		if unicode.IsUpper(c) {
+			if i > 0 && r[i-1] != '_' {
+				prev := r[i-1]
+				nextLower := i+1 < len(r) && unicode.IsLower(r[i+1])
+				if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+					b.WriteRune('_')
+				}
+			}
+			c = unicode.ToLower(c)
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,79 @@
+package wsjson
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// An allowed origin, the scheme is optional and the host may start with "*."
+type originPattern struct {
+	scheme string
+	host   string
+}
+
+func parseOriginPattern(origin string) originPattern {
+	origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+	if i := strings.Index(origin, "://"); i >= 0 {
+		return originPattern{scheme: origin[:i], host: origin[i+3:]}
+	}
+	return originPattern{host: origin}
+}
+
+func (op originPattern) match(origin *url.URL) bool {
+	if op.scheme != "" && op.scheme != strings.ToLower(origin.Scheme) {
+		return false
+	}
+
+	host := strings.ToLower(origin.Host)
+	if suffix, ok := strings.CutPrefix(op.host, "*."); ok {
+		// subdomains only, the domain must be allowed on its own
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return host == op.host
+}
+
+// Set the origins allowed to connect, like "https://example.com" or "*.example.com".
+// The scheme is optional and "*." matches any subdomain.
+// Without allowed origins only requests from the same host are accepted
+func (wsj *WsJson) SetAllowedOrigins(origins ...string) {
+	wsj.allowedOrigins = make([]originPattern, len(origins))
+	for i, origin := range origins {
+		wsj.allowedOrigins[i] = parseOriginPattern(origin)
+	}
+}
+
+// Accept connections from any origin, meant for development only
+// as it allows cross-site websocket hijacking
+func (wsj *WsJson) AllowAllOrigins(allow bool) {
+	wsj.allowAllOrigins = allow
+}
+
+// Whether the origin of the upgrade request is allowed.
+// Requests without the Origin header don't come from browsers and are accepted
+func (wsj *WsJson) checkOrigin(r *http.Request) bool {
+	originHeader := r.Header.Get("Origin")
+	if originHeader == "" || wsj.allowAllOrigins {
+		return true
+	}
+
+	origin, err := url.Parse(originHeader)
+	if err != nil || origin.Host == "" {
+		return false
+	}
+
+	if len(wsj.allowedOrigins) == 0 {
+		return strings.EqualFold(origin.Host, r.Host)
+	}
+	for _, pattern := range wsj.allowedOrigins {
+		if pattern.match(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check the origin with the upgrader, custom upgraders may have their own check
+func (wsj *WsJson) originAllowed(r *http.Request) bool {
+	return wsj.upgrader().CheckOrigin(r)
+}
@@ -0,0 +1,76 @@
+package wsjson
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	allowed := []string{"https://example.com", "*.example.org", "http://localhost:3000"}
+
+	var table = []struct {
+		allowed []string
+		origin  string
+		result  bool
+	}{
+		{nil, "", true},
+		{nil, "http://server.test", true},
+		{nil, "http://SERVER.test", true},
+		{nil, "http://other.test", false},
+		{nil, "null", false},
+		{allowed, "https://example.com", true},
+		{allowed, "http://example.com", false},
+		{allowed, "https://www.example.com", false},
+		{allowed, "https://app.example.org", true},
+		{allowed, "http://a.b.example.org", true},
+		{allowed, "https://example.org", false},
+		{allowed, "https://evilexample.org", false},
+		{allowed, "http://localhost:3000", true},
+		{allowed, "http://localhost:3001", false},
+		{allowed, "http://server.test", false},
+	}
+
+	for _, row := range table {
+		wsj := &WsJson{}
+		wsj.SetAllowedOrigins(row.allowed...)
+		r := httptest.NewRequest("GET", "http://server.test/ws", nil)
+		if row.origin != "" {
+			r.Header.Set("Origin", row.origin)
+		}
+		if result := wsj.checkOrigin(r); result != row.result {
+			t.Errorf("Invalid check of %q with %v, expected: %v, got: %v", row.origin, row.allowed, row.result, result)
+		}
+	}
+
+	wsj := &WsJson{}
+	wsj.AllowAllOrigins(true)
+	r := httptest.NewRequest("GET", "http://server.test/ws", nil)
+	r.Header.Set("Origin", "http://other.test")
+	if !wsj.checkOrigin(r) {
+		t.Errorf("All origins should be allowed")
+	}
+}
+
+func TestOriginRejected(t *testing.T) {
+	wsj := &WsJson{}
+	wsj.SetAllowedOrigins("https://*.example.com")
+	wsj.AddService(&WhoAmIService{})
+	server := startServer(t, wsj)
+
+	conn, _, err := dialServer(server, "/", http.Header{"Origin": {"https://app.example.com"}})
+	if err != nil {
+		t.Fatalf("Allowed origin should connect: %v", err)
+	}
+	conn.Close()
+
+	lc := startLogCapture()
+	_, resp, err := dialServer(server, "/", http.Header{"Origin": {"https://evil.test"}})
+	lc.stop()
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Connection from other origins should be rejected, got: %v", err)
+	}
+	if !lc.contains("Origin not allowed: https://evil.test") {
+		t.Errorf("Rejected origin should be logged: %v", lc.buffer)
+	}
+}
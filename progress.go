@@ -0,0 +1,81 @@
+package wsjson
+
+import "context"
+
+const (
+	// Notification with the progress of a call, sent when the call has a progress token
+	MethodProgress = "$/progress"
+)
+
+// Progress of a call
+type Progress struct {
+	// Completed percentage, from 0 to 100
+	Percent float64     `json:"percent"`
+	Message string      `json:"message,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Params of MethodProgress
+type ProgressParams struct {
+	// Token from the meta of the call
+	Token interface{} `json:"token"`
+	Value Progress    `json:"value"`
+}
+
+type progressTokenCtxKey struct{}
+
+type progressHandlerCtxKey struct{}
+
+func withProgressToken(ctx context.Context, token interface{}) context.Context {
+	return context.WithValue(ctx, progressTokenCtxKey{}, token)
+}
+
+// ReportProgress sends the progress of the call being handled to the peer.
+// It does nothing when the peer didn't ask for the progress of the call
+func ReportProgress(ctx context.Context, progress Progress) error {
+	token := ctx.Value(progressTokenCtxKey{})
+	client := ClientFromContext(ctx)
+	if token == nil || client == nil {
+		return nil
+	}
+
+	request, err := client.newRequest(MethodProgress, &ProgressParams{Token: token, Value: progress})
+	if err != nil {
+		return err
+	}
+	return client.sendContext(ctx, request)
+}
+
+// WithProgress returns a context for Call and CallStream that asks the peer for the
+// progress of the call, onProgress is called with each report in order
+func WithProgress(ctx context.Context, onProgress func(Progress)) context.Context {
+	return context.WithValue(ctx, progressHandlerCtxKey{}, onProgress)
+}
+
+func progressFromContext(ctx context.Context) func(Progress) {
+	onProgress, _ := ctx.Value(progressHandlerCtxKey{}).(func(Progress))
+	return onProgress
+}
+
+// Handles the progress of the calls made, the token is the id of the call
+func (wsjc *WsJsonClient) handleProgress(request Request) {
+	var params ProgressParams
+	if err := wsjc.codec().Unmarshal(request.Params, &params); err != nil {
+		return
+	}
+	id, ok := requestId(params.Token)
+	if !ok {
+		return
+	}
+
+	if handlers := wsjc.pendingCallHandlers(id); handlers != nil && handlers.onProgress != nil {
+		handlers.onProgress(params.Value)
+	}
+}
+
+// Handlers of the notifications about a call made, nil if not pending
+func (wsjc *WsJsonClient) pendingCallHandlers(id int) *callHandlers {
+	wsjc.resultsMutex.RLock()
+	defer wsjc.resultsMutex.RUnlock()
+	return wsjc.pendingHandlers[id]
+}
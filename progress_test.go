@@ -0,0 +1,123 @@
+package wsjson
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type ReportService struct{}
+
+func (*ReportService) ApiGenerate(ctx context.Context, steps int) (string, error) {
+	for i := 1; i <= steps; i++ {
+		err := ReportProgress(ctx, Progress{Percent: float64(i * 100 / steps), Message: "step"})
+		if err != nil {
+			return "", err
+		}
+	}
+	return "done", nil
+}
+
+func TestReportProgress(t *testing.T) {
+	wsj := &WsJson{}
+	wsj.AddService(&ReportService{})
+	server := startServer(t, wsj)
+
+	conn, _, err := dialServer(server, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var table = []struct {
+		msg      string
+		token    interface{}
+		progress []float64
+	}{
+		{`{"jsonrpc": "2.0", "method": "ReportService.Generate", "params": [4], "id": 1, "meta": {"progressToken": "t1"}}`,
+			"t1", []float64{25, 50, 75, 100}},
+		{`{"jsonrpc": "2.0", "method": "ReportService.Generate", "params": [2], "id": 2, "meta": {"progressToken": 9}}`,
+			9.0, []float64{50, 100}},
+		// without a token progress is not reported
+		{`{"jsonrpc": "2.0", "method": "ReportService.Generate", "params": [2], "id": 3}`,
+			nil, nil},
+	}
+
+	for _, row := range table {
+		if err := conn.WriteMessage(1, []byte(row.msg)); err != nil {
+			t.Fatal(err)
+		}
+
+		var progress []float64
+		for {
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			var msg struct {
+				Method string
+				Params ProgressParams
+				Result interface{}
+				Error  *Error
+			}
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Method != MethodProgress {
+				if msg.Error != nil || msg.Result != "done" {
+					t.Errorf("Invalid final response for %s: %v %v", row.msg, msg.Result, msg.Error)
+				}
+				break
+			}
+			if msg.Params.Token != row.token || msg.Params.Value.Message != "step" {
+				t.Errorf("Invalid progress for %s: %+v", row.msg, msg.Params)
+			}
+			progress = append(progress, msg.Params.Value.Percent)
+		}
+
+		if !reflect.DeepEqual(progress, row.progress) {
+			t.Errorf("Invalid progress for %s, expected: %v, got: %v", row.msg, row.progress, progress)
+		}
+	}
+}
+
+func TestCallProgress(t *testing.T) {
+	wsj := &WsJson{}
+	wsj.AddService(&ReportService{})
+	server := startServer(t, wsj)
+
+	conn, _, err := dialServer(server, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := newWsJsonClient(newServiceManager(), conn, []interface{}{&WhoAmIService{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.serve()
+	defer client.close()
+
+	var percents []float64
+	ctx := WithProgress(context.Background(), func(p Progress) {
+		percents = append(percents, p.Percent)
+	})
+	var result string
+	err = client.Call(ctx, "ReportService.Generate", []int{5}, &result)
+	if err != nil || result != "done" || !reflect.DeepEqual(percents, []float64{20, 40, 60, 80, 100}) {
+		t.Errorf("Invalid call with progress, got: %v %s %v", err, result, percents)
+	}
+
+	var reports int
+	ch, err := client.CallMethodProgress("ReportService.Generate", []int{3}, func(p Progress) {
+		reports++
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case raw := <-ch:
+		if err := client.Decode(raw, &result); err != nil || result != "done" || reports != 3 {
+			t.Errorf("Invalid CallMethodProgress result, got: %v %s %d", err, result, reports)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("CallMethodProgress should receive the result")
+	}
+}
@@ -0,0 +1,106 @@
+package wsjson
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Plain JSON-RPC 2.0, the default protocol
+	ProtocolJSONRPC = "jsonrpc-2.0"
+)
+
+// Optional parts of the wire protocol, enabled per protocol
+type Features uint
+
+const (
+	// The peer is notified with EventMethodsChanged when services are added or removed
+	FeatureMethodsChanged Features = 1 << iota
+	// Methods of the peer can be called from this side of the connection
+	FeatureServerCalls
+	// Connections can be resumed with the messages missed, when sessions are enabled
+	FeatureSessions
+
+	// All the features, used by connections without a negotiated protocol
+	AllFeatures Features = FeatureMethodsChanged | FeatureServerCalls | FeatureSessions
+)
+
+var (
+	// Returned when the feature needed isn't enabled by the protocol of the connection
+	ErrFeatureNotSupported = errors.New("Feature not supported by the protocol")
+
+	// Protocol of the connections when no protocols are configured
+	legacyProtocol = &Protocol{Features: AllFeatures}
+)
+
+// A subprotocol that can be negotiated with Sec-WebSocket-Protocol
+type Protocol struct {
+	// Value of the Sec-WebSocket-Protocol header, like "wsjson.v2"
+	Name     string
+	Features Features
+	// Encoding of the messages, JSONCodec if nil
+	Codec Codec
+}
+
+// Whether the protocol enables all the features
+func (p *Protocol) Has(features Features) bool {
+	return p.Features&features == features
+}
+
+// Set the subprotocols accepted, in order of preference.
+// Clients that don't request a subprotocol use the first one with the JSON codec, they
+// are rejected without one, as are clients requesting only unsupported subprotocols
+func (wsj *WsJson) SetProtocols(protocols ...Protocol) {
+	wsj.protocols = protocols
+}
+
+// Choose the protocol of the upgrade request
+func (wsj *WsJson) negotiateProtocol(r *http.Request) (*Protocol, error) {
+	if len(wsj.protocols) == 0 {
+		return legacyProtocol, nil
+	}
+
+	requested := websocket.Subprotocols(r)
+	if len(requested) == 0 {
+		// clients that don't know about subprotocols speak JSON
+		for i := range wsj.protocols {
+			if wsj.protocols[i].isJSON() {
+				return &wsj.protocols[i], nil
+			}
+		}
+		return nil, errors.New("Subprotocol required, there are no JSON protocols")
+	}
+	for i := range wsj.protocols {
+		for _, name := range requested {
+			if name == wsj.protocols[i].Name {
+				return &wsj.protocols[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("Unsupported protocols: %s", strings.Join(requested, ", "))
+}
+
+// Whether the messages of the protocol are encoded as JSON
+func (p *Protocol) isJSON() bool {
+	return p.Codec == nil || p.Codec == JSONCodec
+}
+
+// Header announcing the protocol chosen, nil when the client didn't request any
+func protocolHeader(r *http.Request, protocol *Protocol) http.Header {
+	if len(websocket.Subprotocols(r)) == 0 || protocol.Name == "" {
+		return nil
+	}
+	return http.Header{"Sec-Websocket-Protocol": {protocol.Name}}
+}
+
+// Protocol negotiated for the connection
+func (wsjc *WsJsonClient) Protocol() *Protocol {
+	if wsjc.protocol == nil {
+		return legacyProtocol
+	}
+	return wsjc.protocol
+}
@@ -0,0 +1,120 @@
+package wsjson
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestProtocolNegotiation(t *testing.T) {
+	wsj := &WsJson{}
+	wsj.SetProtocols(
+		Protocol{Name: "wsjson.v2+bin", Features: AllFeatures, Codec: binaryCodec{}},
+		Protocol{Name: "wsjson.v2", Features: AllFeatures},
+		Protocol{Name: ProtocolJSONRPC},
+	)
+
+	clients := make(chan *WsJsonClient, 1)
+	wsj.SetApiFactory(func(w http.ResponseWriter, r *http.Request) []interface{} {
+		return []interface{}{&WhoAmIService{}}
+	})
+	wsj.AddService(&protocolService{clients})
+	server := startServer(t, wsj)
+	connected := `{"jsonrpc": "2.0", "method": "protocolService.Connected", "id": 1}`
+
+	var table = []struct {
+		requested []string
+		selected  string
+		protocol  string
+	}{
+		{nil, "", "wsjson.v2"},
+		{[]string{ProtocolJSONRPC}, ProtocolJSONRPC, ProtocolJSONRPC},
+		{[]string{ProtocolJSONRPC, "wsjson.v2"}, "wsjson.v2", "wsjson.v2"},
+		{[]string{"wsjson.v1", ProtocolJSONRPC}, ProtocolJSONRPC, ProtocolJSONRPC},
+	}
+
+	for _, row := range table {
+		dialer := websocket.Dialer{Subprotocols: row.requested}
+		conn, _, err := dialer.Dial("ws"+server.URL[4:], nil)
+		if err != nil {
+			t.Fatalf("Error connecting with %v: %v", row.requested, err)
+		}
+		if conn.Subprotocol() != row.selected {
+			t.Errorf("Invalid subprotocol for %v, expected: %q, got: %q", row.requested, row.selected, conn.Subprotocol())
+		}
+
+		roundTrip(t, conn, connected)
+		client := <-clients
+		if client.Protocol().Name != row.protocol {
+			t.Errorf("Invalid protocol of the connection for %v, expected: %s, got: %s", row.requested, row.protocol, client.Protocol().Name)
+		}
+		conn.Close()
+	}
+
+	// unsupported protocols
+	lc := startLogCapture()
+	dialer := websocket.Dialer{Subprotocols: []string{"wsjson.v1"}}
+	_, resp, err := dialer.Dial("ws"+server.URL[4:], nil)
+	lc.stop()
+	if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Unsupported protocols should be rejected, got: %v", err)
+	}
+	if !lc.contains("Unsupported protocols: wsjson.v1") {
+		t.Errorf("Unsupported protocols should be logged: %v", lc.buffer)
+	}
+
+	// clients without subprotocol need a JSON protocol
+	wsj = &WsJson{}
+	wsj.SetProtocols(Protocol{Name: "wsjson.v2+bin", Features: AllFeatures, Codec: binaryCodec{}})
+	wsj.AddService(&WhoAmIService{})
+	binServer := startServer(t, wsj)
+	lc = startLogCapture()
+	_, resp, err = websocket.DefaultDialer.Dial("ws"+binServer.URL[4:], nil)
+	lc.stop()
+	if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Clients without subprotocol should be rejected without JSON protocols, got: %v", err)
+	}
+}
+
+// JSON in binary frames
+type binaryCodec struct {
+	jsonCodec
+}
+
+func (binaryCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+type protocolService struct {
+	clients chan *WsJsonClient
+}
+
+func (ps *protocolService) ApiConnected(ctx context.Context) (bool, error) {
+	ps.clients <- ClientFromContext(ctx)
+	return true, nil
+}
+
+func TestProtocolFeatures(t *testing.T) {
+	client, err := newWsJsonClient(newServiceManager(), nil, []interface{}{&WhoAmIService{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.protocol = &Protocol{Name: ProtocolJSONRPC}
+
+	if err := client.Call(context.Background(), "peer.Method", nil, nil); err != ErrFeatureNotSupported {
+		t.Errorf("Calls should need FeatureServerCalls, got: %v", err)
+	}
+
+	if err := client.AddService(&protocolService{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.output) != 0 {
+		t.Errorf("Methods changes should need FeatureMethodsChanged")
+	}
+
+	if !legacyProtocol.Has(FeatureServerCalls | FeatureMethodsChanged) {
+		t.Errorf("Connections without protocol should have all the features")
+	}
+}
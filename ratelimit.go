@@ -0,0 +1,334 @@
+package wsjson
+
+import (
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// Buckets of principals are pruned when there are more than this
+	maxIdleBuckets = 1024
+)
+
+// Token bucket configuration, Rate calls per second are allowed with bursts of up to Burst calls.
+// Burst is at least 1, the rate rounded up if zero. The zero value doesn't limit the calls
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// Rate limits for the calls received, each call must be allowed by all of them
+type RateLimits struct {
+	// Calls of each connection
+	Connection RateLimit
+	// Calls of each authenticated principal, shared by all its connections
+	Principal RateLimit
+	// Calls of each method on a connection, by full method name
+	Methods map[string]RateLimit
+	// Close connections after this number of consecutive calls over the limits, 0 never closes them
+	MaxViolations int
+}
+
+// Data of the error returned to calls over the limits
+type RateLimitData struct {
+	// Milliseconds until the next call is allowed
+	RetryAfter int64 `json:"retryAfter"`
+}
+
+func (rl RateLimit) enabled() bool {
+	return rl.Rate > 0
+}
+
+// The limit with the default burst, a bucket without burst would never allow a call
+func (rl RateLimit) withDefaults() RateLimit {
+	if rl.enabled() && rl.Burst <= 0 {
+		rl.Burst = int(math.Max(1, math.Ceil(rl.Rate)))
+	}
+	return rl
+}
+
+type tokenBucket struct {
+	mutex  sync.Mutex
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		limit:  limit,
+		tokens: float64(limit.Burst),
+		last:   now,
+	}
+}
+
+// Add the tokens accumulated since the last time, must be called with the mutex locked
+func (tb *tokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(tb.last).Seconds()
+	if elapsed > 0 {
+		tb.tokens = math.Min(float64(tb.limit.Burst), tb.tokens+elapsed*tb.limit.Rate)
+		tb.last = now
+	}
+}
+
+// Time until a token is available, must be called with the mutex locked
+func (tb *tokenBucket) wait() time.Duration {
+	if tb.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - tb.tokens) / tb.limit.Rate * float64(time.Second))
+}
+
+// Time until a token is available without taking it, 0 if there is one
+func (tb *tokenBucket) available(now time.Time) time.Duration {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+	tb.refill(now)
+	return tb.wait()
+}
+
+// Take a token, returns the time until one is available when there are none left
+func (tb *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+	tb.refill(now)
+	if wait := tb.wait(); wait > 0 {
+		return false, wait
+	}
+	tb.tokens--
+	return true, 0
+}
+
+// Return a token taken for a call that was rejected by another bucket
+func (tb *tokenBucket) refund() {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+	tb.tokens = math.Min(float64(tb.limit.Burst), tb.tokens+1)
+}
+
+// Whether the bucket has been refilled, it can be discarded
+func (tb *tokenBucket) full(now time.Time) bool {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+	return tb.tokens+now.Sub(tb.last).Seconds()*tb.limit.Rate >= float64(tb.limit.Burst)
+}
+
+// Buckets of the principals, shared by all the connections
+type principalLimiter struct {
+	mutex     sync.Mutex
+	limit     RateLimit
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+// Bucket of a principal, created when first used
+func (pl *principalLimiter) bucket(id string, now time.Time) *tokenBucket {
+	pl.mutex.Lock()
+	defer pl.mutex.Unlock()
+	bucket, ok := pl.buckets[id]
+	if !ok {
+		pl.prune(now)
+		bucket = newTokenBucket(pl.limit, now)
+		pl.buckets[id] = bucket
+	}
+	return bucket
+}
+
+// Discard the buckets that have been refilled, must be called with the mutex locked
+func (pl *principalLimiter) prune(now time.Time) {
+	if len(pl.buckets) < maxIdleBuckets || now.Sub(pl.lastPrune) < time.Minute {
+		return
+	}
+	pl.lastPrune = now
+	for id, bucket := range pl.buckets {
+		if bucket.full(now) {
+			delete(pl.buckets, id)
+		}
+	}
+}
+
+// Rate limits of a connection
+type connLimiter struct {
+	limits     RateLimits
+	principals *principalLimiter
+	conn       *tokenBucket
+
+	mutex      sync.Mutex
+	methods    map[string]*tokenBucket
+	violations int
+}
+
+func newConnLimiter(limits RateLimits, principals *principalLimiter) *connLimiter {
+	cl := &connLimiter{
+		limits:     limits,
+		principals: principals,
+		methods:    make(map[string]*tokenBucket),
+	}
+	if limits.Connection.enabled() {
+		cl.conn = newTokenBucket(limits.Connection, time.Now())
+	}
+	return cl
+}
+
+// Check a call is allowed, returns an error with the time to wait if it isn't and
+// whether the connection should be closed
+func (cl *connLimiter) allow(method string, principal Principal) (*Error, bool) {
+	now := time.Now()
+	ok, wait := cl.take(method, principal, now)
+
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	if ok {
+		cl.violations = 0
+		return nil, false
+	}
+
+	cl.violations++
+	err := NewErrorWithData(ErrorRateLimited, "Rate limit exceeded",
+		&RateLimitData{RetryAfter: int64(math.Ceil(float64(wait) / float64(time.Millisecond)))})
+	return err, cl.limits.MaxViolations > 0 && cl.violations >= cl.limits.MaxViolations
+}
+
+// Take a token from each bucket of the call, none is taken unless all of them have one
+func (cl *connLimiter) take(method string, principal Principal, now time.Time) (bool, time.Duration) {
+	buckets := cl.buckets(method, principal, now)
+	var wait time.Duration
+	for _, bucket := range buckets {
+		if available := bucket.available(now); available > wait {
+			wait = available
+		}
+	}
+	if wait > 0 {
+		return false, wait
+	}
+
+	for i, bucket := range buckets {
+		if ok, wait := bucket.take(now); !ok {
+			// taken meanwhile by another connection of the principal
+			for _, taken := range buckets[:i] {
+				taken.refund()
+			}
+			return false, wait
+		}
+	}
+	return true, 0
+}
+
+// Buckets limiting a call
+func (cl *connLimiter) buckets(method string, principal Principal, now time.Time) []*tokenBucket {
+	var buckets []*tokenBucket
+	if limit, ok := cl.limits.Methods[method]; ok && limit.enabled() {
+		cl.mutex.Lock()
+		bucket, ok := cl.methods[method]
+		if !ok {
+			bucket = newTokenBucket(limit, now)
+			cl.methods[method] = bucket
+		}
+		cl.mutex.Unlock()
+		buckets = append(buckets, bucket)
+	}
+	if cl.conn != nil {
+		buckets = append(buckets, cl.conn)
+	}
+	if cl.principals != nil && principal != nil {
+		buckets = append(buckets, cl.principals.bucket(principal.Id(), now))
+	}
+	return buckets
+}
+
+// Whether all the buckets of the connection have been refilled, it can be discarded
+func (cl *connLimiter) full(now time.Time) bool {
+	if cl.conn != nil && !cl.conn.full(now) {
+		return false
+	}
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	for _, bucket := range cl.methods {
+		if !bucket.full(now) {
+			return false
+		}
+	}
+	return true
+}
+
+// Limiters of the HTTP POST requests by remote host, each host is limited like a connection
+type hostLimiters struct {
+	mutex      sync.Mutex
+	limits     RateLimits
+	principals *principalLimiter
+	limiters   map[string]*connLimiter
+	lastPrune  time.Time
+}
+
+// Limiter of a remote host, created when first used
+func (hl *hostLimiters) limiter(host string) *connLimiter {
+	hl.mutex.Lock()
+	defer hl.mutex.Unlock()
+	limiter, ok := hl.limiters[host]
+	if !ok {
+		hl.prune(time.Now())
+		limiter = newConnLimiter(hl.limits, hl.principals)
+		hl.limiters[host] = limiter
+	}
+	return limiter
+}
+
+// Discard the limiters that have been refilled, must be called with the mutex locked
+func (hl *hostLimiters) prune(now time.Time) {
+	if len(hl.limiters) < maxIdleBuckets || now.Sub(hl.lastPrune) < time.Minute {
+		return
+	}
+	hl.lastPrune = now
+	for host, limiter := range hl.limiters {
+		if limiter.full(now) {
+			delete(hl.limiters, host)
+		}
+	}
+}
+
+// Set the rate limits of the calls received, they apply to new connections
+func (wsj *WsJson) SetRateLimits(limits RateLimits) {
+	limits.Connection = limits.Connection.withDefaults()
+	limits.Principal = limits.Principal.withDefaults()
+	methods := make(map[string]RateLimit, len(limits.Methods))
+	for method, limit := range limits.Methods {
+		methods[method] = limit.withDefaults()
+	}
+	limits.Methods = methods
+	wsj.rateLimits = &limits
+	wsj.principalLimiter = nil
+	if limits.Principal.enabled() {
+		wsj.principalLimiter = &principalLimiter{
+			limit:   limits.Principal,
+			buckets: make(map[string]*tokenBucket),
+		}
+	}
+	wsj.hostLimiters = &hostLimiters{
+		limits:     limits,
+		principals: wsj.principalLimiter,
+		limiters:   make(map[string]*connLimiter),
+	}
+}
+
+// Rate limiter for a new connection, nil if there are no limits
+func (wsj *WsJson) newConnLimiter() *connLimiter {
+	if wsj.rateLimits == nil {
+		return nil
+	}
+	return newConnLimiter(*wsj.rateLimits, wsj.principalLimiter)
+}
+
+// Rate limiter of the HTTP POST requests of a remote address, nil if there are no limits.
+// The requests of a host share the limits of a connection
+func (wsj *WsJson) hostLimiter(remoteAddr string) *connLimiter {
+	if wsj.hostLimiters == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return wsj.hostLimiters.limiter(host)
+}
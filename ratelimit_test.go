@@ -0,0 +1,171 @@
+package wsjson
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Now()
+	bucket := newTokenBucket(RateLimit{Rate: 2, Burst: 2}, start)
+
+	var table = []struct {
+		elapsed time.Duration
+		allowed bool
+		wait    time.Duration
+	}{
+		{0, true, 0},
+		{0, true, 0},
+		{0, false, 500 * time.Millisecond},
+		{250 * time.Millisecond, false, 250 * time.Millisecond},
+		{500 * time.Millisecond, true, 0},
+		{10 * time.Second, true, 0},
+		{10 * time.Second, true, 0},
+		{10 * time.Second, false, 500 * time.Millisecond},
+	}
+
+	for i, row := range table {
+		allowed, wait := bucket.take(start.Add(row.elapsed))
+		if allowed != row.allowed || (wait-row.wait).Abs() > time.Millisecond {
+			t.Errorf("Invalid take %d, expected: %v %v, got: %v %v", i, row.allowed, row.wait, allowed, wait)
+		}
+	}
+}
+
+func TestConnLimiter(t *testing.T) {
+	wsj := &WsJson{}
+	wsj.SetRateLimits(RateLimits{
+		Connection: RateLimit{Rate: 0.1},
+		Principal:  RateLimit{Rate: 2.5},
+		Methods:    map[string]RateLimit{"search": {Rate: 0.1, Burst: 2}},
+	})
+	if burst := wsj.rateLimits.Connection.Burst; burst != 1 {
+		t.Errorf("The burst should be at least 1, got %d", burst)
+	}
+	if burst := wsj.rateLimits.Principal.Burst; burst != 3 {
+		t.Errorf("The burst should default to the rate rounded up, got %d", burst)
+	}
+
+	now := time.Now()
+	limiter := wsj.newConnLimiter()
+	if ok, _ := limiter.take("search", nil, now); !ok {
+		t.Errorf("The first call should be allowed")
+	}
+	// rejected by the connection bucket
+	if ok, wait := limiter.take("search", nil, now); ok || wait <= 0 {
+		t.Errorf("The second call should be rejected, got %v %v", ok, wait)
+	}
+	if wait := limiter.methods["search"].available(now); wait != 0 {
+		t.Errorf("Rejected calls should not take tokens from the other buckets, wait %v", wait)
+	}
+}
+
+// Error data of a rate limited response
+func retryAfter(t *testing.T, resp *Response) int64 {
+	if resp.Err == nil || resp.Err.Code != ErrorRateLimited {
+		t.Fatalf("Call should be rate limited: %+v", resp)
+	}
+	data, _ := json.Marshal(resp.Err.Data)
+	var limitData RateLimitData
+	if err := json.Unmarshal(data, &limitData); err != nil {
+		t.Fatal(err)
+	}
+	return limitData.RetryAfter
+}
+
+func TestRateLimits(t *testing.T) {
+	wsj := &WsJson{}
+	wsj.SetAuthenticator(&BearerAuthenticator{Validate: validateToken})
+	wsj.AddService(&WhoAmIService{})
+	wsj.SetRateLimits(RateLimits{
+		Connection: RateLimit{Rate: 0.1, Burst: 3},
+		Principal:  RateLimit{Rate: 0.1, Burst: 4},
+		Methods:    map[string]RateLimit{"rpc.methods": {Rate: 0.1, Burst: 1}},
+	})
+	server := startServer(t, wsj)
+	whoAmI := `{"jsonrpc": "2.0", "method": "WhoAmIService.WhoAmI", "id": 1}`
+	listMethods := `{"jsonrpc": "2.0", "method": "rpc.methods", "id": 2}`
+
+	// per connection
+	conn, _, err := dialServer(server, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for i := 0; i < 3; i++ {
+		if resp := roundTrip(t, conn, whoAmI); resp.Err != nil {
+			t.Errorf("Call %d should be allowed: %+v", i, resp.Err)
+		}
+	}
+	if wait := retryAfter(t, roundTrip(t, conn, whoAmI)); wait <= 0 || wait > 10000 {
+		t.Errorf("Invalid retry after: %d", wait)
+	}
+
+	// per method
+	conn2, _, err := dialServer(server, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	if resp := roundTrip(t, conn2, listMethods); resp.Err != nil {
+		t.Errorf("First call should be allowed: %+v", resp.Err)
+	}
+	retryAfter(t, roundTrip(t, conn2, listMethods))
+	if resp := roundTrip(t, conn2, whoAmI); resp.Err != nil {
+		t.Errorf("Other methods should be allowed: %+v", resp.Err)
+	}
+
+	// per principal, shared by the connections
+	header := http.Header{"Authorization": {"Bearer valid-limited"}}
+	for i := 0; i < 2; i++ {
+		conn, _, err := dialServer(server, "/", header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		for j := 0; j < 2; j++ {
+			if resp := roundTrip(t, conn, whoAmI); resp.Err != nil {
+				t.Errorf("Call %d of connection %d should be allowed: %+v", j, i, resp.Err)
+			}
+		}
+	}
+	conn3, _, err := dialServer(server, "/", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn3.Close()
+	retryAfter(t, roundTrip(t, conn3, whoAmI))
+}
+
+func TestRateLimitsClose(t *testing.T) {
+	wsj := &WsJson{}
+	wsj.AddService(&WhoAmIService{})
+	wsj.SetRateLimits(RateLimits{
+		Connection:    RateLimit{Rate: 0.1, Burst: 1},
+		MaxViolations: 2,
+	})
+	server := startServer(t, wsj)
+	whoAmI := `{"jsonrpc": "2.0", "method": "WhoAmIService.WhoAmI", "id": 1}`
+
+	conn, _, err := dialServer(server, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	lc := startLogCapture()
+	defer lc.stop()
+	roundTrip(t, conn, whoAmI)
+	retryAfter(t, roundTrip(t, conn, whoAmI))
+	retryAfter(t, roundTrip(t, conn, whoAmI))
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("Connection should be closed after repeated violations, got: %v", err)
+	}
+}
@@ -0,0 +1,408 @@
+package wsjson
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// Returned by the calls made while disconnected with FailCalls
+	ErrDisconnected = errors.New("Disconnected")
+)
+
+// State of a ReconnectingClient
+type ConnState int
+
+const (
+	// Connecting for the first time
+	StateConnecting ConnState = iota
+	StateConnected
+	// The connection was lost, connecting again
+	StateReconnecting
+	StateClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	}
+	return "ConnState(" + strconv.Itoa(int(s)) + ")"
+}
+
+// What happens to the calls made while disconnected
+type OfflinePolicy int
+
+const (
+	// Calls wait to be sent until connected, or their context is done
+	QueueCalls OfflinePolicy = iota
+	// Calls fail at once with ErrDisconnected
+	FailCalls
+)
+
+// Exponential backoff between the attempts to connect, each delay is randomized
+// between half and all of it so clients don't reconnect at once
+type Backoff struct {
+	// Delay after the first failed attempt, 100ms if zero
+	Initial time.Duration
+	// Maximum delay, 30s if zero
+	Max time.Duration
+	// Growth of the delay after each failed attempt, 2 if zero
+	Multiplier float64
+}
+
+// Delay after the given number of failed attempts, from 1
+func (b Backoff) delay(attempts int, rnd *rand.Rand) time.Duration {
+	initial, max, multiplier := b.Initial, b.Max, b.Multiplier
+	if initial <= 0 {
+		initial = 100 * time.Millisecond
+	}
+	if max <= 0 {
+		max = 30 * time.Second
+	}
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	d := float64(initial)
+	for i := 1; i < attempts && d < float64(max); i++ {
+		d *= multiplier
+	}
+	if d > float64(max) {
+		d = float64(max)
+	}
+	return time.Duration(d/2 + rnd.Float64()*d/2)
+}
+
+// Options of DialReconnecting
+type ReconnectOptions struct {
+	// Header of the upgrade requests
+	Header  http.Header
+	Backoff Backoff
+	// Time allowed to connect and run the handshake, 10s if zero
+	Timeout time.Duration
+	// Runs on each new connection before the calls waiting are sent, to authenticate or
+	// subscribe. Connections resuming their session keep their state and skip it.
+	// An error closes the connection and counts as a failed attempt
+	Handshake func(ctx context.Context, client *WsJsonClient) error
+	Policy    OfflinePolicy
+	// Receives each change of state, err is the cause of the disconnections.
+	// Called in order from the goroutine that connects
+	OnStateChange func(state ConnState, err error)
+}
+
+// A client that connects again when its connection is lost, see DialReconnecting
+type ReconnectingClient struct {
+	wsj     *WsJson
+	url     string
+	options ReconnectOptions
+	rnd     *rand.Rand
+
+	mutex  sync.Mutex
+	state  ConnState
+	client *WsJsonClient
+	// closed once connected, replaced when disconnected
+	connected chan struct{}
+	// receives the loss of the connection
+	lost chan error
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// DialReconnecting connects to a wsjson server in the background and connects again with
+// exponential backoff whenever the connection is lost, until closed. When the server
+// has sessions they are resumed, and the calls waiting for their response get it, otherwise
+// those calls fail with ErrConnectionClosed. The server can call the global services of wsj
+func (wsj *WsJson) DialReconnecting(url string, options ReconnectOptions) *ReconnectingClient {
+	if options.Timeout <= 0 {
+		options.Timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	rc := &ReconnectingClient{
+		wsj:       wsj,
+		url:       url,
+		options:   options,
+		rnd:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		state:     StateConnecting,
+		connected: make(chan struct{}),
+		lost:      make(chan error, 1),
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	go rc.run()
+	return rc
+}
+
+// Call a method of the server, see WsJsonClient.Call. Calls made while disconnected
+// wait or fail according to the OfflinePolicy
+func (rc *ReconnectingClient) Call(ctx context.Context, name string, params interface{}, result interface{}) error {
+	client, err := rc.ready(ctx)
+	if err != nil {
+		return err
+	}
+	return client.Call(ctx, name, params, result)
+}
+
+// Send a notification to the server, notifications sent while disconnected
+// wait or fail according to the OfflinePolicy
+func (rc *ReconnectingClient) Notify(ctx context.Context, name string, params interface{}) error {
+	client, err := rc.ready(ctx)
+	if err != nil {
+		return err
+	}
+	request, err := client.newRequest(name, params)
+	if err != nil {
+		return err
+	}
+	return client.sendContext(ctx, request)
+}
+
+// Client of the current connection, the same one while the client is connected
+// and resumed, nil before the first connection
+func (rc *ReconnectingClient) Client() *WsJsonClient {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	return rc.client
+}
+
+func (rc *ReconnectingClient) State() ConnState {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	return rc.state
+}
+
+// Close the connection and stop reconnecting, calls waiting fail with ErrConnectionClosed
+func (rc *ReconnectingClient) Close() error {
+	rc.cancel()
+	<-rc.done
+	return nil
+}
+
+// Client to make a call, waiting until connected with QueueCalls
+func (rc *ReconnectingClient) ready(ctx context.Context) (*WsJsonClient, error) {
+	rc.mutex.Lock()
+	state, client, connected := rc.state, rc.client, rc.connected
+	rc.mutex.Unlock()
+	switch {
+	case state == StateConnected:
+		return client, nil
+	case state == StateClosed:
+		return nil, ErrConnectionClosed
+	case rc.options.Policy == FailCalls:
+		return nil, ErrDisconnected
+	}
+
+	select {
+	case <-connected:
+		return rc.ready(ctx)
+	case <-rc.done:
+		return nil, ErrConnectionClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (rc *ReconnectingClient) setState(state ConnState, err error) {
+	rc.mutex.Lock()
+	if rc.state == state {
+		rc.mutex.Unlock()
+		return
+	}
+	rc.state = state
+	if state == StateConnected {
+		close(rc.connected)
+	} else {
+		select {
+		case <-rc.connected:
+			rc.connected = make(chan struct{})
+		default:
+		}
+	}
+	rc.mutex.Unlock()
+	if rc.options.OnStateChange != nil {
+		rc.options.OnStateChange(state, err)
+	}
+}
+
+// Connect until closed
+func (rc *ReconnectingClient) run() {
+	defer func() {
+		if client := rc.Client(); client != nil {
+			client.Close()
+		}
+		rc.setState(StateClosed, nil)
+		close(rc.done)
+	}()
+
+	failures := 0
+	for {
+		err := rc.connect()
+		if err == nil {
+			failures = 0
+			rc.setState(StateConnected, nil)
+			select {
+			case err = <-rc.lost:
+			case <-rc.Client().Done():
+				// closed through the client itself
+				return
+			case <-rc.ctx.Done():
+				return
+			}
+		} else {
+			failures++
+		}
+		if rc.ctx.Err() != nil {
+			return
+		}
+		if rc.State() != StateConnecting {
+			rc.setState(StateReconnecting, err)
+		}
+
+		if failures > 0 {
+			timer := time.NewTimer(rc.options.Backoff.delay(failures, rc.rnd))
+			select {
+			case <-timer.C:
+			case <-rc.ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}
+}
+
+// Dial a connection, resuming the session if any, and run the handshake on new sessions
+func (rc *ReconnectingClient) connect() error {
+	ctx, cancel := context.WithTimeout(rc.ctx, rc.options.Timeout)
+	defer cancel()
+
+	client := rc.Client()
+	if client == nil {
+		conn, err := rc.wsj.dialConn(ctx, rc.url, rc.options.Header)
+		if err != nil {
+			return err
+		}
+		if client, err = rc.wsj.newDialedClient(conn); err != nil {
+			conn.Close()
+			return err
+		}
+		client.onConnLost = rc.connLost
+		client.serve()
+		rc.mutex.Lock()
+		rc.client = client
+		rc.mutex.Unlock()
+		return rc.handshake(ctx, client, conn)
+	}
+
+	var token string
+	var seq int64
+	if client.tracker != nil {
+		token, seq = client.tracker.state()
+	}
+	target := rc.url
+	var started <-chan bool
+	if token != "" {
+		u, err := url.Parse(rc.url)
+		if err != nil {
+			return err
+		}
+		query := u.Query()
+		query.Set(SessionParam, token)
+		query.Set(SessionSeqParam, strconv.FormatInt(seq, 10))
+		u.RawQuery = query.Encode()
+		target = u.String()
+		started = client.tracker.expectSession()
+	}
+
+	conn, err := rc.wsj.dialConn(ctx, target, rc.options.Header)
+	if err != nil {
+		return err
+	}
+	if conn.Subprotocol() != client.Protocol().Name {
+		conn.Close()
+		return fmt.Errorf("Protocol %q instead of %q", conn.Subprotocol(), client.Protocol().Name)
+	}
+	if started == nil {
+		// without a session to resume the responses of the calls made were lost with
+		// the previous connection, they fail before the new one is attached
+		failPendingCalls(client)
+	}
+	// once flushed the connection is attached, and its loss is reported
+	if err := client.send(resumeConn{conn: conn}); err != nil {
+		conn.Close()
+		return err
+	}
+	if err := client.Flush(ctx); err != nil {
+		rc.dropConn(client, conn)
+		return err
+	}
+
+	resumed := false
+	if started != nil {
+		select {
+		case resumed = <-started:
+		case <-ctx.Done():
+			rc.dropConn(client, conn)
+			return ctx.Err()
+		}
+	}
+	if resumed {
+		return nil
+	}
+	if started != nil {
+		// a new session, it can't answer the calls made on the previous connection
+		failPendingCalls(client)
+	}
+	return rc.handshake(ctx, client, conn)
+}
+
+// Fail the calls waiting for a response with ErrConnectionClosed
+func failPendingCalls(client *WsJsonClient) {
+	client.resultsMutex.Lock()
+	defer client.resultsMutex.Unlock()
+	client.closePendingResults()
+}
+
+func (rc *ReconnectingClient) handshake(ctx context.Context, client *WsJsonClient, conn *websocket.Conn) error {
+	if rc.options.Handshake == nil {
+		return nil
+	}
+	if err := rc.options.Handshake(ctx, client); err != nil {
+		rc.dropConn(client, conn)
+		return fmt.Errorf("Handshake failed: %w", err)
+	}
+	return nil
+}
+
+// Close the connection of a failed attempt, waiting for its loss to be reported
+func (rc *ReconnectingClient) dropConn(client *WsJsonClient, conn *websocket.Conn) {
+	conn.Close()
+	select {
+	case <-rc.lost:
+	case <-client.Done():
+	case <-rc.ctx.Done():
+	}
+}
+
+func (rc *ReconnectingClient) connLost(err error) {
+	select {
+	case rc.lost <- err:
+	case <-rc.ctx.Done():
+	}
+}
@@ -0,0 +1,253 @@
+package wsjson
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Records the states of a ReconnectingClient
+type stateRecorder struct {
+	mutex  sync.Mutex
+	states []ConnState
+}
+
+func (sr *stateRecorder) record(state ConnState, err error) {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+	sr.states = append(sr.states, state)
+}
+
+func (sr *stateRecorder) get() []ConnState {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+	return append([]ConnState(nil), sr.states...)
+}
+
+func wsURL(server *httptest.Server) string {
+	return "ws" + server.URL[len("http"):]
+}
+
+// Wait for the state of the client
+func waitState(t *testing.T, rc *ReconnectingClient, state ConnState) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for rc.State() != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("The client should be %v, got %v", state, rc.State())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Wait for the states changed by the client
+func (sr *stateRecorder) wait(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(sr.get()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("The client should change state %d times, got %v", n, sr.get())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Lose the connection of the client as when the network fails
+func loseConn(rc *ReconnectingClient) {
+	if conn := rc.Client().currentConn(); conn != nil {
+		dropConn(conn)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	_, server, _ := startSessionServer(t, nil)
+	var handshakes int
+	var mutex sync.Mutex
+	recorder := &stateRecorder{}
+	rc := (&WsJson{}).DialReconnecting(wsURL(server), ReconnectOptions{
+		Backoff: Backoff{Initial: 10 * time.Millisecond},
+		Handshake: func(ctx context.Context, client *WsJsonClient) error {
+			mutex.Lock()
+			handshakes++
+			mutex.Unlock()
+			return client.Call(ctx, "CounterService.Incr", nil, nil)
+		},
+		OnStateChange: recorder.record,
+	})
+	defer rc.Close()
+
+	var count int
+	if err := rc.Call(context.Background(), "CounterService.Incr", nil, &count); err != nil || count != 2 {
+		t.Fatalf("The call should follow the handshake, got: %d %v", count, err)
+	}
+	client := rc.Client()
+	pending := make(chan error, 1)
+	go func() {
+		pending <- rc.Call(context.Background(), "CounterService.Wait", nil, nil)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	loseConn(rc)
+	recorder.wait(t, 3)
+	select {
+	case err := <-pending:
+		if err != ErrConnectionClosed {
+			t.Errorf("Calls waiting without a session should fail, got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Calls waiting without a session should fail")
+	}
+	if err := rc.Call(context.Background(), "CounterService.Incr", nil, &count); err != nil || count != 2 {
+		t.Errorf("The call should wait for the new connection and its handshake, got: %d %v", count, err)
+	}
+	if rc.Client() != client {
+		t.Errorf("The client should be kept across connections")
+	}
+	mutex.Lock()
+	if handshakes != 2 {
+		t.Errorf("The handshake should run on each connection, got %d", handshakes)
+	}
+	mutex.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := rc.Notify(ctx, "CounterService.Incr", nil); err != context.Canceled {
+		t.Errorf("Notifications should use their context, got: %v", err)
+	}
+
+	rc.Close()
+	expected := []ConnState{StateConnected, StateReconnecting, StateConnected, StateClosed}
+	if states := recorder.get(); len(states) != len(expected) {
+		t.Errorf("States should be %v, got %v", expected, states)
+	} else {
+		for i := range states {
+			if states[i] != expected[i] {
+				t.Errorf("States should be %v, got %v", expected, states)
+				break
+			}
+		}
+	}
+	if err := rc.Call(context.Background(), "CounterService.Incr", nil, nil); err != ErrConnectionClosed {
+		t.Errorf("Calls after closing should fail, got: %v", err)
+	}
+}
+
+func TestReconnectSession(t *testing.T) {
+	_, server, release := startSessionServer(t, &SessionOptions{GracePeriod: time.Second})
+	var handshakes int
+	var mutex sync.Mutex
+	rc := (&WsJson{}).DialReconnecting(wsURL(server), ReconnectOptions{
+		Backoff: Backoff{Initial: 10 * time.Millisecond},
+		Handshake: func(ctx context.Context, client *WsJsonClient) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			handshakes++
+			return nil
+		},
+	})
+	defer rc.Close()
+
+	if err := rc.Call(context.Background(), "CounterService.Incr", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	result := make(chan error, 1)
+	go func() {
+		var done string
+		err := rc.Call(context.Background(), "CounterService.Wait", nil, &done)
+		if err == nil && done != "done" {
+			err = errors.New("Unexpected result " + done)
+		}
+		result <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	loseConn(rc)
+	close(release)
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("The response should be replayed on the resumed session, got: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The response should be replayed on the resumed session")
+	}
+	var count int
+	if err := rc.Call(context.Background(), "CounterService.Incr", nil, &count); err != nil || count != 2 {
+		t.Errorf("The services of the session should be kept, got: %d %v", count, err)
+	}
+	mutex.Lock()
+	if handshakes != 1 {
+		t.Errorf("The handshake should not run on resumed sessions, got %d", handshakes)
+	}
+	mutex.Unlock()
+}
+
+func TestReconnectPolicy(t *testing.T) {
+	_, server, _ := startSessionServer(t, nil)
+	var tests = []struct {
+		policy   OfflinePolicy
+		expected error
+	}{
+		{FailCalls, ErrDisconnected},
+		{QueueCalls, context.DeadlineExceeded},
+	}
+	for _, test := range tests {
+		failures := 0
+		rc := (&WsJson{}).DialReconnecting(wsURL(server), ReconnectOptions{
+			Backoff: Backoff{Initial: 10 * time.Millisecond},
+			Policy:  test.policy,
+			Handshake: func(ctx context.Context, client *WsJsonClient) error {
+				if failures < 3 {
+					failures++
+					return errors.New("Not yet")
+				}
+				return nil
+			},
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		if err := rc.Call(ctx, "CounterService.Incr", nil, nil); err != test.expected {
+			t.Errorf("Policy %d: calls while connecting should fail with %v, got: %v", test.policy, test.expected, err)
+		}
+		cancel()
+
+		if err := rc.Call(context.Background(), "CounterService.Incr", nil, nil); test.policy == QueueCalls && err != nil {
+			t.Errorf("Policy %d: the call should wait for the handshake, got: %v", test.policy, err)
+		}
+		waitState(t, rc, StateConnected)
+		if failures != 3 {
+			t.Errorf("Policy %d: failed handshakes should be retried, got %d", test.policy, failures)
+		}
+		rc.Close()
+		if rc.State() != StateClosed {
+			t.Errorf("Policy %d: the client should be closed, got %v", test.policy, rc.State())
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	var tests = []struct {
+		backoff  Backoff
+		attempts int
+		max      time.Duration
+	}{
+		{Backoff{}, 1, 100 * time.Millisecond},
+		{Backoff{}, 3, 400 * time.Millisecond},
+		{Backoff{}, 100, 30 * time.Second},
+		{Backoff{Initial: time.Second, Max: 5 * time.Second, Multiplier: 3}, 2, 3 * time.Second},
+		{Backoff{Initial: time.Second, Max: 5 * time.Second, Multiplier: 3}, 3, 5 * time.Second},
+	}
+	for _, test := range tests {
+		for i := 0; i < 10; i++ {
+			if d := test.backoff.delay(test.attempts, rnd); d < test.max/2 || d > test.max {
+				t.Errorf("Delay of %+v after %d attempts should be between %v and %v, got %v",
+					test.backoff, test.attempts, test.max/2, test.max, d)
+			}
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package wsjson
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Direction of the frames recorded
+const (
+	DirectionIn  = "in"
+	DirectionOut = "out"
+)
+
+// Value of the redacted fields
+const Redacted = "[REDACTED]"
+
+var (
+	// Returned by the calls to the peer of the methods replayed
+	ErrReplayPeerCall = errors.New("Calls to the peer can't be replayed")
+)
+
+// A message received or sent by a connection, one line of a recording
+type Frame struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"dir"`
+	// Id of the connection, the same for all its frames
+	Conn string `json:"conn"`
+	// Message as JSON, whatever the codec of the connection
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Writes the frames of the connections as JSON lines
+type Recorder struct {
+	mutex  sync.Mutex
+	writer io.Writer
+	// names of the fields redacted at any depth, lower case
+	redact map[string]bool
+}
+
+// NewRecorder creates a recorder writing to w, one frame per line
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{writer: w, redact: make(map[string]bool)}
+}
+
+// Redact replaces the values of the fields with these names, case insensitive,
+// at any depth of the messages recorded, e.g. "password" or "token"
+func (r *Recorder) Redact(fields ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, field := range fields {
+		r.redact[strings.ToLower(field)] = true
+	}
+}
+
+// Record a frame of a connection, errors are logged as recording must not affect it
+func (r *Recorder) record(conn, direction string, codec Codec, data []byte) {
+	payload, err := toJSON(codec, data)
+	if err != nil {
+		log.Printf("Error recording frame: %v", err)
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.redact) > 0 {
+		if payload, err = r.redactPayload(payload); err != nil {
+			log.Printf("Error redacting frame: %v", err)
+			return
+		}
+	}
+	line, err := json.Marshal(&Frame{Time: time.Now().UTC(), Direction: direction, Conn: conn, Payload: payload})
+	if err != nil {
+		log.Printf("Error recording frame: %v", err)
+		return
+	}
+	if _, err := r.writer.Write(append(line, '\n')); err != nil {
+		log.Printf("Error recording frame: %v", err)
+	}
+}
+
+// Message of a codec as JSON, messages that are not valid are recorded as a string
+func toJSON(codec Codec, data []byte) (json.RawMessage, error) {
+	if codec.MessageType() == JSONCodec.MessageType() {
+		if json.Valid(data) {
+			return json.RawMessage(data), nil
+		}
+		return json.Marshal(string(data))
+	}
+	var message interface{}
+	if err := codec.Unmarshal(data, &message); err != nil {
+		return json.Marshal(data)
+	}
+	return json.Marshal(message)
+}
+
+func (r *Recorder) redactPayload(payload json.RawMessage) (json.RawMessage, error) {
+	var message interface{}
+	if err := json.Unmarshal(payload, &message); err != nil {
+		return nil, err
+	}
+	return json.Marshal(r.redactValue(message))
+}
+
+func (r *Recorder) redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if r.redact[strings.ToLower(key)] {
+				v[key] = Redacted
+			} else {
+				v[key] = r.redactValue(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = r.redactValue(item)
+		}
+	}
+	return value
+}
+
+// Set the recorder of all the frames of the connections, nil disables recording.
+// Must be called before serving connections
+func (wsj *WsJson) SetRecorder(recorder *Recorder) {
+	wsj.recorder = recorder
+}
+
+// Random id of a connection in the recordings
+func newConnId() string {
+	var id [8]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+func (wsjc *WsJsonClient) recordFrame(direction string, data []byte) {
+	if wsjc.recorder != nil {
+		wsjc.recorder.record(wsjc.connId, direction, wsjc.codec(), data)
+	}
+}
+
+// ReadFrames reads a recording, one frame per line
+func ReadFrames(r io.Reader) ([]Frame, error) {
+	var frames []Frame
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var frame Frame
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			return nil, fmt.Errorf("Invalid frame at line %d: %v", line, err)
+		}
+		frames = append(frames, frame)
+	}
+	return frames, scanner.Err()
+}
+
+// SplitSessions groups the frames by connection, in the order the connections appear
+func SplitSessions(frames []Frame) [][]Frame {
+	var sessions [][]Frame
+	index := make(map[string]int)
+	for _, frame := range frames {
+		i, ok := index[frame.Conn]
+		if !ok {
+			i = len(sessions)
+			index[frame.Conn] = i
+			sessions = append(sessions, nil)
+		}
+		sessions[i] = append(sessions[i], frame)
+	}
+	return sessions
+}
+
+// Envelope of a frame, to tell calls from responses
+type frameMessage struct {
+	Method string          `json:"method"`
+	Id     json.RawMessage `json:"id"`
+}
+
+func (m *frameMessage) hasId() bool {
+	return len(m.Id) > 0 && string(m.Id) != "null"
+}
+
+// MessageKey decodes the method of a message, empty for responses, and the key matching
+// the calls with their responses, empty when it has no id. False if it isn't a message
+func MessageKey(payload json.RawMessage) (method, key string, ok bool) {
+	var m frameMessage
+	if json.Unmarshal(payload, &m) != nil {
+		return "", "", false
+	}
+	if m.hasId() {
+		key = canonical(m.Id)
+	}
+	return m.Method, key, true
+}
+
+// IsCall reports whether a frame is a call or a notification, not a response
+func (f *Frame) IsCall() bool {
+	method, _, ok := MessageKey(f.Payload)
+	return ok && method != ""
+}
+
+// Response of a replayed call that differs from the recorded one
+type Mismatch struct {
+	Conn string
+	Id   json.RawMessage
+	// Nil when the response is missing
+	Expected json.RawMessage
+	Got      json.RawMessage
+}
+
+func (m *Mismatch) String() string {
+	return fmt.Sprintf("conn %s, id %s: expected %s, got %s", m.Conn, m.Id, orNone(m.Expected), orNone(m.Got))
+}
+
+func orNone(payload json.RawMessage) string {
+	if payload == nil {
+		return "no response"
+	}
+	return string(payload)
+}
+
+// CompareResponses compares the responses recorded for the calls received by a session
+// with the responses to the replay of its calls, matched by id
+func CompareResponses(session []Frame, replayed []json.RawMessage) []Mismatch {
+	if len(session) == 0 {
+		return nil
+	}
+	conn := session[0].Conn
+
+	// only the calls received in the session are replayed
+	expected := make(map[string]json.RawMessage)
+	var ids []json.RawMessage
+	for _, frame := range session {
+		var m frameMessage
+		if json.Unmarshal(frame.Payload, &m) != nil || !m.hasId() {
+			continue
+		}
+		key := canonical(m.Id)
+		if frame.Direction == DirectionIn && m.Method != "" {
+			if _, ok := expected[key]; !ok {
+				ids = append(ids, m.Id)
+				expected[key] = nil
+			}
+		} else if frame.Direction == DirectionOut && m.Method == "" {
+			if _, ok := expected[key]; ok {
+				expected[key] = frame.Payload
+			}
+		}
+	}
+
+	got := make(map[string]json.RawMessage)
+	for _, payload := range replayed {
+		var m frameMessage
+		if json.Unmarshal(payload, &m) == nil && m.Method == "" && m.hasId() {
+			got[canonical(m.Id)] = payload
+		}
+	}
+
+	var mismatches []Mismatch
+	for _, id := range ids {
+		key := canonical(id)
+		// calls without a recorded response, e.g. the connection was closed
+		if expected[key] == nil {
+			continue
+		}
+		if !equalJSON(expected[key], got[key]) {
+			mismatches = append(mismatches, Mismatch{Conn: conn, Id: id, Expected: expected[key], Got: got[key]})
+		}
+	}
+	return mismatches
+}
+
+func canonical(payload json.RawMessage) string {
+	var v interface{}
+	if json.Unmarshal(payload, &v) != nil {
+		return string(payload)
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func equalJSON(a, b json.RawMessage) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// Replay feeds the calls received by the recorded sessions to the services of wsj, in process,
+// and returns the responses that differ from the recorded ones. Each session gets the
+// global services and the ones given, as the ApiFactory needs a request.
+// The sessions run without a principal, the recordings don't keep it, so methods that
+// need one fail as unauthenticated. There is no peer to answer the calls of the methods,
+// they fail with ErrReplayPeerCall
+func (wsj *WsJson) Replay(frames []Frame, services ...interface{}) ([]Mismatch, error) {
+	var mismatches []Mismatch
+	for _, session := range SplitSessions(frames) {
+		replayed, err := wsj.replaySession(session, services)
+		if err != nil {
+			return nil, err
+		}
+		mismatches = append(mismatches, CompareResponses(session, replayed)...)
+	}
+	return mismatches, nil
+}
+
+func (wsj *WsJson) replaySession(session []Frame, services []interface{}) ([]json.RawMessage, error) {
+	client, err := newWsJsonClient(wsj.newServiceManager(), nil, services)
+	if err != nil {
+		return nil, err
+	}
+	defer client.close()
+	client.replaying = true
+
+	// messages sent by the methods, e.g. partial results, are discarded
+	go func() {
+		for {
+			select {
+			case <-client.output:
+			case <-client.done:
+				return
+			}
+		}
+	}()
+
+	var replayed []json.RawMessage
+	for _, frame := range session {
+		if frame.Direction != DirectionIn || !frame.IsCall() {
+			continue
+		}
+		response := client.handleMessage(bytes.NewReader(frame.Payload))
+		if response == nil {
+			continue
+		}
+		payload, err := json.Marshal(response)
+		if err != nil {
+			return nil, err
+		}
+		replayed = append(replayed, payload)
+	}
+	return replayed, nil
+}
@@ -0,0 +1,157 @@
+package wsjson
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type Credentials struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+type LoginService struct{}
+
+func (*LoginService) ApiLogin(c Credentials) (string, error) {
+	return "Welcome " + c.User, nil
+}
+
+// Buffer written by the connections and read by the test
+type lockedBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (lb *lockedBuffer) Write(p []byte) (int, error) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	return lb.buf.Write(p)
+}
+
+func (lb *lockedBuffer) String() string {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	return lb.buf.String()
+}
+
+// Record a session with an echo and a login, returns its frames
+func recordSession(t *testing.T, wsj *WsJson) []Frame {
+	var buf lockedBuffer
+	recorder := NewRecorder(&buf)
+	recorder.Redact("Password")
+	wsj.SetRecorder(recorder)
+	server := startServer(t, wsj)
+
+	conn, _, err := dialServer(server, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	roundTrip(t, conn, `{"jsonrpc": "2.0", "method": "SimpleService.Echo", "params": ["hi"], "id": 1}`)
+	roundTrip(t, conn, `{"jsonrpc": "2.0", "method": "LoginService.Login", "params": {"user": "ana", "password": "secret"}, "id": 2}`)
+
+	// frames sent are recorded once written
+	deadline := time.Now().Add(time.Second)
+	for strings.Count(buf.String(), "\n") < 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("4 frames should be recorded, got:\n%s", buf.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	frames, err := ReadFrames(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frames
+}
+
+func TestRecorder(t *testing.T) {
+	wsj := &WsJson{}
+	wsj.AddService(&SimpleService{})
+	wsj.AddService(&LoginService{})
+	frames := recordSession(t, wsj)
+
+	var table = []struct {
+		direction string
+		payload   string
+	}{
+		{DirectionIn, `{"jsonrpc": "2.0", "method": "SimpleService.Echo", "params": ["hi"], "id": 1}`},
+		{DirectionOut, `{"jsonrpc": "2.0", "result": "hi", "id": 1}`},
+		{DirectionIn, `{"jsonrpc": "2.0", "method": "LoginService.Login", "params": {"user": "ana", "password": "[REDACTED]"}, "id": 2}`},
+		{DirectionOut, `{"jsonrpc": "2.0", "result": "Welcome ana", "id": 2}`},
+	}
+
+	if len(frames) != len(table) {
+		t.Fatalf("Invalid frames recorded: %+v", frames)
+	}
+	for i, row := range table {
+		frame := frames[i]
+		if frame.Direction != row.direction || !equalJSON(frame.Payload, json.RawMessage(row.payload)) {
+			t.Errorf("Invalid frame %d, expected: %s %s, got: %s %s", i, row.direction, row.payload, frame.Direction, frame.Payload)
+		}
+		if frame.Conn == "" || frame.Conn != frames[0].Conn || frame.Time.IsZero() {
+			t.Errorf("Invalid connection or time of frame %d: %+v", i, frame)
+		}
+	}
+}
+
+// Calls its peer, which answers with the recorded greeting
+type GreeterService struct{}
+
+func (*GreeterService) ApiGreet(ctx context.Context) (string, error) {
+	var greeting string
+	err := ClientFromContext(ctx).Call(ctx, "Peer.Greeting", nil, &greeting)
+	return greeting, err
+}
+
+func TestReplay(t *testing.T) {
+	wsj := &WsJson{}
+	wsj.AddService(&SimpleService{})
+	wsj.AddService(&LoginService{})
+	frames := recordSession(t, wsj)
+
+	mismatches, err := wsj.Replay(frames)
+	if err != nil || len(mismatches) != 0 {
+		t.Errorf("Replay of the same services should match: %v %v", err, mismatches)
+	}
+
+	// a recorded response that changed
+	frames[1].Payload = json.RawMessage(`{"jsonrpc": "2.0", "result": "hello", "id": 1}`)
+	mismatches, err = wsj.Replay(frames)
+	if err != nil || len(mismatches) != 1 || string(mismatches[0].Id) != "1" || !equalJSON(mismatches[0].Got, json.RawMessage(`{"jsonrpc": "2.0", "result": "hi", "id": 1}`)) {
+		t.Errorf("Replay should find the changed response: %v %v", err, mismatches)
+	}
+
+	// only the global services are available
+	other := &WsJson{}
+	other.AddService(&SimpleService{})
+	mismatches, err = other.Replay(frames)
+	if err != nil || len(mismatches) != 2 {
+		t.Errorf("Replay without the login service should fail the login: %v %v", err, mismatches)
+	}
+
+	// calls to the peer fail, there is no peer
+	frames = []Frame{
+		{Direction: DirectionIn, Conn: "c1", Payload: json.RawMessage(`{"jsonrpc": "2.0", "method": "GreeterService.Greet", "id": 1}`)},
+		{Direction: DirectionOut, Conn: "c1", Payload: json.RawMessage(`{"jsonrpc": "2.0", "result": "hello", "id": 1}`)},
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mismatches, err = wsj.Replay(frames, &GreeterService{})
+	}()
+	select {
+	case <-done:
+		if err != nil || len(mismatches) != 1 || !strings.Contains(string(mismatches[0].Got), ErrReplayPeerCall.Error()) {
+			t.Errorf("Calls to the peer should fail: %v %v", err, mismatches)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Replay of calls to the peer should not block")
+	}
+}
@@ -0,0 +1,50 @@
+package wsjson
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Cache of the exposed methods of each service type, the reflection needed to
+// find them is done once per type instead of once per registered instance
+type registry struct {
+	mutex sync.RWMutex
+	types map[reflect.Type]map[string]*serviceMethod
+}
+
+func newRegistry() *registry {
+	return &registry{
+		types: make(map[reflect.Type]map[string]*serviceMethod),
+	}
+}
+
+// Get the exposed methods for the type of an instance, the returned map must not be modified
+func (r *registry) methods(instance interface{}, mapName NameMapper) (map[string]*serviceMethod, error) {
+	servType := reflect.TypeOf(instance)
+
+	r.mutex.RLock()
+	methods, ok := r.types[servType]
+	r.mutex.RUnlock()
+	if ok {
+		return methods, nil
+	}
+
+	var err error
+	if methProv, ok := instance.(MethodsProvider); ok {
+		methods, err = methodsFromProvider(methProv)
+	} else {
+		methods, err = methodsByPrefix(instance, mapName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	// another connection may have added it already
+	if prev, ok := r.types[servType]; ok {
+		return prev, nil
+	}
+	r.types[servType] = methods
+	return methods, nil
+}
@@ -1,9 +1,10 @@
 package wsjson
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 )
@@ -11,92 +12,143 @@ import (
 const (
 	// Default prefix for exposed methods of registered services
 	defMethodPrefix = "Api"
+
+	// Default separator between service and method names
+	defMethodSeparator = "."
 )
 
 var (
-	typeOfError = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
 )
 
 type NameProvider interface {
 	WsName() string
 }
 
-// Services must implement this interface to use a different prefix for exposed methods
+// Services must implement this interface to use a different prefix for exposed methods,
+// all the instances of a type must use the same prefix
 type PrefixProvider interface {
 	WsPrefix() string
 }
 
-// Services must implement this interface to explicitely define the exposed methods
+// Services must implement this interface to explicitely define the exposed methods,
+// all the instances of a type must expose the same methods
 type MethodsProvider interface {
 	WsMethods() map[string]string
 }
 
-// A single service
+// Services must implement this interface to register nested services,
+// the methods of a sub-service are named <ServiceName>.<SubServiceName>.<MethodName>
+type SubServicesProvider interface {
+	WsServices() []interface{}
+}
+
+// A single service, an instance bound to the methods of its type
 type service struct {
 	instance interface{}
 	name     string
-	servType reflect.Type
 	value    reflect.Value
 	methods  map[string]*serviceMethod
+	// roles required by method, may be nil
+	roles map[string][]string
+}
+
+// A method that can be called by the peer
+type methodHandler interface {
+	call(ctx context.Context, codec Codec, params RawMessage) (interface{}, error)
 }
 
-// An exposed service method
+// An exposed service method, shared by all the instances of a type
 type serviceMethod struct {
-	service    *service
 	method     *reflect.Method
 	argTypes   []reflect.Type
 	isEvent    bool
 	returnType reflect.Type
+	// the first argument is a context.Context
+	hasContext bool
+	// a *Stream is received after the context
+	hasStream bool
+	// returns a channel of partial results
+	returnsChan bool
 }
 
 type serviceManager struct {
 	services map[string]*service
+	// methods registered by their full name
+	handlers map[string]methodHandler
 	mutex    sync.RWMutex
+	// methods not found are looked up in the parent, may be nil
+	parent *serviceManager
+	// cache of the methods of each type
+	registry *registry
+	// decides which methods can be called, may be nil
+	policy AccessPolicy
+
+	// separator between service and method names
+	separator string
+	// maps the names of methods found by prefix, may be nil
+	mapName NameMapper
 }
 
-// Add all methods form the instance whose name starts with a prefix
-func (serv *service) addMethodsByPrefix() error {
+// A service method bound to a receiver
+type boundMethod struct {
+	*serviceMethod
+	receiver reflect.Value
+	// roles required to call the method, nil if there are no restrictions
+	roles []string
+}
+
+// Find all methods of the instance whose name starts with a prefix
+func methodsByPrefix(instance interface{}, mapName NameMapper) (map[string]*serviceMethod, error) {
 	prefix := defMethodPrefix
-	prefProv, ok := serv.instance.(PrefixProvider)
+	prefProv, ok := instance.(PrefixProvider)
 	if ok {
 		prefix = prefProv.WsPrefix()
 	}
 
-	for i := 0; i < serv.servType.NumMethod(); i++ {
-		method := serv.servType.Method(i)
+	methods := make(map[string]*serviceMethod)
+	servType := reflect.TypeOf(instance)
+	for i := 0; i < servType.NumMethod(); i++ {
+		method := servType.Method(i)
 		if !strings.HasPrefix(method.Name, prefix) {
 			continue
 		}
 
-		servMethod, err := newServiceMethod(serv, &method)
+		servMethod, err := newServiceMethod(&method)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		name := strings.TrimPrefix(method.Name, prefix)
-		serv.methods[name] = servMethod
+		if mapName != nil {
+			name = mapName(name)
+		}
+		methods[name] = servMethod
 	}
-	return nil
+	return methods, nil
 }
 
-// Add methods using a provider
-func (serv *service) addMethodsFromProvider(provider MethodsProvider) error {
+// Find the methods using a provider
+func methodsFromProvider(provider MethodsProvider) (map[string]*serviceMethod, error) {
+	methods := make(map[string]*serviceMethod)
+	servType := reflect.TypeOf(provider)
 	for name, methodName := range provider.WsMethods() {
-		method, ok := serv.servType.MethodByName(methodName)
+		method, ok := servType.MethodByName(methodName)
 		if !ok {
-			return fmt.Errorf("WSMethods(): %s is not a method of %v", methodName, serv.servType)
+			return nil, fmt.Errorf("WSMethods(): %s is not a method of %v", methodName, servType)
 		}
 
-		servMethod, err := newServiceMethod(serv, &method)
+		servMethod, err := newServiceMethod(&method)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		serv.methods[name] = servMethod
+		methods[name] = servMethod
 	}
-	return nil
+	return methods, nil
 }
 
 // New serviceMethod instance
-func newServiceMethod(serv *service, method *reflect.Method) (*serviceMethod, error) {
+func newServiceMethod(method *reflect.Method) (*serviceMethod, error) {
 	methodType := method.Type
 
 	// method must be exported
@@ -129,24 +181,47 @@ func newServiceMethod(serv *service, method *reflect.Method) (*serviceMethod, er
 		}
 	}
 
+	// a context may be received as the first argument
+	firstArg := 1
+	hasContext := methodType.NumIn() > 1 && methodType.In(1) == typeOfContext
+	if hasContext {
+		firstArg = 2
+	}
+	hasStream := methodType.NumIn() > firstArg && methodType.In(firstArg) == typeOfStream
+	if hasStream {
+		firstArg++
+	}
+
 	sm := &serviceMethod{
-		service:    serv,
-		method:     method,
-		isEvent:    isEvent,
-		argTypes:   make([]reflect.Type, methodType.NumIn()-1),
-		returnType: returnType,
+		method:      method,
+		isEvent:     isEvent,
+		argTypes:    make([]reflect.Type, methodType.NumIn()-firstArg),
+		returnType:  returnType,
+		hasContext:  hasContext,
+		hasStream:   hasStream,
+		returnsChan: returnType != nil && returnType.Kind() == reflect.Chan && returnType.ChanDir()&reflect.RecvDir != 0,
 	}
-	for j := 1; j < methodType.NumIn(); j++ {
-		sm.argTypes[j-1] = methodType.In(j)
+	for j := firstArg; j < methodType.NumIn(); j++ {
+		sm.argTypes[j-firstArg] = methodType.In(j)
 	}
 	return sm, nil
 }
 
-// Decode json params according to the method signature using reflection
-func (am *serviceMethod) decodeParams(params json.RawMessage) ([]reflect.Value, error) {
+// Decode params according to the method signature using reflection,
+// the returned values include the receiver, the context and the stream if needed
+func (am *serviceMethod) decodeParams(receiver reflect.Value, ctx context.Context, stream *Stream, codec Codec, params RawMessage) ([]reflect.Value, error) {
+	fixed := []reflect.Value{receiver}
+	if am.hasContext {
+		fixed = append(fixed, reflect.ValueOf(ctx))
+	}
+	if am.hasStream {
+		fixed = append(fixed, reflect.ValueOf(stream))
+	}
+
 	typesLen := len(am.argTypes)
-	paramValues := make([]reflect.Value, typesLen+1)
-	paramValues[0] = am.service.value
+	offset := len(fixed)
+	paramValues := make([]reflect.Value, typesLen+offset)
+	copy(paramValues, fixed)
 
 	// If method has only one parameter and it is an struct then params must be send as an service
 	if typesLen == 1 {
@@ -158,7 +233,7 @@ func (am *serviceMethod) decodeParams(params json.RawMessage) ([]reflect.Value,
 		}
 		if firstType.Kind() == reflect.Struct {
 			value := reflect.New(firstType)
-			err := json.Unmarshal(params, value.Interface())
+			err := codec.Unmarshal(params, value.Interface())
 			if err != nil {
 				//log.Printf("Error decoding parameters, params: %q, type: %#v, %v", params, firstType.Name(), err)
 				return nil, NewError(ErrorInvalidParams, "Params must be an object")
@@ -167,14 +242,14 @@ func (am *serviceMethod) decodeParams(params json.RawMessage) ([]reflect.Value,
 				value = value.Elem()
 			}
 
-			paramValues[1] = value
+			paramValues[offset] = value
 			return paramValues, nil
 		}
 	}
 
-	var paramsArray []json.RawMessage
+	var paramsArray []RawMessage
 	if len(params) > 0 { // params may be ommited
-		err := json.Unmarshal(params, &paramsArray)
+		err := codec.Unmarshal(params, &paramsArray)
 		if err != nil {
 			return nil, NewError(ErrorInvalidParams, "Params must be an array, %v, %v", err, params)
 		}
@@ -191,23 +266,71 @@ func (am *serviceMethod) decodeParams(params json.RawMessage) ([]reflect.Value,
 
 	for i, par := range paramsArray {
 		value := reflect.New(am.argTypes[i])
-		err := json.Unmarshal(par, value.Interface())
+		err := codec.Unmarshal(par, value.Interface())
 		if err != nil {
 			return nil, NewError(
 				ErrorInvalidParams, "Unable to decode parameter %d: %v",
 				i, err.Error(),
 			)
 		}
-		paramValues[i+1] = value.Elem()
+		paramValues[i+offset] = value.Elem()
 	}
 
 	return paramValues, nil
 
 }
 
+// Call the method on its receiver with the encoded params
+func (bm boundMethod) call(ctx context.Context, codec Codec, params RawMessage) (interface{}, error) {
+	am := bm.serviceMethod
+	var stream *Stream
+	var err error
+	if am.hasStream || am.returnsChan {
+		if stream, err = newStream(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	paramValues, err := am.decodeParams(bm.receiver, ctx, stream, codec, params)
+	if err != nil {
+		return nil, err
+	}
+	response := am.method.Func.Call(paramValues)
+
+	if am.isEvent {
+		//Events have no return values
+		return nil, nil
+	}
+
+	if len(response) != 2 {
+		return nil, fmt.Errorf("Response should had 2 values, got: %#v", response)
+	}
+
+	if response[1].IsNil() {
+		err = nil
+	} else {
+		e, ok := response[1].Interface().(error)
+		if !ok {
+			return nil, fmt.Errorf("Last parameter should have been an error, got: %#v, %t", response, response[1].Type().Kind() == reflect.Interface)
+		}
+		err = e
+	}
+
+	if am.returnsChan && err == nil {
+		if response[0].IsNil() {
+			return &StreamResult{}, nil
+		}
+		return stream.drain(response[0])
+	}
+	return response[0].Interface(), err
+}
+
 func newServiceManager() *serviceManager {
 	m := &serviceManager{
-		services: make(map[string]*service),
+		services:  make(map[string]*service),
+		handlers:  make(map[string]methodHandler),
+		separator: defMethodSeparator,
+		registry:  newRegistry(),
 	}
 	return m
 }
@@ -217,6 +340,14 @@ func (m *serviceManager) numServices() int {
 	return len(m.services)
 }
 
+// Whether there is any method available, including the ones of the parent
+func (m *serviceManager) hasMethods() bool {
+	m.mutex.RLock()
+	n := len(m.services) + len(m.handlers)
+	m.mutex.RUnlock()
+	return n > 0 || (m.parent != nil && m.parent.hasMethods())
+}
+
 // Return the total number of methods registered
 func (m *serviceManager) numMethods() int {
 	var c int
@@ -226,19 +357,17 @@ func (m *serviceManager) numMethods() int {
 	return c
 }
 
-// Register an Service to serve requests
-// the resulting service methods will have "name." as prefix
-func (m *serviceManager) addService(instance interface{}) error {
+// Create the services for an instance and its sub-services,
+// the names of the services are prefixed with the name of its parent
+func (m *serviceManager) newServices(parent string, instance interface{}) ([]*service, error) {
 	if instance == nil {
-		return fmt.Errorf("Attempt to add nil service instance")
+		return nil, fmt.Errorf("Attempt to add nil service instance")
 	}
 
 	//log.Printf("Adding Service: %#v", instance)
 	serv := &service{
 		instance: instance,
-		servType: reflect.TypeOf(instance),
 		value:    reflect.ValueOf(instance),
-		methods:  make(map[string]*serviceMethod),
 	}
 
 	nameProv, ok := instance.(NameProvider)
@@ -251,44 +380,194 @@ func (m *serviceManager) addService(instance interface{}) error {
 	}
 
 	if serv.name == "" {
-		return fmt.Errorf("Unable to get a name for: %T", instance)
+		return nil, fmt.Errorf("Unable to get a name for: %T", instance)
 	}
 
-	methProv, ok := instance.(MethodsProvider)
-	var err error
-	if ok {
-		err = serv.addMethodsFromProvider(methProv)
-	} else {
-		err = serv.addMethodsByPrefix()
+	if strings.Contains(serv.name, m.separator) {
+		return nil, fmt.Errorf("Service name %q can't contain the separator %q", serv.name, m.separator)
 	}
 
+	if parent != "" {
+		serv.name = parent + m.separator + serv.name
+	}
+
+	methods, err := m.registry.methods(instance, m.mapName)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	for name := range methods {
+		if strings.Contains(name, m.separator) {
+			return nil, fmt.Errorf("Method name %q of %s can't contain the separator %q", name, serv.name, m.separator)
+		}
 	}
+	serv.methods = methods
 
-	if len(serv.methods) == 0 {
-		return fmt.Errorf("No exposed methods found for %#v", instance)
+	if rolesProv, ok := instance.(MethodRolesProvider); ok {
+		serv.roles = rolesProv.WsRoles()
+	}
+
+	services := []*service{serv}
+	if subProv, ok := instance.(SubServicesProvider); ok {
+		for _, sub := range subProv.WsServices() {
+			subServices, err := m.newServices(serv.name, sub)
+			if err != nil {
+				return nil, err
+			}
+			services = append(services, subServices...)
+		}
+	}
+
+	if len(serv.methods) == 0 && len(services) == 1 {
+		return nil, fmt.Errorf("No exposed methods found for %#v", instance)
+	}
+
+	return services, nil
+}
+
+// Register an Service to serve requests
+// the resulting service methods will have "name." as prefix
+func (m *serviceManager) addService(instance interface{}) error {
+	_, err := m.addServices(instance)
+	return err
+}
+
+// Register a service and its sub-services, returns the services added
+func (m *serviceManager) addServices(instance interface{}) ([]*service, error) {
+	services, err := m.newServices("", instance)
+	if err != nil {
+		return nil, err
 	}
 
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
-	m.services[serv.name] = serv
-	return nil
+	for _, serv := range services {
+		if _, ok := m.services[serv.name]; ok {
+			return nil, fmt.Errorf("Service %s is already registered", serv.name)
+		}
+	}
+	for _, serv := range services {
+		m.services[serv.name] = serv
+	}
+	return services, nil
 }
 
-// Get a method by name using notation <ServiceName>.<MethodName>
-func (m *serviceManager) getMethod(name string) (*serviceMethod, error) {
-	nameParts := strings.Split(name, ".")
-	if len(nameParts) != 2 {
-		return nil, NewError(ErrorMethodNotFound, "Invalid method name: %s", name)
+// Remove a service and its sub-services, returns the services removed
+func (m *serviceManager) removeService(name string) ([]*service, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.services[name]; !ok {
+		return nil, fmt.Errorf("Service %s is not registered", name)
 	}
 
-	servName := nameParts[0]
-	methodName := nameParts[1]
+	var removed []*service
+	for servName, serv := range m.services {
+		if servName == name || strings.HasPrefix(servName, name+m.separator) {
+			removed = append(removed, serv)
+			delete(m.services, servName)
+		}
+	}
+	return removed, nil
+}
+
+// Full names of the methods of some services the principal can call, sorted
+func (m *serviceManager) serviceMethodNames(services []*service, principal Principal) []string {
+	var names []string
+	for _, serv := range services {
+		for methodName := range serv.methods {
+			name := serv.name + m.separator + methodName
+			if m.allowed(principal, name, serv.methodRoles(methodName)) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
 
+// Full names of all the methods the principal can call, including the ones of the parent
+func (m *serviceManager) methodNames(principal Principal) []string {
 	m.mutex.RLock()
-	api, ok := m.services[servName]
+	services := make([]*service, 0, len(m.services))
+	for _, serv := range m.services {
+		services = append(services, serv)
+	}
+	names := m.serviceMethodNames(services, principal)
+	for name := range m.handlers {
+		if m.allowed(principal, name, nil) {
+			names = append(names, name)
+		}
+	}
 	m.mutex.RUnlock()
+
+	if m.parent != nil {
+		names = append(names, m.parent.methodNames(principal)...)
+	}
+
+	// remove duplicates, methods may be defined in the parent too
+	sort.Strings(names)
+	unique := names[:0]
+	for i, name := range names {
+		if i == 0 || name != names[i-1] {
+			unique = append(unique, name)
+		}
+	}
+	return unique
+}
+
+// Register a method by its full name
+func (m *serviceManager) addHandler(name string, handler methodHandler) error {
+	if name == "" {
+		return fmt.Errorf("Method name can't be empty")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.handlers[name]; ok {
+		return fmt.Errorf("Method %s is already registered", name)
+	}
+	m.handlers[name] = handler
+	return nil
+}
+
+// Get a method registered by its full name
+func (m *serviceManager) getHandler(name string) methodHandler {
+	m.mutex.RLock()
+	handler, ok := m.handlers[name]
+	m.mutex.RUnlock()
+	if !ok && m.parent != nil {
+		return m.parent.getHandler(name)
+	}
+	return handler
+}
+
+// Get a service by its full name
+func (m *serviceManager) getService(name string) (*service, bool) {
+	m.mutex.RLock()
+	serv, ok := m.services[name]
+	m.mutex.RUnlock()
+	if !ok && m.parent != nil {
+		return m.parent.getService(name)
+	}
+	return serv, ok
+}
+
+// Get a method by name using notation <ServiceName>.<MethodName>,
+// the service name may contain the separator when it is a sub-service.
+// Methods registered by their full name take precedence over services
+func (m *serviceManager) getMethod(name string) (methodHandler, error) {
+	if handler := m.getHandler(name); handler != nil {
+		return handler, nil
+	}
+
+	idx := strings.LastIndex(name, m.separator)
+	if idx <= 0 || idx+len(m.separator) == len(name) {
+		return nil, NewError(ErrorMethodNotFound, "Invalid method name: %s", name)
+	}
+
+	servName := name[:idx]
+	methodName := name[idx+len(m.separator):]
+
+	api, ok := m.getService(servName)
 	if !ok {
 		return nil, NewError(ErrorMethodNotFound, "API not found: %s", servName)
 	}
@@ -298,40 +577,25 @@ func (m *serviceManager) getMethod(name string) (*serviceMethod, error) {
 		return nil, NewError(ErrorMethodNotFound, "API %s doesn't have the %s method", servName, methodName)
 	}
 
-	return method, nil
+	return boundMethod{method, api.value, api.methodRoles(methodName)}, nil
+}
+
+// Whether a method is registered with the name
+func (m *serviceManager) hasMethod(name string) bool {
+	_, err := m.getMethod(name)
+	return err == nil
 }
 
 // Call an exposed service method
-func (m *serviceManager) callMethod(name string, params json.RawMessage) (interface{}, error) {
+func (m *serviceManager) callMethod(ctx context.Context, codec Codec, name string, params RawMessage) (interface{}, error) {
 	method, err := m.getMethod(name)
 	if err != nil {
 		return nil, err
 	}
 
-	paramValues, err := method.decodeParams(params)
-	if err != nil {
+	if err = m.authorize(ctx, name, method); err != nil {
 		return nil, err
 	}
-	response := method.method.Func.Call(paramValues)
 
-	if method.isEvent {
-		//Events have no return values
-		return nil, nil
-	}
-
-	if len(response) != 2 {
-		return nil, fmt.Errorf("Response should had 2 values, got: %#v", response)
-	}
-
-	if response[1].IsNil() {
-		err = nil
-	} else {
-		e, ok := response[1].Interface().(error)
-		if !ok {
-			return nil, fmt.Errorf("Last parameter should have been an error, got: %#v, %t", response, response[1].Type().Kind() == reflect.Interface)
-		}
-		err = e
-	}
-
-	return response[0].Interface(), err
+	return method.call(ctx, codec, params)
 }
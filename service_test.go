@@ -0,0 +1,403 @@
+package wsjson
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type UsersService struct{}
+
+func (*UsersService) WsName() string {
+	return "users"
+}
+
+func (*UsersService) ApiGetUser(id int) (string, error) {
+	return "user" + strings.Repeat("!", id), nil
+}
+
+func (*UsersService) ApiHTTPStatus() (int, error) {
+	return 200, nil
+}
+
+// Namespace without methods of its own
+type AdminService struct{}
+
+func (*AdminService) WsName() string {
+	return "admin"
+}
+
+func (*AdminService) WsServices() []interface{} {
+	return []interface{}{&UsersService{}}
+}
+
+func TestNameMappers(t *testing.T) {
+	var table = []struct {
+		name, camel, snake string
+	}{
+		{"GetUser", "getUser", "get_user"},
+		{"GetUserID", "getUserID", "get_user_id"},
+		{"HTTPStatus", "httpStatus", "http_status"},
+		{"ID", "id", "id"},
+		{"Fields2Obj", "fields2Obj", "fields2_obj"},
+		{"already_snake", "already_snake", "already_snake"},
+		{"", "", ""},
+	}
+
+	for _, row := range table {
+		if camel := LowerCamelCase(row.name); camel != row.camel {
+			t.Errorf("Invalid lowerCamelCase for %s, expected: %s, got: %s", row.name, row.camel, camel)
+		}
+		if snake := SnakeCase(row.name); snake != row.snake {
+			t.Errorf("Invalid snake_case for %s, expected: %s, got: %s", row.name, row.snake, snake)
+		}
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	wsj := &WsJson{}
+	wsj.SetMethodSeparator("/")
+	wsj.SetNameMapper(LowerCamelCase)
+	client, err := newWsJsonClient(wsj.newServiceManager(), nil, []interface{}{&AdminService{}, &SimpleService{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if client.manager.numServices() != 3 {
+		t.Errorf("Should had 3 services registered, found: %d", client.manager.numServices())
+	}
+
+	var table = []struct {
+		msg     string
+		errCode int
+		errMsg  string
+		result  interface{}
+	}{
+		{`{"jsonrpc": "2.0", "method": "admin/users/getUser", "params": [2], "id": 1}`, 0, "", "user!!"},
+		{`{"jsonrpc": "2.0", "method": "admin/users/httpStatus", "id": 1}`, 0, "", 200},
+		{`{"jsonrpc": "2.0", "method": "SimpleService/echo", "params": ["hi"], "id": 1}`, 0, "", "hi"},
+		{`{"jsonrpc": "2.0", "method": "admin/users/GetUser", "params": [2], "id": 1}`,
+			ErrorMethodNotFound, "API admin/users doesn't have the GetUser method", nil},
+		{`{"jsonrpc": "2.0", "method": "admin.users.getUser", "params": [2], "id": 1}`,
+			ErrorMethodNotFound, "Invalid method name", nil},
+		{`{"jsonrpc": "2.0", "method": "admin/getUser", "params": [2], "id": 1}`,
+			ErrorMethodNotFound, "API admin doesn't have the getUser method", nil},
+		{`{"jsonrpc": "2.0", "method": "users/getUser", "params": [2], "id": 1}`,
+			ErrorMethodNotFound, "API not found: users", nil},
+		{`{"jsonrpc": "2.0", "method": "admin/users/", "params": [2], "id": 1}`,
+			ErrorMethodNotFound, "Invalid method name", nil},
+	}
+
+	for _, tc := range table {
+		resp := client.handleMessage(strings.NewReader(tc.msg))
+		if resp == nil {
+			t.Errorf("A response was expected for '%s'", tc.msg)
+			continue
+		}
+
+		if tc.errMsg == "" {
+			if resp.Err != nil {
+				t.Errorf("No error was expected for '%s', got: %#v", tc.msg, resp.Err)
+			} else if resp.Result != tc.result {
+				t.Errorf("Invalid result for '%s', expected: %#v, got: %#v", tc.msg, tc.result, resp.Result)
+			}
+			continue
+		}
+
+		if resp.Err == nil || resp.Err.Code != tc.errCode || !strings.Contains(resp.Err.Message, tc.errMsg) {
+			t.Errorf("Invalid error for '%s', expected: %d '%s', got: %#v", tc.msg, tc.errCode, tc.errMsg, resp.Err)
+		}
+	}
+}
+
+type DottedService struct{}
+
+func (*DottedService) WsName() string {
+	return "dotted.name"
+}
+
+func (*DottedService) ApiNoop() {
+}
+
+type DottedMethods struct{}
+
+func (*DottedMethods) WsMethods() map[string]string {
+	return map[string]string{"get.user": "GetUser"}
+}
+
+func (*DottedMethods) GetUser() (string, error) {
+	return "ana", nil
+}
+
+func TestNamespaceValidations(t *testing.T) {
+	var table = []struct {
+		service interface{}
+		error   string
+	}{
+		{&DottedService{}, `Service name "dotted.name" can't contain the separator`},
+		{&DottedMethods{}, `Method name "get.user" of DottedMethods can't contain the separator`},
+	}
+	for _, row := range table {
+		_, err := newWsJsonClient(newServiceManager(), nil, []interface{}{row.service})
+		if err == nil || !strings.Contains(err.Error(), row.error) {
+			t.Errorf("Invalid error for a name with the separator, expected: %s, got: %v", row.error, err)
+		}
+	}
+}
+
+type AddParams struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+type ctxKey struct{}
+
+type ContextService struct{}
+
+func (*ContextService) ApiWho(ctx context.Context, greeting string) (string, error) {
+	return fmt.Sprintf("%s %v", greeting, ctx.Value(ctxKey{})), nil
+}
+
+func TestRegister(t *testing.T) {
+	wsj := &WsJson{}
+	err := Register(wsj, "math.add", func(ctx context.Context, p AddParams) (int, error) {
+		return p.A + p.B, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Register(wsj, "math.sum", func(ctx context.Context, p []int) (int, error) {
+		s := 0
+		for _, n := range p {
+			s += n
+		}
+		return s, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// takes precedence over the Echo method of SimpleService
+	err = Register(wsj, "SimpleService.Echo", func(ctx context.Context, p []string) (string, error) {
+		return "registered", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// calls are answered with null results
+	err = Register(wsj, "math.none", func(ctx context.Context, p []int) (fmt.Stringer, error) {
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lastEvent string
+	err = RegisterEvent(wsj, "math.log", func(ctx context.Context, p string) {
+		lastEvent = p
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Register(wsj, "math.add", func(ctx context.Context, p AddParams) (int, error) {
+		return 0, nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "Method math.add is already registered") {
+		t.Errorf("Invalid error registering a method twice: %v", err)
+	}
+
+	client, err := newWsJsonClient(wsj.newServiceManager(), nil, []interface{}{&SimpleService{}, &ContextService{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.ctx = context.WithValue(client.ctx, ctxKey{}, "Ringo")
+
+	var table = []struct {
+		msg     string
+		errCode int
+		errMsg  string
+		result  interface{}
+	}{
+		{`{"jsonrpc": "2.0", "method": "math.add", "params": {"a": 40, "b": 2}, "id": 1}`, 0, "", 42},
+		{`{"jsonrpc": "2.0", "method": "math.add", "id": 1}`, 0, "", 0},
+		{`{"jsonrpc": "2.0", "method": "math.sum", "params": [1, 2, 3], "id": 1}`, 0, "", 6},
+		{`{"jsonrpc": "2.0", "method": "math.sum", "params": {"a": 1}, "id": 1}`,
+			ErrorInvalidParams, "Unable to decode params", nil},
+		{`{"jsonrpc": "2.0", "method": "math.mul", "params": [1, 2, 3], "id": 1}`,
+			ErrorMethodNotFound, "API not found: math", nil},
+		{`{"jsonrpc": "2.0", "method": "SimpleService.Echo", "params": ["hi"], "id": 1}`, 0, "", "registered"},
+		{`{"jsonrpc": "2.0", "method": "SimpleService.AnArray", "params": [["a"]], "id": 1}`, 0, "", 1},
+		{`{"jsonrpc": "2.0", "method": "ContextService.Who", "params": ["Hi"], "id": 1}`, 0, "", "Hi Ringo"},
+		{`{"jsonrpc": "2.0", "method": "math.none", "id": 1}`, 0, "", nil},
+		{`{"jsonrpc": "2.0", "method": "math.log", "params": "called", "id": 1}`, 0, "", nil},
+	}
+
+	for _, tc := range table {
+		resp := client.handleMessage(strings.NewReader(tc.msg))
+		if resp == nil {
+			t.Errorf("A response was expected for '%s'", tc.msg)
+			continue
+		}
+
+		if tc.errMsg == "" {
+			if resp.Err != nil {
+				t.Errorf("No error was expected for '%s', got: %#v", tc.msg, resp.Err)
+			} else if resp.Result != tc.result {
+				t.Errorf("Invalid result for '%s', expected: %#v, got: %#v", tc.msg, tc.result, resp.Result)
+			}
+			continue
+		}
+
+		if resp.Err == nil || resp.Err.Code != tc.errCode || !strings.Contains(resp.Err.Message, tc.errMsg) {
+			t.Errorf("Invalid error for '%s', expected: %d '%s', got: %#v", tc.msg, tc.errCode, tc.errMsg, resp.Err)
+		}
+	}
+
+	resp := client.handleMessage(strings.NewReader(`{"jsonrpc": "2.0", "method": "math.log", "params": "logged"}`))
+	if resp != nil {
+		t.Errorf("No response expected for an event, got: %+v", resp)
+	}
+	if lastEvent != "logged" {
+		t.Errorf("Event function should have been called, last event: %s", lastEvent)
+	}
+}
+
+func TestSharedRegistry(t *testing.T) {
+	wsj := &WsJson{}
+	if err := wsj.AddService(&UsersService{}); err != nil {
+		t.Fatal(err)
+	}
+
+	services1 := &SimpleService{}
+	services2 := &SimpleService{}
+	client1, err := newWsJsonClient(wsj.newServiceManager(), nil, []interface{}{services1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client2, err := newWsJsonClient(wsj.newServiceManager(), nil, []interface{}{services2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// method metadata is shared, receivers are not
+	serv1, _ := client1.manager.getService("SimpleService")
+	serv2, _ := client2.manager.getService("SimpleService")
+	if serv1.methods["Echo"] != serv2.methods["Echo"] {
+		t.Error("Methods of the same type should be shared between connections")
+	}
+
+	client1.handleMessage(strings.NewReader(`{"jsonrpc": "2.0", "method": "SimpleService.Event", "params": ["one"]}`))
+	client2.handleMessage(strings.NewReader(`{"jsonrpc": "2.0", "method": "SimpleService.Event", "params": ["two"]}`))
+	if services1.lastEvent != "one" || services2.lastEvent != "two" {
+		t.Errorf("Events should be received by the service of each connection: %q, %q",
+			services1.lastEvent, services2.lastEvent)
+	}
+
+	// global services are available without connection services
+	client3, err := newWsJsonClient(wsj.newServiceManager(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, client := range []*WsJsonClient{client1, client3} {
+		resp := client.handleMessage(strings.NewReader(`{"jsonrpc": "2.0", "method": "users.GetUser", "params": [1], "id": 1}`))
+		if resp == nil || resp.Err != nil || resp.Result != "user!" {
+			t.Errorf("Invalid response for a global service: %+v", resp)
+		}
+	}
+}
+
+func BenchmarkNewClient(b *testing.B) {
+	wsj := &WsJson{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		services := []interface{}{&SimpleService{}, &NamedPrefixService{}, &MethodProviderService{}}
+		if _, err := newWsJsonClient(wsj.newServiceManager(), nil, services); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDynamicServices(t *testing.T) {
+	wsj := &WsJson{}
+	err := Register(wsj, "auth.login", func(ctx context.Context, password string) (bool, error) {
+		if password != "secret" {
+			return false, nil
+		}
+		return true, ClientFromContext(ctx).AddService(&AdminService{})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := newWsJsonClient(wsj.newServiceManager(), nil, []interface{}{&ContextService{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(msg string) *Response {
+		resp := client.handleMessage(strings.NewReader(msg))
+		if resp == nil {
+			t.Fatalf("A response was expected for '%s'", msg)
+		}
+		return resp
+	}
+
+	expectChange := func(expected string) {
+		select {
+		case out := <-client.output:
+			rq, ok := out.(*Request)
+			if !ok || rq.Method != EventMethodsChanged || string(rq.Params) != expected {
+				t.Errorf("Invalid methods changed notification, expected: %s, got: %s", expected, rq)
+			}
+		default:
+			t.Errorf("A methods changed notification was expected: %s", expected)
+		}
+	}
+
+	expected := []string{"ContextService.Who", "auth.login", "rpc.authenticate", "rpc.methods"}
+	for _, params := range []string{``, `, "params": null`, `, "params": []`, `, "params": {}`} {
+		resp := call(`{"jsonrpc": "2.0", "method": "rpc.methods"` + params + `, "id": 1}`)
+		if !reflect.DeepEqual(resp.Result, expected) {
+			t.Errorf("Invalid methods with params %q, expected: %v, got: %v", params, expected, resp.Result)
+		}
+	}
+
+	resp := call(`{"jsonrpc": "2.0", "method": "admin.users.GetUser", "params": [1], "id": 1}`)
+	if resp.Err == nil || resp.Err.Code != ErrorMethodNotFound {
+		t.Errorf("Admin methods should not be available before login: %+v", resp)
+	}
+
+	resp = call(`{"jsonrpc": "2.0", "method": "auth.login", "params": "secret", "id": 1}`)
+	if resp.Err != nil || resp.Result != true {
+		t.Fatalf("Invalid login response: %+v", resp)
+	}
+	expectChange(`{"added":["admin.users.GetUser","admin.users.HTTPStatus"]}`)
+
+	resp = call(`{"jsonrpc": "2.0", "method": "admin.users.GetUser", "params": [1], "id": 1}`)
+	if resp.Err != nil || resp.Result != "user!" {
+		t.Errorf("Admin methods should be available after login: %+v", resp)
+	}
+
+	if err := client.AddService(&AdminService{}); err == nil || !strings.Contains(err.Error(), "Service admin is already registered") {
+		t.Errorf("Invalid error adding a service twice: %v", err)
+	}
+
+	if err := client.RemoveService("admin"); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(`{"removed":["admin.users.GetUser","admin.users.HTTPStatus"]}`)
+
+	resp = call(`{"jsonrpc": "2.0", "method": "admin.users.GetUser", "params": [1], "id": 1}`)
+	if resp.Err == nil || resp.Err.Code != ErrorMethodNotFound {
+		t.Errorf("Admin methods should not be available after removal: %+v", resp)
+	}
+
+	if err := client.RemoveService("admin"); err == nil || !strings.Contains(err.Error(), "Service admin is not registered") {
+		t.Errorf("Invalid error removing a missing service: %v", err)
+	}
+}
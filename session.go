@@ -0,0 +1,381 @@
+package wsjson
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Notification sent first on each connection of a session, with SessionParams
+	MethodSession = "$/session"
+	// Notification acknowledging the messages received, with AckParams
+	MethodAck = "$/ack"
+
+	// Query parameters of the upgrade request resuming a session:
+	// its token and the seq of the last message received
+	SessionParam    = "wsjson-session"
+	SessionSeqParam = "wsjson-seq"
+
+	defaultGracePeriod = time.Minute
+	defaultBufferSize  = 1000
+	// Messages received between the acknowledgements sent
+	ackInterval = 16
+)
+
+// Options of the sessions, see SetSessions
+type SessionOptions struct {
+	// Time a session waits for its client to reconnect, one minute if zero
+	GracePeriod time.Duration
+	// Messages kept until acknowledged, 1000 if zero. The oldest ones are dropped
+	// when exceeded, and the session can't be resumed from before them
+	BufferSize int
+}
+
+// Params of MethodSession
+type SessionParams struct {
+	Token string `json:"token"`
+	// The session was resumed, the messages after the seq of the client follow
+	Resumed bool `json:"resumed"`
+}
+
+// Params of MethodAck
+type AckParams struct {
+	Seq int64 `json:"seq"`
+}
+
+// Enable the sessions of the connections whose protocol has FeatureSessions, nil disables them.
+// When a connection is lost its session keeps its services and calls for the grace period,
+// buffering the messages sent, and a client reconnecting with its token gets the ones it missed.
+// Must be called before serving connections
+func (wsj *WsJson) SetSessions(options *SessionOptions) {
+	if options != nil {
+		opts := *options
+		if opts.GracePeriod <= 0 {
+			opts.GracePeriod = defaultGracePeriod
+		}
+		if opts.BufferSize <= 0 {
+			opts.BufferSize = defaultBufferSize
+		}
+		options = &opts
+	}
+	wsj.sessionOptions = options
+}
+
+// Sessions waiting for their client, by token
+type sessionSet struct {
+	mutex   sync.Mutex
+	clients map[string]*WsJsonClient
+}
+
+func (ss *sessionSet) add(client *WsJsonClient) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	if ss.clients == nil {
+		ss.clients = make(map[string]*WsJsonClient)
+	}
+	ss.clients[client.session.token] = client
+}
+
+func (ss *sessionSet) remove(client *WsJsonClient) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	delete(ss.clients, client.session.token)
+}
+
+func (ss *sessionSet) get(token string) *WsJsonClient {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	return ss.clients[token]
+}
+
+// Session of a connection
+type connSession struct {
+	token   string
+	options *SessionOptions
+
+	mutex sync.Mutex
+	// seq of the last message sent
+	seq int64
+	// messages sent not acknowledged yet, oldest first
+	buffer []sessionMessage
+	// seq of the last message dropped from the buffer before being acknowledged
+	lost int64
+	// ends the session once the grace period is over, nil while connected
+	timer *time.Timer
+}
+
+type sessionMessage struct {
+	seq  int64
+	data []byte
+}
+
+// Queued to resume a session on a new connection
+type resumeConn struct {
+	conn        *websocket.Conn
+	compression *connCompression
+	// seq of the last message received by the client
+	seq int64
+}
+
+func newConnSession(options *SessionOptions) (*connSession, error) {
+	var token [16]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return nil, err
+	}
+	return &connSession{token: hex.EncodeToString(token[:]), options: options}, nil
+}
+
+// Assign the next seq to a message
+func (cs *connSession) nextSeq() int64 {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	cs.seq++
+	return cs.seq
+}
+
+// Keep a message until acknowledged
+func (cs *connSession) buffered(seq int64, data []byte) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	cs.buffer = append(cs.buffer, sessionMessage{seq, data})
+	if len(cs.buffer) > cs.options.BufferSize {
+		cs.lost = cs.buffer[0].seq
+		cs.buffer = cs.buffer[1:]
+	}
+}
+
+// Drop the messages received by the client
+func (cs *connSession) ack(seq int64) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	n := 0
+	for n < len(cs.buffer) && cs.buffer[n].seq <= seq {
+		n++
+	}
+	cs.buffer = cs.buffer[n:]
+}
+
+// Messages not received by a client that received until seq
+func (cs *connSession) replay(seq int64) []sessionMessage {
+	cs.ack(seq)
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	return append([]sessionMessage(nil), cs.buffer...)
+}
+
+// Whether a client that received until seq can resume the session
+func (cs *connSession) resumable(seq int64) bool {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	return seq >= cs.lost && seq <= cs.seq
+}
+
+// Start the grace period after the connection is lost
+func (cs *connSession) detach(expire func()) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	if cs.timer == nil {
+		cs.timer = time.AfterFunc(cs.options.GracePeriod, expire)
+	}
+}
+
+// Stop the grace period, false if it is over
+func (cs *connSession) attach() bool {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	if cs.timer == nil {
+		return true
+	}
+	stopped := cs.timer.Stop()
+	cs.timer = nil
+	return stopped
+}
+
+// Session resumed by an upgrade request with its token and the seq of the last message
+// received by the client, nil to start a new one. The client must connect with the same
+// protocol, and sessions with a principal are only resumed by requests authenticated as it
+func (wsj *WsJson) resumableSession(r *http.Request, principal Principal, protocol *Protocol) (*WsJsonClient, int64) {
+	query := r.URL.Query()
+	token := query.Get(SessionParam)
+	if token == "" {
+		return nil, 0
+	}
+	client := wsj.sessions.get(token)
+	if client == nil {
+		return nil, 0
+	}
+	if client.Protocol().Name != protocol.Name {
+		log.Printf("Session not resumed, protocol %q instead of %q", protocol.Name, client.Protocol().Name)
+		return nil, 0
+	}
+	if current := client.Principal(); current != nil && (principal == nil || current.Id() != principal.Id()) {
+		log.Printf("Session not resumed by a different principal")
+		return nil, 0
+	}
+
+	seq, _ := strconv.ParseInt(query.Get(SessionSeqParam), 10, 64)
+	if !client.session.resumable(seq) {
+		// the messages missed are not available, the session is kept until it expires
+		log.Printf("Session not resumed, the messages after %d are not available", seq)
+		return nil, 0
+	}
+	if !client.session.attach() {
+		return nil, 0
+	}
+	return client, seq
+}
+
+// Session of a dialed connection, as announced by the server
+type sessionTracker struct {
+	mutex sync.Mutex
+	token string
+	// seq of the last message received
+	seq int64
+	// receives whether the next session was resumed, may be nil
+	started chan bool
+}
+
+// Track the seq of a message received, returns whether it wasn't received before
+// and whether it has to be acknowledged
+func (st *sessionTracker) receive(seq int64) (bool, bool) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	if seq <= st.seq {
+		return false, false
+	}
+	st.seq = seq
+	return true, seq%ackInterval == 0
+}
+
+// Token and seq of the last message received, to resume the session
+func (st *sessionTracker) state() (string, int64) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	return st.token, st.seq
+}
+
+// Handles MethodSession, a new session starts its messages from the first one
+func (wsjc *WsJsonClient) handleSession(request Request) {
+	var params SessionParams
+	if wsjc.tracker == nil || wsjc.codec().Unmarshal(request.Params, &params) != nil {
+		return
+	}
+	wsjc.tracker.mutex.Lock()
+	defer wsjc.tracker.mutex.Unlock()
+	if !params.Resumed {
+		wsjc.tracker.seq = 0
+	}
+	wsjc.tracker.token = params.Token
+	if wsjc.tracker.started != nil {
+		wsjc.tracker.started <- params.Resumed
+		wsjc.tracker.started = nil
+	}
+}
+
+// Channel receiving whether the session of the next connection is resumed
+func (st *sessionTracker) expectSession() <-chan bool {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	st.started = make(chan bool, 1)
+	return st.started
+}
+
+// Handles MethodAck, the messages acknowledged are not replayed
+func (wsjc *WsJsonClient) handleAck(request Request) {
+	var params AckParams
+	if wsjc.session == nil || wsjc.codec().Unmarshal(request.Params, &params) != nil {
+		return
+	}
+	wsjc.session.ack(params.Seq)
+}
+
+// Send the token of the session first on each connection
+func (wsjc *WsJsonClient) startSession(resumed bool) {
+	request, err := wsjc.newRequest(MethodSession, &SessionParams{Token: wsjc.session.token, Resumed: resumed})
+	var data []byte
+	if err == nil {
+		data, err = wsjc.codec().Marshal(request)
+	}
+	if err != nil {
+		log.Printf("Error encoding message: %v", err)
+		return
+	}
+	wsjc.write(data)
+}
+
+// Continue on a new connection, sessions replay the messages the client missed
+func (wsjc *WsJsonClient) resume(rc resumeConn) {
+	if wsjc.session != nil && !wsjc.session.attach() {
+		// lost again and expired meanwhile
+		rc.conn.Close()
+		return
+	}
+	wsjc.connMutex.Lock()
+	select {
+	case <-wsjc.done:
+		wsjc.connMutex.Unlock()
+		rc.conn.Close()
+		return
+	default:
+	}
+	previous := wsjc.conn
+	wsjc.conn = rc.conn
+	wsjc.compression = rc.compression
+	wsjc.connMutex.Unlock()
+	if wsjc.metrics != nil {
+		wsjc.metrics.ConnectionOpened()
+	}
+	if previous != nil {
+		// the client reconnected before the loss was noticed
+		previous.Close()
+		if wsjc.metrics != nil {
+			wsjc.metrics.ConnectionClosed()
+		}
+	}
+
+	if wsjc.session != nil {
+		wsjc.startSession(true)
+		for _, message := range wsjc.session.replay(rc.seq) {
+			wsjc.write(message.data)
+		}
+	}
+	go wsjc.readLoop(rc.conn)
+}
+
+// Set the seq of a message of a session
+func setSeq(message interface{}, seq int64) {
+	switch message := message.(type) {
+	case *Request:
+		if message.Meta == nil {
+			message.Meta = &Meta{}
+		}
+		message.Meta.Seq = seq
+	case *Response:
+		if message.Meta == nil {
+			message.Meta = &Meta{}
+		}
+		message.Meta.Seq = seq
+	}
+}
+
+// Upgrade the connection of a client resuming its session
+func (wsj *WsJson) resumeSession(w http.ResponseWriter, r *http.Request, client *WsJsonClient, seq int64) {
+	conn, compression, err := wsj.upgrade(w, r, client.Protocol())
+	if err != nil {
+		if client.currentConn() == nil {
+			client.session.detach(client.close)
+		}
+		return
+	}
+	if err := client.send(resumeConn{conn, compression, seq}); err != nil {
+		// closed meanwhile, the client will start a new session
+		conn.Close()
+	}
+}
@@ -0,0 +1,306 @@
+package wsjson
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// State of a session, created by the ApiFactory for each one
+type CounterService struct {
+	count   int
+	release chan struct{}
+}
+
+func (cs *CounterService) ApiIncr() (int, error) {
+	cs.count++
+	return cs.count, nil
+}
+
+// Answers once released
+func (cs *CounterService) ApiWait() (string, error) {
+	<-cs.release
+	return "done", nil
+}
+
+func (cs *CounterService) ApiTicks(ctx context.Context, n int) (bool, error) {
+	client := ClientFromContext(ctx)
+	for i := 1; i <= n; i++ {
+		if err := client.SendEvent("tick", []int{i}); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func startSessionServer(t *testing.T, options *SessionOptions) (*WsJson, *httptest.Server, chan struct{}) {
+	release := make(chan struct{})
+	wsj := &WsJson{}
+	wsj.SetSessions(options)
+	wsj.SetApiFactory(func(w http.ResponseWriter, r *http.Request) []interface{} {
+		return []interface{}{&CounterService{release: release}}
+	})
+	return wsj, startServer(t, wsj), release
+}
+
+// Read the next message, either a request or a response
+func readMessage(t *testing.T, conn *websocket.Conn) *Request {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var message Request
+	if err := conn.ReadJSON(&message); err != nil {
+		t.Fatal(err)
+	}
+	return &message
+}
+
+func seqOf(message *Request) int64 {
+	if message.Meta == nil {
+		return 0
+	}
+	return message.Meta.Seq
+}
+
+// Read MethodSession, sent first on each connection
+func readSession(t *testing.T, conn *websocket.Conn) *SessionParams {
+	t.Helper()
+	message := readMessage(t, conn)
+	var params SessionParams
+	if message.Method != MethodSession || json.Unmarshal(message.Params, &params) != nil || params.Token == "" {
+		t.Fatalf("The session should be sent first, got: %s", message)
+	}
+	return &params
+}
+
+func sendMessage(t *testing.T, conn *websocket.Conn, message string) {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func resumePath(token string, seq int64) string {
+	return fmt.Sprintf("/?%s=%s&%s=%d", SessionParam, token, SessionSeqParam, seq)
+}
+
+// Close the connection without a close frame, as when the network is lost
+func dropConn(conn *websocket.Conn) {
+	conn.UnderlyingConn().Close()
+}
+
+func TestSessionResume(t *testing.T) {
+	_, server, release := startSessionServer(t, &SessionOptions{GracePeriod: time.Second})
+
+	conn, _, err := dialServer(server, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := readSession(t, conn)
+	if session.Resumed {
+		t.Errorf("A new session should not be resumed")
+	}
+	sendMessage(t, conn, `{"jsonrpc": "2.0", "method": "CounterService.Incr", "id": 1}`)
+	if resp := readMessage(t, conn); string(resp.Result) != "1" || seqOf(resp) != 1 {
+		t.Fatalf("The response should be the first message of the session, got: %s", resp)
+	}
+
+	// answered and notified while disconnected
+	sendMessage(t, conn, `{"jsonrpc": "2.0", "method": "CounterService.Wait", "id": 2}`)
+	sendMessage(t, conn, `{"jsonrpc": "2.0", "method": "CounterService.Ticks", "params": [2], "id": 3}`)
+	time.Sleep(50 * time.Millisecond)
+	dropConn(conn)
+	close(release)
+	time.Sleep(50 * time.Millisecond)
+
+	conn, _, err = dialServer(server, resumePath(session.Token, 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if resumed := readSession(t, conn); !resumed.Resumed || resumed.Token != session.Token {
+		t.Errorf("The session should be resumed, got: %+v", resumed)
+	}
+
+	received := map[string]int64{}
+	for seq := int64(2); seq <= 5; seq++ {
+		message := readMessage(t, conn)
+		if seqOf(message) != seq {
+			t.Errorf("Message %d should be replayed in order, got: %s", seq, message)
+		}
+		key := message.Method + string(message.Params)
+		if message.isResult() {
+			key = fmt.Sprintf("%v:%s", message.Id, message.Result)
+		}
+		received[key] = seqOf(message)
+	}
+	for _, expected := range []string{`2:"done"`, `tick[1]`, `tick[2]`, `3:true`} {
+		if _, ok := received[expected]; !ok {
+			t.Errorf("Message %s should be replayed, got: %v", expected, received)
+		}
+	}
+	if received["tick[1]"] > received["tick[2]"] || received["tick[2]"] > received["3:true"] {
+		t.Errorf("Messages should keep their order, got: %v", received)
+	}
+
+	// the services are the same
+	sendMessage(t, conn, `{"jsonrpc": "2.0", "method": "CounterService.Incr", "id": 4}`)
+	if resp := readMessage(t, conn); string(resp.Result) != "2" || seqOf(resp) != 6 {
+		t.Errorf("The state of the session should be kept, got: %s", resp)
+	}
+}
+
+func TestSessionNotResumed(t *testing.T) {
+	wsj, server, _ := startSessionServer(t, &SessionOptions{GracePeriod: 200 * time.Millisecond, BufferSize: 2})
+
+	var tests = []struct {
+		name string
+		// how the connection ends, after receiving 4 ticks
+		end func(conn *websocket.Conn)
+		seq int64
+		// the previous session is kept until it expires
+		kept bool
+	}{
+		{"expired", func(conn *websocket.Conn) {
+			dropConn(conn)
+			time.Sleep(400 * time.Millisecond)
+		}, 5, false},
+		{"normal closure", func(conn *websocket.Conn) {
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			time.Sleep(50 * time.Millisecond)
+			conn.Close()
+		}, 5, false},
+		{"messages dropped", dropConn, 1, true},
+		{"unknown seq", dropConn, 9, true},
+	}
+	for _, test := range tests {
+		conn, _, err := dialServer(server, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		session := readSession(t, conn)
+		sendMessage(t, conn, `{"jsonrpc": "2.0", "method": "CounterService.Ticks", "params": [4], "id": 1}`)
+		for i := 0; i < 5; i++ {
+			readMessage(t, conn)
+		}
+		test.end(conn)
+
+		conn, _, err = dialServer(server, resumePath(session.Token, test.seq), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resumed := readSession(t, conn); resumed.Resumed || resumed.Token == session.Token {
+			t.Errorf("%s: a new session should start, got: %+v", test.name, resumed)
+		}
+		sendMessage(t, conn, `{"jsonrpc": "2.0", "method": "CounterService.Incr", "id": 1}`)
+		if resp := readMessage(t, conn); string(resp.Result) != "1" || seqOf(resp) != 1 {
+			t.Errorf("%s: the new session should have new services, got: %s", test.name, resp)
+		}
+		conn.Close()
+		if client := wsj.sessions.get(session.Token); (client != nil) != test.kept {
+			t.Errorf("%s: the previous session should be kept: %v, got: %v", test.name, test.kept, client != nil)
+		}
+		deadline := time.Now().Add(time.Second)
+		for wsj.sessions.get(session.Token) != nil {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: the previous session should expire", test.name)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestSessionPrincipal(t *testing.T) {
+	wsj, server, _ := startSessionServer(t, &SessionOptions{GracePeriod: time.Second})
+	wsj.SetAuthenticator(&BearerAuthenticator{Validate: validateToken, QueryParam: "token"})
+
+	conn, _, err := dialServer(server, "/?token=valid-ana", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := readSession(t, conn)
+	dropConn(conn)
+
+	var tests = []struct {
+		query   string
+		resumed bool
+	}{
+		{"", false},
+		{"&token=valid-bob", false},
+		{"&token=valid-ana", true},
+	}
+	for _, test := range tests {
+		conn, _, err := dialServer(server, resumePath(session.Token, 0)+test.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resumed := readSession(t, conn); resumed.Resumed != test.resumed {
+			t.Errorf("Session resumed with %q should be %v, got: %+v", test.query, test.resumed, resumed)
+		}
+		dropConn(conn)
+	}
+}
+
+func TestSessionBuffer(t *testing.T) {
+	cs, err := newConnSession(&SessionOptions{BufferSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		seq := cs.nextSeq()
+		cs.buffered(seq, []byte{byte(seq)})
+	}
+
+	var tests = []struct {
+		seq       int64
+		resumable bool
+	}{{0, false}, {1, false}, {2, true}, {4, true}, {5, true}, {6, false}}
+	for _, test := range tests {
+		if got := cs.resumable(test.seq); got != test.resumable {
+			t.Errorf("Resuming from %d should be %v, got %v", test.seq, test.resumable, got)
+		}
+	}
+
+	cs.ack(3)
+	replayed := cs.replay(3)
+	if len(replayed) != 2 || replayed[0].seq != 4 || replayed[1].seq != 5 {
+		t.Errorf("Messages after 3 should be replayed, got: %v", replayed)
+	}
+	if replayed = cs.replay(5); len(replayed) != 0 {
+		t.Errorf("No messages should be replayed, got: %v", replayed)
+	}
+}
+
+func TestSessionDialed(t *testing.T) {
+	wsj, server, _ := startSessionServer(t, &SessionOptions{})
+	url := "ws" + server.URL[len("http"):]
+	client, err := (&WsJson{}).Dial(context.Background(), url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	events := ackInterval + 4
+	if err := client.Call(context.Background(), "CounterService.Ticks", []int{events}, nil); err != nil {
+		t.Fatal(err)
+	}
+	token, seq := client.tracker.state()
+	if token == "" || seq != int64(events)+1 {
+		t.Errorf("The session should be tracked, got: %q %d", token, seq)
+	}
+
+	// the acknowledged messages are not kept
+	session := wsj.sessions.get(token).session
+	deadline := time.Now().Add(time.Second)
+	for len(session.replay(0)) != 5 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if replayed := session.replay(0); len(replayed) != 5 {
+		t.Errorf("Messages after %d should be kept, got %d", ackInterval, len(replayed))
+	}
+}
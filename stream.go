@@ -0,0 +1,185 @@
+package wsjson
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+)
+
+const (
+	// Notification with a value of a streaming call, sent before its response
+	MethodPartialResult = "$/partialResult"
+)
+
+var typeOfStream = reflect.TypeOf((*Stream)(nil))
+
+// Params of MethodPartialResult
+type PartialResult struct {
+	// Id of the call
+	Id    interface{} `json:"id"`
+	Value interface{} `json:"value"`
+}
+
+// Result of the methods returning a channel, sent after all its values
+type StreamResult struct {
+	Count int `json:"count"`
+}
+
+// Sends partial results of a call, methods receive it as the first argument
+// after the context. Their return value is sent as the final response
+type Stream struct {
+	ctx    context.Context
+	client *WsJsonClient
+	id     interface{}
+	count  atomic.Int64
+}
+
+type requestIdCtxKey struct{}
+
+func withRequestId(ctx context.Context, id interface{}) context.Context {
+	return context.WithValue(ctx, requestIdCtxKey{}, id)
+}
+
+// Id of the request being handled, nil for notifications
+func requestIdFromContext(ctx context.Context) interface{} {
+	return ctx.Value(requestIdCtxKey{})
+}
+
+// Stream for the call being handled
+func newStream(ctx context.Context) (*Stream, error) {
+	client := ClientFromContext(ctx)
+	id := requestIdFromContext(ctx)
+	if client == nil || id == nil {
+		return nil, NewError(ErrorInvalidRequest, "Streaming methods must be called with an id")
+	}
+	return &Stream{ctx: ctx, client: client, id: id}, nil
+}
+
+// Send a partial result, it blocks while the peer is not reading them.
+// Fails when the call is cancelled or the connection closed
+func (s *Stream) Send(value interface{}) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	request, err := s.client.newRequest(MethodPartialResult, &PartialResult{Id: s.id, Value: value})
+	if err != nil {
+		return err
+	}
+	if err = s.client.sendContext(s.ctx, request); err != nil {
+		return err
+	}
+	s.count.Add(1)
+	return nil
+}
+
+// Context of the call, done when it is cancelled
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+// Number of partial results sent
+func (s *Stream) Count() int {
+	return int(s.count.Load())
+}
+
+// Send the values of a channel until it is closed
+func (s *Stream) drain(ch reflect.Value) (*StreamResult, error) {
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: ch},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(s.ctx.Done())},
+	}
+	for {
+		chosen, value, ok := reflect.Select(cases)
+		if chosen == 1 {
+			discard(ch)
+			return nil, s.ctx.Err()
+		}
+		if !ok {
+			return &StreamResult{Count: s.Count()}, nil
+		}
+		if err := s.Send(value.Interface()); err != nil {
+			discard(ch)
+			return nil, err
+		}
+	}
+}
+
+// Receive the values of a channel in the background until it is closed,
+// its sender would block forever otherwise
+func discard(ch reflect.Value) {
+	go func() {
+		for {
+			if _, ok := ch.Recv(); !ok {
+				return
+			}
+		}
+	}()
+}
+
+// Queue a message to be sent unless the context is done first
+func (wsjc *WsJsonClient) sendContext(ctx context.Context, message interface{}) error {
+	if wsjc.overHTTP {
+		return ErrNoWebsocket
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	select {
+	case wsjc.output <- message:
+		return nil
+	case <-wsjc.done:
+		return ErrConnectionClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Handles the partial results of the streaming calls made
+func (wsjc *WsJsonClient) handlePartialResult(request Request) {
+	var partial struct {
+		Id    interface{} `json:"id"`
+		Value RawMessage  `json:"value"`
+	}
+	if err := wsjc.codec().Unmarshal(request.Params, &partial); err != nil {
+		return
+	}
+	id, ok := requestId(partial.Id)
+	if !ok {
+		return
+	}
+
+	if handlers := wsjc.pendingCallHandlers(id); handlers != nil && handlers.onValue != nil {
+		handlers.onValue(partial.Value)
+	}
+}
+
+// CallStream calls a streaming method of the peer, onValue is called with each
+// partial result in order and result receives the final response.
+// Reading from the connection waits for onValue to return
+func CallStream[T any](ctx context.Context, client *WsJsonClient, name string, params interface{}, onValue func(T), result interface{}) error {
+	var decodeErr error
+	codec := client.codec()
+	handlers := &callHandlers{onProgress: progressFromContext(ctx)}
+	handlers.onValue = func(raw RawMessage) {
+		var value T
+		if err := codec.Unmarshal(raw, &value); err != nil {
+			if decodeErr == nil {
+				decodeErr = err
+			}
+			return
+		}
+		onValue(value)
+	}
+	id, ch, err := client.sendRequest(ctx, name, params, handlers)
+	if err != nil {
+		return err
+	}
+
+	if err := client.waitResult(ctx, id, ch, result); err != nil {
+		return err
+	}
+	return decodeErr
+}
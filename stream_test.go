@@ -0,0 +1,199 @@
+package wsjson
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type LogService struct {
+	stopped chan error
+}
+
+func (*LogService) ApiTail(stream *Stream, lines int) (string, error) {
+	for i := 0; i < lines; i++ {
+		if err := stream.Send(fmt.Sprintf("line %d", i)); err != nil {
+			return "", err
+		}
+	}
+	return "eof", nil
+}
+
+func (*LogService) ApiCount(ctx context.Context, n int) (<-chan int, error) {
+	if n < 0 {
+		return nil, NewError(ErrorInvalidParams, "Invalid count")
+	}
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		for i := 0; i < n; i++ {
+			select {
+			case ch <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (ls *LogService) ApiFollow(ctx context.Context, stream *Stream) (bool, error) {
+	for {
+		if err := stream.Send("line"); err != nil {
+			ls.stopped <- err
+			return false, err
+		}
+	}
+}
+
+func TestStreaming(t *testing.T) {
+	wsj := &WsJson{}
+	wsj.AddService(&LogService{})
+	server := startServer(t, wsj)
+
+	conn, _, err := dialServer(server, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var table = []struct {
+		msg    string
+		values []interface{}
+		result interface{}
+	}{
+		{`{"jsonrpc": "2.0", "method": "LogService.Tail", "params": [3], "id": 1}`,
+			[]interface{}{"line 0", "line 1", "line 2"}, "eof"},
+		{`{"jsonrpc": "2.0", "method": "LogService.Count", "params": [4], "id": 2}`,
+			[]interface{}{0.0, 1.0, 2.0, 3.0}, map[string]interface{}{"count": 4.0}},
+		{`{"jsonrpc": "2.0", "method": "LogService.Count", "params": [0], "id": 3}`,
+			nil, map[string]interface{}{"count": 0.0}},
+	}
+
+	for _, row := range table {
+		if err := conn.WriteMessage(1, []byte(row.msg)); err != nil {
+			t.Fatal(err)
+		}
+
+		var values []interface{}
+		for {
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			var msg struct {
+				Method string
+				Params PartialResult
+				Result interface{}
+				Error  *Error
+			}
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Method != MethodPartialResult {
+				if msg.Error != nil || !reflect.DeepEqual(msg.Result, row.result) {
+					t.Errorf("Invalid final response for %s, expected: %v, got: %v %v", row.msg, row.result, msg.Result, msg.Error)
+				}
+				break
+			}
+			values = append(values, msg.Params.Value)
+		}
+
+		if !reflect.DeepEqual(values, row.values) {
+			t.Errorf("Invalid partial results for %s, expected: %v, got: %v", row.msg, row.values, values)
+		}
+	}
+
+	// errors before streaming
+	resp := roundTrip(t, conn, `{"jsonrpc": "2.0", "method": "LogService.Count", "params": [-1], "id": 4}`)
+	if resp.Err == nil || resp.Err.Code != ErrorInvalidParams {
+		t.Errorf("Method errors should be returned: %+v", resp)
+	}
+}
+
+func TestStreamingStops(t *testing.T) {
+	stopped := make(chan error, 1)
+	wsj := &WsJson{}
+	wsj.AddService(&LogService{stopped})
+	server := startServer(t, wsj)
+
+	conn, _, err := dialServer(server, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteMessage(1, []byte(`{"jsonrpc": "2.0", "method": "LogService.Follow", "id": 1}`)); err != nil {
+		t.Fatal(err)
+	}
+	// the stream waits while the peer doesn't read
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+
+	select {
+	case err := <-stopped:
+		if err != context.Canceled && err != ErrConnectionClosed {
+			t.Errorf("Invalid error after closing: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Stream should stop when the connection is closed")
+	}
+}
+
+func TestStreamingDiscards(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stream := &Stream{ctx: ctx}
+
+	// a sender that ignores the context
+	ch := make(chan int)
+	closed := make(chan bool)
+	go func() {
+		for i := 0; i < 3; i++ {
+			ch <- i
+		}
+		close(ch)
+		closed <- true
+	}()
+
+	if _, err := stream.drain(reflect.ValueOf(ch)); err != context.Canceled {
+		t.Errorf("Draining a cancelled call should fail, got: %v", err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Errorf("The values left should be discarded until the channel is closed")
+	}
+}
+
+func TestCallStream(t *testing.T) {
+	wsj := &WsJson{}
+	wsj.AddService(&LogService{})
+	server := startServer(t, wsj)
+
+	conn, _, err := dialServer(server, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := newWsJsonClient(newServiceManager(), conn, []interface{}{&WhoAmIService{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.serve()
+	defer client.close()
+
+	var lines []string
+	var result string
+	err = CallStream(context.Background(), client, "LogService.Tail", []int{50}, func(line string) {
+		lines = append(lines, line)
+	}, &result)
+	if err != nil || result != "eof" || len(lines) != 50 || lines[49] != "line 49" {
+		t.Errorf("Invalid stream, got: %v %s %v", err, result, lines)
+	}
+
+	var sum int
+	var count StreamResult
+	err = CallStream(context.Background(), client, "LogService.Count", []int{10}, func(n int) {
+		sum += n
+	}, &count)
+	if err != nil || sum != 45 || count.Count != 10 {
+		t.Errorf("Invalid stream, got: %v %d %+v", err, sum, count)
+	}
+}
@@ -0,0 +1,280 @@
+package wsjson
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Attributes of the spans of the calls, following the OpenTelemetry conventions
+const (
+	AttrRPCSystem     = "rpc.system"
+	AttrRPCMethod     = "rpc.method"
+	AttrRPCRequestId  = "rpc.jsonrpc.request_id"
+	AttrRPCErrorCode  = "rpc.jsonrpc.error_code"
+	AttrRPCParamsSize = "rpc.jsonrpc.params_size"
+)
+
+var errInvalidTraceparent = errors.New("Invalid traceparent")
+
+// Identifies a span across peers, sent as a W3C traceparent
+type SpanContext struct {
+	TraceId [16]byte
+	SpanId  [8]byte
+	Sampled bool
+}
+
+// Whether the trace and span ids are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceId != [16]byte{} && sc.SpanId != [8]byte{}
+}
+
+// Traceparent of the span, e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%x-%x-%s", sc.TraceId, sc.SpanId, flags)
+}
+
+// ParseTraceparent decodes a W3C traceparent of version 00
+func ParseTraceparent(traceparent string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(traceparent, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, errInvalidTraceparent
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, errInvalidTraceparent
+	}
+	if _, err := hex.Decode(sc.TraceId[:], []byte(parts[1])); err != nil {
+		return sc, errInvalidTraceparent
+	}
+	if _, err := hex.Decode(sc.SpanId[:], []byte(parts[2])); err != nil {
+		return sc, errInvalidTraceparent
+	}
+	if !sc.IsValid() {
+		return sc, errInvalidTraceparent
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = iota
+	// A call received
+	SpanKindServer
+	// A call made to the peer
+	SpanKindClient
+)
+
+// A span once ended, as received by the exporters
+type SpanData struct {
+	Name        string
+	Kind        SpanKind
+	SpanContext SpanContext
+	// Invalid for the root spans
+	Parent SpanContext
+	// Whether the parent was received from the peer
+	RemoteParent bool
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]interface{}
+}
+
+// Receives the spans sampled when they end, called concurrently
+type SpanExporter interface {
+	ExportSpan(span SpanData)
+}
+
+// Span in progress, its methods do nothing on a nil span
+type Span struct {
+	tracer *Tracer
+	mutex  sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// Set an attribute of the span, ended spans are not changed
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.ended {
+		s.data.Attributes[key] = value
+	}
+}
+
+// Context to propagate, invalid for a nil span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// End the span and export it if sampled, only the first call has effect
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	// the exporter may keep the data
+	data.Attributes = make(map[string]interface{}, len(s.data.Attributes))
+	for key, value := range s.data.Attributes {
+		data.Attributes[key] = value
+	}
+	s.mutex.Unlock()
+
+	if data.SpanContext.Sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.ExportSpan(data)
+	}
+}
+
+// End the span of a call with the error received or sent, nil if it succeeded
+func (s *Span) endCall(err *Error) {
+	if err != nil {
+		s.SetAttribute(AttrRPCErrorCode, err.Code)
+	}
+	s.End()
+}
+
+type spanCtxKey struct{}
+
+// SpanFromContext returns the span of the context, nil if none
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanCtxKey{}).(*Span)
+	return span
+}
+
+// ContextWithSpan returns a context where the span is the parent of the new spans
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanCtxKey{}, span)
+}
+
+// Creates the spans and sends them to an exporter
+type Tracer struct {
+	exporter SpanExporter
+}
+
+func NewTracer(exporter SpanExporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// Start a span, child of the span in the context if any
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	span := t.start(SpanFromContext(ctx).SpanContext(), false, name, SpanKindInternal)
+	return ContextWithSpan(ctx, span), span
+}
+
+// Start a span with the given parent, a root span when it is invalid
+func (t *Tracer) start(parent SpanContext, remote bool, name string, kind SpanKind) *Span {
+	sc := SpanContext{TraceId: parent.TraceId, Sampled: true}
+	if parent.IsValid() {
+		sc.Sampled = parent.Sampled
+	} else {
+		rand.Read(sc.TraceId[:])
+		remote = false
+	}
+	rand.Read(sc.SpanId[:])
+
+	return &Span{
+		tracer: t,
+		data: SpanData{
+			Name:         name,
+			Kind:         kind,
+			SpanContext:  sc,
+			Parent:       parent,
+			RemoteParent: remote,
+			Start:        time.Now(),
+			Attributes:   make(map[string]interface{}),
+		},
+	}
+}
+
+// Start the span of a call made or received
+func (t *Tracer) startCall(parent SpanContext, remote bool, kind SpanKind, request *Request) *Span {
+	span := t.start(parent, remote, request.Method, kind)
+	span.data.Attributes[AttrRPCSystem] = "jsonrpc"
+	span.data.Attributes[AttrRPCMethod] = request.Method
+	span.data.Attributes[AttrRPCParamsSize] = len(request.Params)
+	if request.Id != nil {
+		span.data.Attributes[AttrRPCRequestId] = request.Id
+	}
+	return span
+}
+
+// Set the tracer of the calls, nil disables tracing.
+// Must be called before serving connections
+func (wsj *WsJson) SetTracer(tracer *Tracer) {
+	wsj.tracer = tracer
+}
+
+// Span of a call received, child of the traceparent sent by the peer if valid
+func (wsjc *WsJsonClient) startServerSpan(ctx context.Context, request *Request) context.Context {
+	if wsjc.tracer == nil {
+		return ctx
+	}
+	var parent SpanContext
+	if request.Meta != nil && request.Meta.Traceparent != "" {
+		parent, _ = ParseTraceparent(request.Meta.Traceparent)
+	}
+	return ContextWithSpan(ctx, wsjc.tracer.startCall(parent, true, SpanKindServer, request))
+}
+
+// Span of a call made, child of the span in the context. The traceparent is sent to the peer
+func (wsjc *WsJsonClient) startClientSpan(ctx context.Context, request *Request) *Span {
+	if wsjc.tracer == nil {
+		return nil
+	}
+	span := wsjc.tracer.startCall(SpanFromContext(ctx).SpanContext(), false, SpanKindClient, request)
+	if request.Meta == nil {
+		request.Meta = &Meta{}
+	}
+	request.Meta.Traceparent = span.SpanContext().Traceparent()
+	return span
+}
+
+// Keeps the spans ended in memory, for tests
+type InMemoryExporter struct {
+	mutex sync.Mutex
+	spans []SpanData
+}
+
+func (e *InMemoryExporter) ExportSpan(span SpanData) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+// Spans exported, in the order they ended
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+// Discard the spans exported
+func (e *InMemoryExporter) Reset() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans = nil
+}
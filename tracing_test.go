@@ -0,0 +1,151 @@
+package wsjson
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+type TraceService struct{}
+
+func (*TraceService) ApiHello(ctx context.Context, name string) (string, error) {
+	var greeting string
+	if err := ClientFromContext(ctx).Call(ctx, "PeerService.Greeting", nil, &greeting); err != nil {
+		return "", err
+	}
+	return greeting + " " + name, nil
+}
+
+type PeerService struct{}
+
+func (*PeerService) ApiGreeting() (string, error) {
+	return "Hello", nil
+}
+
+func TestParseTraceparent(t *testing.T) {
+	var table = []struct {
+		traceparent string
+		valid       bool
+		sampled     bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-zzf067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false, false},
+		{"", false, false},
+	}
+
+	for _, row := range table {
+		sc, err := ParseTraceparent(row.traceparent)
+		if (err == nil) != row.valid {
+			t.Errorf("Invalid validation of %q: %v", row.traceparent, err)
+			continue
+		}
+		if !row.valid {
+			continue
+		}
+		if sc.Sampled != row.sampled || sc.Traceparent() != row.traceparent {
+			t.Errorf("Invalid span context of %q: %+v %s", row.traceparent, sc, sc.Traceparent())
+		}
+	}
+}
+
+func TestTracing(t *testing.T) {
+	exporter := &InMemoryExporter{}
+	tracer := NewTracer(exporter)
+	wsj := &WsJson{}
+	wsj.AddService(&TraceService{})
+	wsj.SetTracer(tracer)
+	server := startServer(t, wsj)
+
+	conn, _, err := dialServer(server, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := newWsJsonClient(newServiceManager(), conn, []interface{}{&PeerService{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.tracer = tracer
+	client.serve()
+	defer client.close()
+
+	ctx, root := tracer.Start(context.Background(), "test")
+	var greeting string
+	if err := client.Call(ctx, "TraceService.Hello", []string{"world"}, &greeting); err != nil || greeting != "Hello world" {
+		t.Fatalf("Invalid traced call: %v %s", err, greeting)
+	}
+	root.End()
+
+	spans := make(map[string]SpanData)
+	for _, span := range exporter.Spans() {
+		spans[fmt.Sprintf("%s/%d", span.Name, span.Kind)] = span
+	}
+	// each span is the parent of the next one
+	var chain = []struct {
+		key    string
+		kind   SpanKind
+		remote bool
+	}{
+		{"test/0", SpanKindInternal, false},
+		{"TraceService.Hello/2", SpanKindClient, false},
+		{"TraceService.Hello/1", SpanKindServer, true},
+		{"PeerService.Greeting/2", SpanKindClient, false},
+		{"PeerService.Greeting/1", SpanKindServer, true},
+	}
+	for i, link := range chain {
+		span, ok := spans[link.key]
+		if !ok {
+			t.Fatalf("Span %s not exported, got: %v", link.key, exporter.Spans())
+		}
+		if span.SpanContext.TraceId != root.SpanContext().TraceId {
+			t.Errorf("Span %s should be in the trace of the root", link.key)
+		}
+		if i == 0 {
+			continue
+		}
+		parent := spans[chain[i-1].key]
+		if span.Parent != parent.SpanContext || span.RemoteParent != link.remote {
+			t.Errorf("Span %s should be a child of %s", link.key, chain[i-1].key)
+		}
+		if span.Attributes[AttrRPCMethod] != span.Name || span.Attributes[AttrRPCErrorCode] != nil {
+			t.Errorf("Invalid attributes of %s: %v", link.key, span.Attributes)
+		}
+	}
+	if size := spans["TraceService.Hello/1"].Attributes[AttrRPCParamsSize]; size != len(`["world"]`) {
+		t.Errorf("Invalid params size: %v", size)
+	}
+
+	// errors are recorded on both sides
+	exporter.Reset()
+	if err := client.Call(context.Background(), "TraceService.Missing", nil, nil); err == nil {
+		t.Fatalf("Call to a missing method should fail")
+	}
+	for _, span := range exporter.Spans() {
+		if span.Attributes[AttrRPCErrorCode] != ErrorMethodNotFound || span.Parent.IsValid() != (span.Kind == SpanKindServer) {
+			t.Errorf("Invalid span of a failed call: %+v", span)
+		}
+	}
+	if n := len(exporter.Spans()); n != 2 {
+		t.Errorf("Failed call should have 2 spans, got %d", n)
+	}
+}
+
+func TestSpanEnded(t *testing.T) {
+	exporter := &InMemoryExporter{}
+	_, span := NewTracer(exporter).Start(context.Background(), "work")
+	span.SetAttribute("step", 1)
+	span.End()
+	span.SetAttribute("step", 2)
+	span.SetAttribute("late", true)
+
+	spans := exporter.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("Span should be exported once, got: %v", spans)
+	}
+	if attrs := spans[0].Attributes; attrs["step"] != 1 || attrs["late"] != nil {
+		t.Errorf("Attributes set after ending should be ignored, got: %v", attrs)
+	}
+}
@@ -0,0 +1,181 @@
+// Browser runtime for the clients generated by wsjson.GenerateTypeScript.
+
+export interface RpcError {
+  code: number;
+  message: string;
+  data?: any;
+}
+
+export class WsJsonError extends Error {
+  constructor(public code: number, message: string, public data?: any) {
+    super(message);
+  }
+}
+
+// Handles a method call or an event sent by the server
+export type Handler = (params: any) => any | Promise<any>;
+
+// Result of the streaming methods returning a channel
+export interface StreamResult {
+  count: number;
+}
+
+// Progress reported by the server for a call
+export interface Progress {
+  percent: number;
+  message?: string;
+  data?: any;
+}
+
+export interface CallOptions {
+  // Aborting the signal asks the server to cancel the call
+  signal?: AbortSignal;
+  // Receives the progress reported by the server
+  onProgress?: (progress: Progress) => void;
+}
+
+interface Pending {
+  resolve: (result: any) => void;
+  reject: (err: any) => void;
+  onValue?: (value: any) => void;
+  onProgress?: (progress: Progress) => void;
+}
+
+export class WsJsonConnection {
+  private socket: WebSocket;
+  private nextId = 1;
+  private pending = new Map<number, Pending>();
+  private handlers = new Map<string, Handler>();
+  private queue: string[] = [];
+
+  constructor(url: string, protocols?: string | string[]) {
+    this.socket = new WebSocket(url, protocols);
+    this.socket.onopen = () => {
+      for (const msg of this.queue) {
+        this.socket.send(msg);
+      }
+      this.queue = [];
+    };
+    this.socket.onmessage = (ev) => this.receive(ev.data);
+    this.socket.onclose = () => {
+      for (const p of this.pending.values()) {
+        p.reject(new WsJsonError(-32603, "Connection closed"));
+      }
+      this.pending.clear();
+    };
+  }
+
+  // Call a method on the server, the promise resolves with its result
+  call<T = any>(method: string, params?: any, options?: CallOptions): Promise<T> {
+    return this.request<T>(method, params, undefined, options);
+  }
+
+  // Call a streaming method, onValue receives each partial result before the promise resolves
+  stream<T = any, R = any>(method: string, params: any, onValue: (value: T) => void, options?: CallOptions): Promise<R> {
+    return this.request<R>(method, params, onValue, options);
+  }
+
+  // Send an event, no response is expected
+  notify(method: string, params?: any): void {
+    this.send({ jsonrpc: "2.0", method, params });
+  }
+
+  // Handle calls and events sent by the server
+  on(method: string, handler: Handler): void {
+    this.handlers.set(method, handler);
+  }
+
+  close(): void {
+    this.socket.close();
+  }
+
+  private request<T>(method: string, params: any, onValue?: (value: any) => void, options: CallOptions = {}): Promise<T> {
+    const id = this.nextId++;
+    const onProgress = options.onProgress;
+    return new Promise<T>((resolve, reject) => {
+      this.pending.set(id, { resolve, reject, onValue, onProgress });
+      const meta = onProgress !== undefined ? { progressToken: id } : undefined;
+      this.send({ jsonrpc: "2.0", method, params, id, meta });
+      options.signal?.addEventListener("abort", () => {
+        if (this.pending.has(id)) {
+          this.notify("$/cancelRequest", { id });
+        }
+      });
+    });
+  }
+
+  private send(msg: any): void {
+    const data = JSON.stringify(msg);
+    if (this.socket.readyState === WebSocket.CONNECTING) {
+      this.queue.push(data);
+    } else {
+      this.socket.send(data);
+    }
+  }
+
+  private receive(data: string): void {
+    let msg: any;
+    try {
+      msg = JSON.parse(data);
+    } catch (e) {
+      return;
+    }
+
+    if (msg.method === "$/partialResult") {
+      const p = this.pending.get(msg.params.id);
+      if (p !== undefined && p.onValue !== undefined) {
+        p.onValue(msg.params.value);
+      }
+      return;
+    }
+
+    if (msg.method === "$/progress") {
+      const p = this.pending.get(msg.params.token);
+      if (p !== undefined && p.onProgress !== undefined) {
+        p.onProgress(msg.params.value);
+      }
+      return;
+    }
+
+    if (msg.method === undefined) {
+      const p = this.pending.get(msg.id);
+      if (p === undefined) {
+        return;
+      }
+      this.pending.delete(msg.id);
+      if (msg.error) {
+        const err: RpcError = msg.error;
+        p.reject(new WsJsonError(err.code, err.message, err.data));
+      } else {
+        p.resolve(msg.result);
+      }
+      return;
+    }
+
+    this.dispatch(msg.method, msg.params, msg.id);
+  }
+
+  private async dispatch(method: string, params: any, id: any): Promise<void> {
+    const handler = this.handlers.get(method);
+    if (handler === undefined) {
+      if (id !== undefined) {
+        this.send({ jsonrpc: "2.0", error: { code: -32601, message: "Method not found: " + method }, id });
+      }
+      return;
+    }
+
+    try {
+      const result = await handler(params);
+      if (id !== undefined) {
+        this.send({ jsonrpc: "2.0", result: result === undefined ? null : result, id });
+      }
+    } catch (e: any) {
+      if (id !== undefined) {
+        const error = e instanceof WsJsonError
+          ? { code: e.code, message: e.message, data: e.data }
+          : { code: -32603, message: String(e && e.message ? e.message : e) };
+        this.send({ jsonrpc: "2.0", error, id });
+      }
+    }
+  }
+}
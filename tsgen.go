@@ -0,0 +1,306 @@
+package wsjson
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Browser runtime used by the generated TypeScript clients
+//
+//go:embed ts/wsjson-runtime.ts
+var tsRuntime string
+
+var (
+	typeOfTime          = reflect.TypeOf(time.Time{})
+	typeOfRawMessage    = reflect.TypeOf(json.RawMessage{})
+	typeOfJsonMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// WriteTypeScriptRuntime writes the browser runtime the generated clients depend on.
+// It should be saved as "wsjson-runtime.ts" next to the generated code.
+func WriteTypeScriptRuntime(w io.Writer) error {
+	_, err := io.WriteString(w, tsRuntime)
+	return err
+}
+
+// GenerateTypeScript writes a typed TypeScript client for the given services.
+// Services are inspected the same way they are when registered on a connection,
+// the output contains one interface per struct type used by the exposed methods
+// and one client class per service.
+func GenerateTypeScript(w io.Writer, services ...interface{}) error {
+	return new(WsJson).GenerateTypeScript(w, services...)
+}
+
+// GenerateTypeScript writes a typed TypeScript client for the given services
+// using the naming options of the handler
+func (wsj *WsJson) GenerateTypeScript(w io.Writer, services ...interface{}) error {
+	manager := wsj.newServiceManager()
+	gen := newTsGenerator(manager.separator)
+	for _, instance := range services {
+		servs, err := manager.newServices("", instance)
+		if err != nil {
+			return err
+		}
+		for _, serv := range servs {
+			if len(serv.methods) > 0 {
+				gen.addService(serv)
+			}
+		}
+	}
+
+	if gen.err != nil {
+		return gen.err
+	}
+
+	_, err := io.WriteString(w, gen.String())
+	return err
+}
+
+// Generates the TypeScript source for a set of services
+type tsGenerator struct {
+	separator string
+	// struct types already declared, by TypeScript name
+	declared map[string]reflect.Type
+	// interface declarations in the order they were found
+	interfaces []string
+	classes    []string
+	// StreamResult must be imported from the runtime
+	usesStreamResult bool
+	err              error
+}
+
+func newTsGenerator(separator string) *tsGenerator {
+	return &tsGenerator{
+		separator: separator,
+		declared:  make(map[string]reflect.Type),
+	}
+}
+
+func (gen *tsGenerator) String() string {
+	var b strings.Builder
+	b.WriteString("// Code generated by wsjson. DO NOT EDIT.\n\n")
+	imports := "WsJsonConnection"
+	if gen.usesStreamResult {
+		imports += ", StreamResult"
+	}
+	fmt.Fprintf(&b, "import { %s } from \"./wsjson-runtime\";\n", imports)
+	for _, decl := range gen.interfaces {
+		b.WriteString("\n")
+		b.WriteString(decl)
+	}
+	for _, decl := range gen.classes {
+		b.WriteString("\n")
+		b.WriteString(decl)
+	}
+	return b.String()
+}
+
+// Add the client class of a service
+func (gen *tsGenerator) addService(serv *service) {
+	names := make([]string, 0, len(serv.methods))
+	for name := range serv.methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "export class %sClient {\n", tsIdentifier(serv.name))
+	b.WriteString("  constructor(private conn: WsJsonConnection) {}\n")
+	for _, name := range names {
+		b.WriteString("\n")
+		gen.writeMethod(&b, serv.name+gen.separator+name, name, serv.methods[name])
+	}
+	b.WriteString("}\n")
+	gen.classes = append(gen.classes, b.String())
+}
+
+// Write a single client method
+func (gen *tsGenerator) writeMethod(b *strings.Builder, fullName, name string, method *serviceMethod) {
+	args := make([]string, len(method.argTypes))
+	var params string
+	if len(method.argTypes) == 1 && isStructParam(method.argTypes[0]) {
+		args[0] = "params: " + gen.tsType(method.argTypes[0])
+		params = "params"
+	} else {
+		names := make([]string, len(method.argTypes))
+		for i, argType := range method.argTypes {
+			names[i] = fmt.Sprintf("arg%d", i)
+			args[i] = names[i] + ": " + gen.tsType(argType)
+		}
+		params = "[" + strings.Join(names, ", ") + "]"
+	}
+
+	methodName := tsIdentifier(lowerFirst(name))
+	if method.hasStream || method.returnsChan {
+		valueType, resultType := "any", gen.tsType(method.returnType)
+		if method.returnsChan {
+			valueType, resultType = gen.tsType(method.returnType.Elem()), "StreamResult"
+			gen.usesStreamResult = true
+		}
+		args = append(args, fmt.Sprintf("onValue: (value: %s) => void", valueType))
+		fmt.Fprintf(b, "  %s(%s): Promise<%s> {\n", methodName, strings.Join(args, ", "), resultType)
+		fmt.Fprintf(b, "    return this.conn.stream(%q, %s, onValue);\n", fullName, params)
+	} else if method.isEvent {
+		fmt.Fprintf(b, "  %s(%s): void {\n", methodName, strings.Join(args, ", "))
+		fmt.Fprintf(b, "    this.conn.notify(%q, %s);\n", fullName, params)
+	} else {
+		fmt.Fprintf(b, "  %s(%s): Promise<%s> {\n", methodName, strings.Join(args, ", "), gen.tsType(method.returnType))
+		fmt.Fprintf(b, "    return this.conn.call(%q, %s);\n", fullName, params)
+	}
+	b.WriteString("  }\n")
+}
+
+// TypeScript type for a Go type, following the encoding/json rules
+func (gen *tsGenerator) tsType(t reflect.Type) string {
+	if t == typeOfTime {
+		return "string"
+	}
+	if t == typeOfRawMessage {
+		return "any"
+	}
+	if t.Implements(typeOfJsonMarshaler) {
+		return "any"
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Ptr:
+		return gen.tsType(t.Elem())
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 && t.Kind() == reflect.Slice {
+			// encoded as base64
+			return "string"
+		}
+		return arrayType(gen.tsType(t.Elem()))
+	case reflect.Map:
+		return fmt.Sprintf("{ [key: string]: %s }", gen.tsType(t.Elem()))
+	case reflect.Struct:
+		if t.Name() == "" {
+			return gen.objectType(t, "")
+		}
+		return gen.declare(t)
+	default:
+		return "any"
+	}
+}
+
+// Declare an interface for a named struct type, returns its name
+func (gen *tsGenerator) declare(t reflect.Type) string {
+	name := tsIdentifier(t.Name())
+	if prev, ok := gen.declared[name]; ok {
+		if prev != t && gen.err == nil {
+			gen.err = fmt.Errorf("Type name %s is used by both %v and %v", name, prev, t)
+		}
+		return name
+	}
+	gen.declared[name] = t
+
+	// the slot is reserved before visiting the fields so recursive types work
+	idx := len(gen.interfaces)
+	gen.interfaces = append(gen.interfaces, "")
+	gen.interfaces[idx] = fmt.Sprintf("export interface %s %s\n", name, gen.objectType(t, ""))
+	return name
+}
+
+// Object literal type with the JSON fields of a struct
+func (gen *tsGenerator) objectType(t reflect.Type, indent string) string {
+	var b strings.Builder
+	b.WriteString("{\n")
+	gen.writeFields(&b, t, indent+"  ")
+	b.WriteString(indent + "}")
+	return b.String()
+}
+
+func (gen *tsGenerator) writeFields(b *strings.Builder, t reflect.Type, indent string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		fieldType := field.Type
+		if field.Anonymous && name == "" {
+			// fields of embedded structs are promoted
+			if fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct {
+				gen.writeFields(b, fieldType, indent)
+				continue
+			}
+		}
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		optional := ""
+		if strings.Contains(opts, "omitempty") {
+			optional = "?"
+		}
+
+		fmt.Fprintf(b, "%s%s%s: %s;\n", indent, tsPropertyName(name), optional, gen.tsType(fieldType))
+	}
+}
+
+// Whether a single parameter is sent as an object instead of an array
+func isStructParam(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
+
+func arrayType(elem string) string {
+	if strings.ContainsAny(elem, " |") {
+		return "Array<" + elem + ">"
+	}
+	return elem + "[]"
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	r := []rune(s)
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
+
+// Replace characters not allowed in TypeScript identifiers
+func tsIdentifier(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// Quote property names that are not valid identifiers
+func tsPropertyName(s string) string {
+	if s != "" && tsIdentifier(s) == s && !unicode.IsDigit([]rune(s)[0]) {
+		return s
+	}
+	return fmt.Sprintf("%q", s)
+}
@@ -0,0 +1,84 @@
+package wsjson
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type Nested struct {
+	Items   []AllTypes        `json:"items"`
+	Tags    map[string]string `json:"tags,omitempty"`
+	Next    *Nested           `json:"next"`
+	private int
+	Ignored string `json:"-"`
+}
+
+type NestedService struct{}
+
+func (*NestedService) ApiWalk(n *Nested) ([]*Nested, error) {
+	return nil, nil
+}
+
+func TestGenerateTypeScript(t *testing.T) {
+	var buf bytes.Buffer
+	err := GenerateTypeScript(&buf, &SimpleService{}, &NamedPrefixService{}, &MethodProviderService{}, &NestedService{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	expected := []string{
+		"import { WsJsonConnection } from \"./wsjson-runtime\";",
+		"export interface AllTypes {\n  number: number;\n  name: string;\n  price: number;\n  flag: boolean;\n}",
+		"export interface Nested {\n  items: AllTypes[];\n  tags?: { [key: string]: string };\n  next: Nested;\n}",
+		"export class SimpleServiceClient {",
+		"  echo(arg0: string): Promise<string> {\n    return this.conn.call(\"SimpleService.Echo\", [arg0]);\n  }",
+		"  double(arg0: number, arg1: string, arg2: number, arg3: boolean): Promise<AllTypes> {",
+		"  anObject(params: AllTypes): Promise<number> {\n    return this.conn.call(\"SimpleService.AnObject\", params);\n  }",
+		"  anArray(arg0: string[]): Promise<number> {",
+		"  event(arg0: string): void {\n    this.conn.notify(\"SimpleService.Event\", [arg0]);\n  }",
+		"export class napreClient {",
+		"  fields2Obj(arg0: number, arg1: string, arg2: number, arg3: boolean): Promise<AllTypes> {",
+		"export class methodsClient {",
+		"  secret_of_life(): Promise<number> {\n    return this.conn.call(\"methods.secret_of_life\", []);\n  }",
+		"  walk(params: Nested): Promise<Nested[]> {",
+	}
+	for _, exp := range expected {
+		if !strings.Contains(out, exp) {
+			t.Errorf("Generated code doesn't contain '%s', output:\n%s", exp, out)
+		}
+	}
+
+	if strings.Count(out, "export interface AllTypes") != 1 {
+		t.Errorf("AllTypes should be declared once, output:\n%s", out)
+	}
+
+	if strings.Contains(out, "private:") || strings.Contains(out, "Ignored") {
+		t.Errorf("Unexported and ignored fields should be skipped, output:\n%s", out)
+	}
+
+	// streaming methods
+	buf.Reset()
+	if err := GenerateTypeScript(&buf, &LogService{}); err != nil {
+		t.Fatal(err)
+	}
+	out = buf.String()
+	expected = []string{
+		"import { WsJsonConnection, StreamResult } from \"./wsjson-runtime\";",
+		"  tail(arg0: number, onValue: (value: any) => void): Promise<string> {\n    return this.conn.stream(\"LogService.Tail\", [arg0], onValue);\n  }",
+		"  count(arg0: number, onValue: (value: number) => void): Promise<StreamResult> {",
+		"  follow(onValue: (value: any) => void): Promise<boolean> {",
+	}
+	for _, exp := range expected {
+		if !strings.Contains(out, exp) {
+			t.Errorf("Generated code doesn't contain '%s', output:\n%s", exp, out)
+		}
+	}
+
+	// validations are the same as when registering services
+	err = GenerateTypeScript(&buf, &EmptyService{})
+	if err == nil || !strings.Contains(err.Error(), "No exposed methods found") {
+		t.Errorf("Invalid error for empty service: %v", err)
+	}
+}
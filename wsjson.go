@@ -3,6 +3,7 @@ package wsjson
 import (
 	"log"
 	"net/http"
+	"sync"
 
 	"github.com/gorilla/websocket"
 )
@@ -20,22 +21,79 @@ type WsJson struct {
 	apiFactory ApiFactory
 
 	// websocket upgrader, can be overwriten by the user
-	wsUpgrader *websocket.Upgrader
+	wsUpgrader   *websocket.Upgrader
+	upgraderOnce sync.Once
+
+	// separator between service and method names, "." by default
+	separator string
+	// maps the names of the exposed methods, may be nil
+	nameMapper NameMapper
+
+	// methods shared by all the connections
+	globals     *serviceManager
+	globalsOnce sync.Once
+	// methods of the service types, shared by all the connections
+	registry *registry
+
+	// authenticates the connections, may be nil
+	authenticator Authenticator
+	// decides which methods can be called, may be nil
+	accessPolicy AccessPolicy
+
+	// limits the calls received, nil if there are no limits
+	rateLimits       *RateLimits
+	principalLimiter *principalLimiter
+	hostLimiters     *hostLimiters
+
+	// origins allowed to connect, the same host if empty
+	allowedOrigins  []originPattern
+	allowAllOrigins bool
+
+	// subprotocols accepted, in order of preference
+	protocols []Protocol
+
+	// per-message compression, nil if disabled
+	compression       *Compression
+	compressionTotals compressionCounters
+
+	// receives the measures, may be nil
+	metrics Metrics
+	// creates the spans of the calls, may be nil
+	tracer *Tracer
+	// records the frames of the connections, may be nil
+	recorder *Recorder
+	// handles the notifications to methods not available, may be nil
+	notificationFallback NotificationFallback
+	// connections being served
+	conns connSet
+	// sessions can be resumed when not nil
+	sessionOptions *SessionOptions
+	sessions       sessionSet
+	// requests that aren't websocket upgrades are answered as JSON-RPC over HTTP POST
+	httpFallback bool
+	// size limit of the HTTP POST bodies, defaultMaxPostSize if zero
+	maxPostSize int64
 }
 
-// Get the websocket upgrader
+// Get the websocket upgrader, the default one is created by the first connection
 func (wsj *WsJson) upgrader() *websocket.Upgrader {
-	if wsj.wsUpgrader == nil {
-		wsj.wsUpgrader = &websocket.Upgrader{
-			ReadBufferSize:  defReadBufferSize,
-			WriteBufferSize: defWriteBufferSize,
+	wsj.upgraderOnce.Do(func() {
+		if wsj.wsUpgrader == nil {
+			wsj.wsUpgrader = &websocket.Upgrader{
+				ReadBufferSize:  defReadBufferSize,
+				WriteBufferSize: defWriteBufferSize,
+				CheckOrigin:     wsj.checkOrigin,
+			}
 		}
-	}
+	})
 	return wsj.wsUpgrader
 }
 
-// Set a custom websocket upgrader
+// Set a custom websocket upgrader, the allowed origins are checked when it has no CheckOrigin
 func (wsj *WsJson) SetUpgrader(upgrader *websocket.Upgrader) {
+	if upgrader.CheckOrigin == nil {
+		upgrader.CheckOrigin = wsj.checkOrigin
+	}
 	wsj.wsUpgrader = upgrader
 }
 
@@ -43,35 +101,197 @@ func (wsj *WsJson) SetApiFactory(factory ApiFactory) {
 	wsj.apiFactory = factory
 }
 
+// Set the separator between service and method names, "." by default.
+// Must be called before any method is registered
+func (wsj *WsJson) SetMethodSeparator(separator string) {
+	wsj.separator = separator
+}
+
+// Set the strategy used to name the methods found by prefix,
+// e.g. LowerCamelCase to expose ApiGetUser as getUser
+func (wsj *WsJson) SetNameMapper(mapper NameMapper) {
+	wsj.nameMapper = mapper
+}
+
+// Create a service manager for a connection using the naming options,
+// methods not found in the connection are looked up in the global manager
+func (wsj *WsJson) newServiceManager() *serviceManager {
+	parent := wsj.globalManager()
+	m := wsj.configureManager(newServiceManager())
+	m.parent = parent
+	return m
+}
+
+// Methods shared by all the connections
+func (wsj *WsJson) globalManager() *serviceManager {
+	wsj.globalsOnce.Do(func() {
+		wsj.registry = newRegistry()
+		wsj.globals = wsj.configureManager(newServiceManager())
+		wsj.registerBuiltins(wsj.globals)
+	})
+	return wsj.globals
+}
+
+func (wsj *WsJson) configureManager(m *serviceManager) *serviceManager {
+	if wsj.separator != "" {
+		m.separator = wsj.separator
+	}
+	m.mapName = wsj.nameMapper
+	m.registry = wsj.registry
+	m.policy = wsj.accessPolicy
+	return m
+}
+
+// Set the authenticator used before upgrading the connections,
+// the principal is available to the ApiFactory with PrincipalFromContext(r.Context())
+func (wsj *WsJson) SetAuthenticator(authenticator Authenticator) {
+	wsj.authenticator = authenticator
+}
+
+// Set the policy deciding which methods can be called by each principal, e.g. a RolePolicy.
+// The global services get it when they are already registered, it doesn't create them
+// so the naming options set later still apply
+func (wsj *WsJson) SetAccessPolicy(policy AccessPolicy) {
+	wsj.accessPolicy = policy
+	if wsj.globals != nil {
+		wsj.globals.policy = policy
+	}
+}
+
+// AddService registers a service shared by all the connections,
+// its methods are available even when the ApiFactory doesn't provide any service
+func (wsj *WsJson) AddService(instance interface{}) error {
+	return wsj.globalManager().addService(instance)
+}
+
 // Implementation of net.http.Handler to manage websocket endpoints
 // this method must be registered with http.Handle
 func (wsj *WsJson) Handle(w http.ResponseWriter, r *http.Request) {
-	// Api factory is required
-	if wsj.apiFactory == nil {
-		log.Fatalf("No API factory defined")
-		return // TODO: return error
+	if !wsj.originAllowed(r) {
+		log.Printf("Origin not allowed: %s", r.Header.Get("Origin"))
+		wsj.upgradeFailed(UpgradeFailedOrigin)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
 	}
 
-	apiObjects := wsj.apiFactory(w, r)
-	if apiObjects == nil {
-		// apiFactory should have handled the response
+	if wsj.httpFallback && !websocket.IsWebSocketUpgrade(r) {
+		wsj.servePost(w, r)
 		return
 	}
 
-	// Upgrade connection to websocket
-	conn, err := wsj.upgrader().Upgrade(w, r, nil)
+	protocol, err := wsj.negotiateProtocol(r)
 	if err != nil {
 		log.Println(err)
+		wsj.upgradeFailed(UpgradeFailedProtocol)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	client, err := newWsJsonClient(conn, apiObjects)
+	principal, r, err := wsj.authenticate(w, r)
+	if err != nil {
+		wsj.upgradeFailed(UpgradeFailedAuth)
+		return
+	}
+
+	sessions := wsj.sessionOptions != nil && protocol.Has(FeatureSessions)
+	if sessions {
+		if client, seq := wsj.resumableSession(r, principal, protocol); client != nil {
+			wsj.resumeSession(w, r, client, seq)
+			return
+		}
+	}
+
+	// Api factory is optional when there are global services
+	var apiObjects []interface{}
+	if wsj.apiFactory != nil {
+		apiObjects = wsj.apiFactory(w, r)
+		if apiObjects == nil {
+			// apiFactory should have handled the response
+			wsj.upgradeFailed(UpgradeFailedFactory)
+			return
+		}
+	}
+
+	client, err := newWsJsonClient(wsj.newServiceManager(), nil, apiObjects)
 	if err != nil {
 		log.Printf("Error creating client: %v\n", err)
+		wsj.upgradeFailed(UpgradeFailedInternal)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
+	var session *connSession
+	if sessions {
+		if session, err = newConnSession(wsj.sessionOptions); err != nil {
+			log.Printf("Error creating session: %v\n", err)
+			wsj.upgradeFailed(UpgradeFailedInternal)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	conn, compression, err := wsj.upgrade(w, r, protocol)
+	if err != nil {
+		return
+	}
+
+	client.conn = conn
+	client.protocol = protocol
+	client.compression = compression
+	client.limiter = wsj.newConnLimiter()
+	client.SetPrincipal(principal)
+	client.metrics = wsj.metrics
+	wsj.configureClient(client)
+	if session != nil {
+		client.session = session
+		wsj.sessions.add(client)
+	}
+	client.onClose = func() {
+		wsj.conns.remove(client)
+		if client.session != nil {
+			wsj.sessions.remove(client)
+		}
+	}
+	wsj.conns.add(client)
+	if wsj.metrics != nil {
+		wsj.metrics.ConnectionOpened()
+	}
 	client.serve()
 
 }
+
+// Authenticate a request, the principal is added to its context.
+// Failures are already answered
+func (wsj *WsJson) authenticate(w http.ResponseWriter, r *http.Request) (Principal, *http.Request, error) {
+	if wsj.authenticator == nil {
+		return nil, r, nil
+	}
+	principal, err := wsj.authenticator.Authenticate(r)
+	if err != nil {
+		log.Printf("Authentication failed: %v", err)
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return nil, r, err
+	}
+	if principal != nil {
+		r = r.WithContext(withPrincipal(r.Context(), principal))
+	}
+	return principal, r, nil
+}
+
+// Upgrade the connection to websocket, failures are already answered
+func (wsj *WsJson) upgrade(w http.ResponseWriter, r *http.Request, protocol *Protocol) (*websocket.Conn, *connCompression, error) {
+	compression, w := wsj.newConnCompression(w, r)
+	conn, err := wsj.upgrader().Upgrade(w, r, protocolHeader(r, protocol))
+	if err != nil {
+		log.Println(err)
+		wsj.upgradeFailed(UpgradeFailedUpgrade)
+		return nil, nil, err
+	}
+	if compression != nil && compression.options.Level != 0 {
+		if err := conn.SetCompressionLevel(compression.options.Level); err != nil {
+			log.Printf("Error setting compression level: %v", err)
+		}
+	}
+	return conn, compression, nil
+}
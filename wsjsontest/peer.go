@@ -0,0 +1,166 @@
+package wsjsontest
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/manologab/wsjson"
+)
+
+// Answers a call of the server to a scripted peer, errors other than *wsjson.Error
+// are sent as ErrorInternalError
+type PeerHandler func(params json.RawMessage) (interface{}, error)
+
+// A fake peer speaking raw JSON-RPC, the messages it receives are checked one by one.
+// The calls of the server to methods with a handler are answered as they arrive
+type Peer struct {
+	t        testing.TB
+	conn     *websocket.Conn
+	nextId   int
+	handlers map[string]PeerHandler
+}
+
+// Peer connects a scripted peer to the server
+func (s *Server) Peer(header http.Header) *Peer {
+	s.t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(s.URL, header)
+	if err != nil {
+		s.t.Fatalf("Error connecting to %s: %v", s.URL, err)
+	}
+	s.t.Cleanup(func() { conn.Close() })
+	return &Peer{t: s.t, conn: conn, handlers: make(map[string]PeerHandler)}
+}
+
+// On answers the calls of the server to method with handler
+func (p *Peer) On(method string, handler PeerHandler) {
+	p.handlers[method] = handler
+}
+
+// Send a raw message
+func (p *Peer) Send(message string) {
+	p.t.Helper()
+	if err := p.conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+		p.t.Fatalf("Error sending message: %v", err)
+	}
+}
+
+func (p *Peer) sendJSON(message interface{}) {
+	p.t.Helper()
+	if err := p.conn.WriteJSON(message); err != nil {
+		p.t.Fatalf("Error sending message: %v", err)
+	}
+}
+
+// Call sends a call and returns its id, see ExpectResult
+func (p *Peer) Call(method string, params interface{}) int {
+	p.t.Helper()
+	p.nextId++
+	p.sendJSON(map[string]interface{}{"jsonrpc": wsjson.JSONRPCVersion, "method": method, "params": params, "id": p.nextId})
+	return p.nextId
+}
+
+// Notify sends a notification
+func (p *Peer) Notify(method string, params interface{}) {
+	p.t.Helper()
+	p.sendJSON(map[string]interface{}{"jsonrpc": wsjson.JSONRPCVersion, "method": method, "params": params})
+}
+
+// Receive waits for the next message not answered by a handler
+func (p *Peer) Receive() *wsjson.Request {
+	p.t.Helper()
+	for {
+		p.conn.SetReadDeadline(time.Now().Add(Timeout))
+		var msg wsjson.Request
+		if err := p.conn.ReadJSON(&msg); err != nil {
+			p.t.Fatalf("Error receiving message: %v", err)
+		}
+		handler, ok := p.handlers[msg.Method]
+		if !ok || msg.Id == nil {
+			return &msg
+		}
+		result, err := handler(json.RawMessage(msg.Params))
+		if err != nil {
+			p.ReplyError(&msg, err)
+		} else {
+			p.Reply(&msg, result)
+		}
+	}
+}
+
+// ExpectResult expects the next message to be the response to the call with the id,
+// its result is compared with expected once decoded into its type
+func (p *Peer) ExpectResult(id int, expected interface{}) {
+	p.t.Helper()
+	msg := p.expectResponse(id)
+	got := decodeLike(p.t, wsjson.JSONCodec, msg.Result, expected, errOrNil(msg.Err), "call "+toJSON(id))
+	if !reflect.DeepEqual(got, expected) {
+		p.t.Errorf("Invalid result of call %d, expected: %#v, got: %#v", id, expected, got)
+	}
+}
+
+// ExpectError expects the next message to be the response to the call with the id, failed with code
+func (p *Peer) ExpectError(id int, code int) *wsjson.Error {
+	p.t.Helper()
+	msg := p.expectResponse(id)
+	if msg.Err == nil || msg.Err.Code != code {
+		p.t.Errorf("Call %d should fail with error %d, got: %s", id, code, msg)
+	}
+	return msg.Err
+}
+
+func (p *Peer) expectResponse(id int) *wsjson.Request {
+	p.t.Helper()
+	msg := p.Receive()
+	if msg.Method != "" || toJSON(msg.Id) != toJSON(id) {
+		p.t.Fatalf("Response to call %d expected, got: %s", id, msg)
+	}
+	return msg
+}
+
+// ExpectCall expects the next message to be a call or notification to method
+func (p *Peer) ExpectCall(method string) *wsjson.Request {
+	p.t.Helper()
+	msg := p.Receive()
+	if msg.Method != method {
+		p.t.Fatalf("Call to %s expected, got: %s", method, msg)
+	}
+	return msg
+}
+
+// Reply to a call of the server
+func (p *Peer) Reply(call *wsjson.Request, result interface{}) {
+	p.t.Helper()
+	p.sendJSON(map[string]interface{}{"jsonrpc": wsjson.JSONRPCVersion, "result": result, "id": call.Id})
+}
+
+// ReplyError answers a call of the server with an error
+func (p *Peer) ReplyError(call *wsjson.Request, err error) {
+	p.t.Helper()
+	rpcErr, ok := err.(*wsjson.Error)
+	if !ok {
+		rpcErr = wsjson.NewError(wsjson.ErrorInternalError, "%s", err.Error())
+	}
+	p.sendJSON(map[string]interface{}{"jsonrpc": wsjson.JSONRPCVersion, "error": rpcErr, "id": call.Id})
+}
+
+// Close the connection of the peer
+func (p *Peer) Close() {
+	p.conn.Close()
+}
+
+func errOrNil(err *wsjson.Error) error {
+	if err == nil {
+		return nil
+	}
+	return err
+}
+
+// Ids as JSON, numbers decoded as float64 match the ints sent
+func toJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,239 @@
+// Package wsjsontest provides an in-process wsjson server, ready-made clients and
+// scripted peers to test services.
+//
+//	func TestEcho(t *testing.T) {
+//		server := wsjsontest.NewServer(t, &EchoService{})
+//		client := server.Client()
+//		client.Call("EchoService.Echo", []string{"hi"}).Expect("hi")
+//		client.Call("EchoService.Missing", nil).ExpectError(wsjson.ErrorMethodNotFound)
+//	}
+package wsjsontest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manologab/wsjson"
+)
+
+// Time waited for the responses and messages expected
+var Timeout = 5 * time.Second
+
+// A wsjson handler served by an httptest server, closed when the test ends
+type Server struct {
+	// Handler being tested, it can be configured before the first connection
+	WsJson *wsjson.WsJson
+	HTTP   *httptest.Server
+	// Websocket URL of the server
+	URL string
+	t   testing.TB
+}
+
+// NewServer serves the services as global services of a new handler
+func NewServer(t testing.TB, services ...interface{}) *Server {
+	t.Helper()
+	wsj := &wsjson.WsJson{}
+	for _, service := range services {
+		if err := wsj.AddService(service); err != nil {
+			t.Fatalf("Error adding service %T: %v", service, err)
+		}
+	}
+	return Serve(t, wsj)
+}
+
+// Serve a configured handler
+func Serve(t testing.TB, wsj *wsjson.WsJson) *Server {
+	server := httptest.NewServer(http.HandlerFunc(wsj.Handle))
+	t.Cleanup(server.Close)
+	return &Server{
+		WsJson: wsj,
+		HTTP:   server,
+		URL:    "ws" + strings.TrimPrefix(server.URL, "http"),
+		t:      t,
+	}
+}
+
+// A notification received by a client that no service handles
+type Event struct {
+	Method string
+	Params wsjson.RawMessage
+}
+
+// Client connected to the server, closed when the test ends.
+// The notifications not handled by its services are kept as events
+type Client struct {
+	*wsjson.WsJsonClient
+	t      testing.TB
+	events chan Event
+}
+
+// Client connects a client, the server can call the services given
+func (s *Server) Client(services ...interface{}) *Client {
+	s.t.Helper()
+	return s.ClientWithHeader(nil, services...)
+}
+
+// ClientWithHeader connects a client with the header of the upgrade request, e.g. to authenticate it
+func (s *Server) ClientWithHeader(header http.Header, services ...interface{}) *Client {
+	s.t.Helper()
+	c := &Client{t: s.t, events: make(chan Event, 100)}
+
+	wsj := &wsjson.WsJson{}
+	for _, service := range services {
+		if err := wsj.AddService(service); err != nil {
+			s.t.Fatalf("Error adding service %T: %v", service, err)
+		}
+	}
+	wsj.SetNotificationFallback(func(ctx context.Context, method string, params wsjson.RawMessage) {
+		select {
+		case c.events <- Event{Method: method, Params: params}:
+		default:
+			s.t.Errorf("Too many events not expected, dropped %s", method)
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	client, err := wsj.Dial(ctx, s.URL, header)
+	if err != nil {
+		s.t.Fatalf("Error connecting to %s: %v", s.URL, err)
+	}
+	s.t.Cleanup(func() { client.Close() })
+	c.WsJsonClient = client
+	return c
+}
+
+// Result of a call made by a client
+type Result struct {
+	t      testing.TB
+	method string
+	codec  wsjson.Codec
+	raw    wsjson.RawMessage
+	err    error
+}
+
+// Call a method of the server and wait for its response
+func (c *Client) Call(method string, params interface{}) *Result {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	r := &Result{t: c.t, method: method, codec: c.codec()}
+	r.err = c.WsJsonClient.Call(ctx, method, params, &r.raw)
+	return r
+}
+
+// Notify sends a notification to the server
+func (c *Client) Notify(method string, params interface{}) {
+	c.t.Helper()
+	if err := c.SendEvent(method, params); err != nil {
+		c.t.Fatalf("Error sending %s: %v", method, err)
+	}
+}
+
+func (c *Client) codec() wsjson.Codec {
+	if codec := c.Protocol().Codec; codec != nil {
+		return codec
+	}
+	return wsjson.JSONCodec
+}
+
+// Error of the call, a *wsjson.Error when sent by the server
+func (r *Result) Err() error {
+	return r.err
+}
+
+// Decode the result, the test fails if the call failed
+func (r *Result) Decode(v interface{}) {
+	r.t.Helper()
+	if r.err != nil {
+		r.t.Fatalf("Call to %s failed: %v", r.method, r.err)
+	}
+	if err := r.codec.Unmarshal(r.raw, v); err != nil {
+		r.t.Fatalf("Error decoding the result of %s: %v", r.method, err)
+	}
+}
+
+// Expect the result to be equal to expected once decoded into its type
+func (r *Result) Expect(expected interface{}) {
+	r.t.Helper()
+	got := decodeLike(r.t, r.codec, r.raw, expected, r.err, r.method)
+	if !reflect.DeepEqual(got, expected) {
+		r.t.Errorf("Invalid result of %s, expected: %#v, got: %#v", r.method, expected, got)
+	}
+}
+
+// ExpectError expects the call to fail with an error of the given code
+func (r *Result) ExpectError(code int) *wsjson.Error {
+	r.t.Helper()
+	rpcErr, ok := r.err.(*wsjson.Error)
+	if !ok {
+		r.t.Errorf("Call to %s should fail with error %d, got: %v", r.method, code, r.err)
+		return nil
+	}
+	if rpcErr.Code != code {
+		r.t.Errorf("Call to %s should fail with error %d, got: %d %s", r.method, code, rpcErr.Code, rpcErr.Message)
+	}
+	return rpcErr
+}
+
+// Decode a message into a new value of the type of like, the test fails on errors
+func decodeLike(t testing.TB, codec wsjson.Codec, raw wsjson.RawMessage, like interface{}, err error, name string) interface{} {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s failed: %v", name, err)
+	}
+	if like == nil {
+		var v interface{}
+		if err := codec.Unmarshal(raw, &v); err != nil {
+			t.Fatalf("Error decoding %s: %v", name, err)
+		}
+		return v
+	}
+	ptr := reflect.New(reflect.TypeOf(like))
+	if err := codec.Unmarshal(raw, ptr.Interface()); err != nil {
+		t.Fatalf("Error decoding %s: %v", name, err)
+	}
+	return ptr.Elem().Interface()
+}
+
+// NextEvent waits for the next event of the given method, the events of other methods before it are discarded
+func (c *Client) NextEvent(method string) Event {
+	c.t.Helper()
+	timeout := time.After(Timeout)
+	for {
+		select {
+		case event := <-c.events:
+			if event.Method == method {
+				return event
+			}
+		case <-timeout:
+			c.t.Fatalf("Event %s not received", method)
+			return Event{}
+		}
+	}
+}
+
+// ExpectEvent waits for the next event of the given method and compares its params,
+// decoded into the type of params
+func (c *Client) ExpectEvent(method string, params interface{}) {
+	c.t.Helper()
+	event := c.NextEvent(method)
+	got := decodeLike(c.t, c.codec(), event.Params, params, nil, "event "+method)
+	if !reflect.DeepEqual(got, params) {
+		c.t.Errorf("Invalid params of event %s, expected: %#v, got: %#v", method, params, got)
+	}
+}
+
+// ExpectNoEvent fails the test if an event is received during d
+func (c *Client) ExpectNoEvent(d time.Duration) {
+	c.t.Helper()
+	select {
+	case event := <-c.events:
+		c.t.Errorf("Event not expected: %s %s", event.Method, event.Params)
+	case <-time.After(d):
+	}
+}
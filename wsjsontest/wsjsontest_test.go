@@ -0,0 +1,112 @@
+package wsjsontest
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/manologab/wsjson"
+)
+
+type Point struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type ChatService struct{}
+
+func (*ChatService) ApiEcho(message string) (string, error) {
+	return message, nil
+}
+
+func (*ChatService) ApiMove(p Point) (*Point, error) {
+	if p.X < 0 {
+		return nil, wsjson.NewError(wsjson.ErrorInvalidParams, "Negative x")
+	}
+	return &Point{p.X + 1, p.Y + 1}, nil
+}
+
+// Broadcasts a message back to the caller as an event
+func (*ChatService) ApiSay(ctx context.Context, message string) (bool, error) {
+	return true, wsjson.ClientFromContext(ctx).SendEvent("chat.message", map[string]string{"text": message})
+}
+
+// Asks the name of the peer
+func (*ChatService) ApiHello(ctx context.Context) (string, error) {
+	var name string
+	if err := wsjson.ClientFromContext(ctx).Call(ctx, "Peer.Name", nil, &name); err != nil {
+		return "", err
+	}
+	return "Hello " + name, nil
+}
+
+type NameService struct{}
+
+func (*NameService) WsName() string {
+	return "Peer"
+}
+
+func (*NameService) ApiName() (string, error) {
+	return "ana", nil
+}
+
+func TestClient(t *testing.T) {
+	server := NewServer(t, &ChatService{})
+	client := server.Client()
+
+	client.Call("ChatService.Echo", []string{"hi"}).Expect("hi")
+	client.Call("ChatService.Move", Point{1, 2}).Expect(Point{2, 3})
+	client.Call("ChatService.Move", &Point{1, 2}).Expect(&Point{2, 3})
+	client.Call("ChatService.Missing", nil).ExpectError(wsjson.ErrorMethodNotFound)
+	if err := client.Call("ChatService.Move", Point{-1, 0}).ExpectError(wsjson.ErrorInvalidParams); err == nil || err.Message != "Negative x" {
+		t.Errorf("Invalid error: %v", err)
+	}
+
+	var p Point
+	client.Call("ChatService.Move", Point{5, 5}).Decode(&p)
+	if p != (Point{6, 6}) {
+		t.Errorf("Invalid decoded result: %v", p)
+	}
+
+	client.Call("ChatService.Say", []string{"hola"}).Expect(true)
+	client.ExpectEvent("chat.message", map[string]string{"text": "hola"})
+	client.ExpectNoEvent(20 * time.Millisecond)
+}
+
+func TestClientServices(t *testing.T) {
+	server := NewServer(t, &ChatService{})
+	client := server.Client(&NameService{})
+	client.Call("ChatService.Hello", nil).Expect("Hello ana")
+}
+
+func TestPeer(t *testing.T) {
+	server := NewServer(t, &ChatService{})
+
+	peer := server.Peer(nil)
+	id := peer.Call("ChatService.Move", Point{1, 1})
+	peer.ExpectResult(id, Point{2, 2})
+	id = peer.Call("ChatService.Echo", 3)
+	peer.ExpectError(id, wsjson.ErrorInvalidParams)
+
+	// answered manually
+	id = peer.Call("ChatService.Hello", nil)
+	call := peer.ExpectCall("Peer.Name")
+	peer.Reply(call, "bob")
+	peer.ExpectResult(id, "Hello bob")
+
+	// answered by a script
+	peer.On("Peer.Name", func(params json.RawMessage) (interface{}, error) {
+		return "eve", nil
+	})
+	id = peer.Call("ChatService.Hello", nil)
+	peer.ExpectResult(id, "Hello eve")
+
+	peer.On("Peer.Name", func(params json.RawMessage) (interface{}, error) {
+		return nil, wsjson.NewError(wsjson.ErrorForbidden, "No names")
+	})
+	id = peer.Call("ChatService.Hello", nil)
+	if err := peer.ExpectError(id, wsjson.ErrorForbidden); err == nil || err.Message != "No names" {
+		t.Errorf("The error of the peer should be returned: %v", err)
+	}
+}